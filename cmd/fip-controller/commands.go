@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/cbeneke/hcloud-fip-controller/internal/app/fipcontroller"
+)
+
+// Subcommands of the binary. Without a subcommand the controller is run.
+const (
+	commandPlan   = "plan"
+	commandStatus = "status"
+	commandMove   = "move"
+	commandPin    = "pin"
+	commandUnpin  = "unpin"
+	// commandAgent runs the node agent instead of the controller, e.g. as DaemonSet
+	commandAgent = "agent"
+)
+
+// commandConfiguration holds the flags only used by subcommands
+type commandConfiguration struct {
+	planOutput string
+	ip         string
+	node       string
+}
+
+// flagSet returns the flags of the subcommand. Unlike the controller flags they are only read from the command
+// line, not from environment variables or the config file.
+func (config *commandConfiguration) flagSet(command string) *flag.FlagSet {
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	switch command {
+	case commandPlan:
+		flags.StringVar(&config.planOutput, "plan-output", fipcontroller.PlanOutputTable, "Output format of the plan. One of table, json")
+	case commandMove, commandPin:
+		flags.StringVar(&config.ip, "ip", "", "IP address to "+command)
+		flags.StringVar(&config.node, "node", "", "Kubernetes node name to "+command+" the IP address to")
+	case commandUnpin:
+		flags.StringVar(&config.ip, "ip", "", "IP address to unpin")
+	}
+	return flags
+}
+
+// splitCommandArgs separates the flags of the subcommand from the controller flags, so both can be given after
+// the subcommand in any order. All flags of the subcommands take a value.
+func splitCommandArgs(flags *flag.FlagSet, args []string) (commandArgs []string, controllerArgs []string) {
+	for i := 0; i < len(args); i++ {
+		name := strings.TrimLeft(args[i], "-")
+		name, _, hasValue := strings.Cut(name, "=")
+		if !strings.HasPrefix(args[i], "-") || flags.Lookup(name) == nil {
+			controllerArgs = append(controllerArgs, args[i])
+			continue
+		}
+		commandArgs = append(commandArgs, args[i])
+		if !hasValue && i+1 < len(args) {
+			i++
+			commandArgs = append(commandArgs, args[i])
+		}
+	}
+	return commandArgs, controllerArgs
+}
+
+// validate checks that the subcommand exists and got the flags it needs
+func (config *commandConfiguration) validate(command string) error {
+	switch command {
+	case "", commandStatus, commandAgent:
+		return nil
+	case commandPlan:
+		if config.planOutput != fipcontroller.PlanOutputTable && config.planOutput != fipcontroller.PlanOutputJSON {
+			return fmt.Errorf("unknown plan output '%s', must be one of %s, %s", config.planOutput, fipcontroller.PlanOutputTable, fipcontroller.PlanOutputJSON)
+		}
+		return nil
+	case commandMove, commandPin:
+		if config.ip == "" || config.node == "" {
+			return fmt.Errorf("%s needs --ip and --node", command)
+		}
+		return nil
+	case commandUnpin:
+		if config.ip == "" {
+			return fmt.Errorf("%s needs --ip", command)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown command '%s', must be one of %s, %s, %s, %s, %s, %s", command, commandPlan, commandStatus, commandMove, commandPin, commandUnpin, commandAgent)
+	}
+}
+
+// runCommand runs a single subcommand against the cluster and exits. Logs are written to stderr to keep the
+// output machine readable.
+func runCommand(ctx context.Context, controller *fipcontroller.Controller, command string, config *commandConfiguration) error {
+	controller.Logger.SetOutput(os.Stderr)
+
+	switch command {
+	case commandPlan:
+		entries, err := controller.Plan(ctx)
+		if err != nil {
+			return err
+		}
+		return fipcontroller.WritePlan(os.Stdout, entries, config.planOutput)
+	case commandStatus:
+		entries, err := controller.Status(ctx)
+		if err != nil {
+			return err
+		}
+		return fipcontroller.WriteStatus(os.Stdout, entries)
+	case commandMove:
+		return controller.Move(ctx, config.ip, config.node)
+	case commandPin:
+		return controller.Pin(ctx, config.ip, config.node)
+	case commandUnpin:
+		return controller.Unpin(ctx, config.ip)
+	}
+	return nil
+}
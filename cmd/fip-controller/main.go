@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/namsral/flag"
@@ -18,12 +19,26 @@ var version = "dev"
 func main() {
 	controllerConfig := &configuration.Configuration{}
 
+	// Subcommands run a single operation instead of the controller. Their flags follow the subcommand
+	command := ""
+	args := os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+	commandConfig := &commandConfiguration{}
+	commandFlags := commandConfig.flagSet(command)
+	commandArgs, args := splitCommandArgs(commandFlags, args)
+
 	// Set defaults for flag.Var values
 	controllerConfig.NodeAddressType = configuration.NodeAddressTypeExternal
 
 	// Parse flags
 	flag.Var(&controllerConfig.HcloudFloatingIPs, "hcloud-floating-ip", "Hetzner cloud floating IP Address. This option can be specified multiple times")
 	flag.Var(&controllerConfig.NodeAddressType, "node-address-type", "Kubernetes node address type")
+	flag.Var(&controllerConfig.ServerWeights, "server-weights", "Server weights for the weighted assignment strategy in the form <server>=<weight>. This option can be specified multiple times")
+	flag.Var(&controllerConfig.AliasIPs, "alias-ip", "Virtual IP inside the alias IP network which is moved between the alias IPs of the servers. This option can be specified multiple times")
+	flag.Var(&controllerConfig.EvacuateTaints, "evacuate-taint", "Taint in the form key[=value][:effect]. IPs are moved off nodes with a matching taint. This option can be specified multiple times")
+	flag.Var(&controllerConfig.ServerPreferences, "server-preferences", "Ordered server names for the ordered assignment strategy. This option can be specified multiple times")
 
 	flag.StringVar(&controllerConfig.HcloudAPIToken, "hcloud-api-token", "", "Hetzner cloud API token")
 	flag.IntVar(&controllerConfig.LeaseDuration, "lease-duration", 15, "Time to wait (in seconds) until next leader check")
@@ -35,11 +50,36 @@ func main() {
 	flag.StringVar(&controllerConfig.LogLevel, "log-level", "Info", "Log level")
 	flag.StringVar(&controllerConfig.FloatingIPLabelSelector, "floating-ip-label-selector", "", "Selector for Floating IPs")
 	flag.StringVar(&controllerConfig.NodeLabelSelector, "node-label-selector", "", "Selector for Nodes")
+	flag.StringVar(&controllerConfig.ServerLabelSelector, "server-label-selector", "", "Selector for hcloud servers. All servers are used when empty")
+	flag.DurationVar(&controllerConfig.HcloudCacheTTL, "hcloud-cache-ttl", time.Minute, "Duration hcloud servers and IPs are cached between reconciliations. Caching is disabled when 0")
+	flag.IntVar(&controllerConfig.HcloudRateLimitHeadroom, "hcloud-rate-limit-headroom", 100, "Number of hcloud API requests kept for assigning IPs. Other requests are slowed down once the remaining rate limit drops to it")
 	flag.StringVar(&controllerConfig.PodLabelSelector, "pod-label-selector", "", "Selector for Pods. Should be the same key as specified in deployment")
 	flag.DurationVar(&controllerConfig.BackoffDuration, "backoff-duration", time.Second, "Duration for first backoff")
 	flag.Float64Var(&controllerConfig.BackoffFactor, "backoff-factor", 1.2, "Factor for backoff increase")
 	flag.IntVar(&controllerConfig.BackoffSteps, "backoff-steps", 5, "Number of backoff retries")
 	flag.StringVar(&controllerConfig.HealthCheckAddress, "health-check-address", ":8080", "Address the health, readiness and metrics endpoints listen on")
+	flag.StringVar(&controllerConfig.AssignmentStrategy, "assignment-strategy", "least-loaded", "Strategy used to choose the server for a floating IP. One of least-loaded, random, weighted, ordered")
+	flag.BoolVar(&controllerConfig.Rebalance, "rebalance", false, "Move floating IPs between running servers to even out their distribution")
+	flag.IntVar(&controllerConfig.RebalanceThreshold, "rebalance-threshold", 1, "Maximum difference in floating IPs between the most and least loaded server before rebalancing")
+	flag.DurationVar(&controllerConfig.RebalanceInterval, "rebalance-interval", 5*time.Minute, "Minimum time between two rebalancing moves")
+	flag.IntVar(&controllerConfig.UnhealthyThreshold, "unhealthy-threshold", 1, "Number of consecutive reconciliations, one per resync interval, a server must be unhealthy before its floating IPs are moved")
+	flag.DurationVar(&controllerConfig.UnhealthyDuration, "unhealthy-duration", 0, "Duration a server must be unhealthy before its floating IPs are moved")
+	flag.DurationVar(&controllerConfig.TakeoverGracePeriod, "takeover-grace-period", 0, "Duration a newly elected leader waits before moving assigned floating IPs")
+	flag.DurationVar(&controllerConfig.ResyncInterval, "resync-interval", 30*time.Second, "Maximum time between two reconciliations. Node and pod changes trigger a reconciliation right away")
+	flag.BoolVar(&controllerConfig.ServiceIPAM, "service-ipam", false, "Allocate floating IPs to services of type LoadBalancer")
+	flag.StringVar(&controllerConfig.LoadBalancerClass, "load-balancer-class", "", "Only handle services of type LoadBalancer with this load balancer class in service IPAM mode")
+	flag.BoolVar(&controllerConfig.FollowServiceAnnotations, "follow-service-annotations", false, "Route floating IPs listed in the fip.hcloud/floating-ips annotation of a service to nodes with ready endpoints of that service")
+	flag.StringVar(&controllerConfig.PrimaryIPLabelSelector, "primary-ip-label-selector", "", "Selector for primary IPs managed by the controller. Primary IPs are not managed when empty")
+	flag.BoolVar(&controllerConfig.PrimaryIPPowerOff, "primary-ip-power-off", false, "Power off servers to move primary IPs between them")
+	flag.StringVar(&controllerConfig.AliasIPNetwork, "alias-ip-network", "", "Name or ID of the hcloud network the alias IPs belong to")
+	flag.BoolVar(&controllerConfig.EvacuateUnschedulable, "evacuate-unschedulable", false, "Move IPs off cordoned nodes")
+	flag.BoolVar(&controllerConfig.EvacuateNoExecute, "evacuate-no-execute", false, "Move IPs off nodes with a NoExecute taint")
+	flag.StringVar(&controllerConfig.FollowService, "follow-service", "", "Route all floating IPs to nodes with ready endpoints of this service in the form <namespace>/<name>")
+	flag.BoolVar(&controllerConfig.FloatingIPPools, "floating-ip-pools", false, "Manage floating IPs through FloatingIPPool objects instead of the floating IP options")
+	flag.BoolVar(&controllerConfig.AssignmentObjects, "assignment-objects", false, "Maintain a FloatingIPAssignment object with the live state of every managed IP")
+	flag.BoolVar(&controllerConfig.NodeLabels, "node-labels", false, "Label nodes holding managed IPs with fip.hcloud/holder=true and annotate them with the held IPs")
+	flag.StringVar(&controllerConfig.AgentInterface, "agent-interface", "eth0", "Host network interface the agent configures the floating IPs of its node on")
+	flag.BoolVar(&controllerConfig.DryRun, "dry-run", false, "Only log, trace and count the IP changes the controller would make instead of performing them")
 	flag.StringVar(&controllerConfig.OtelExporterOtlpEndpoint, "otel-exporter-otlp-endpoint", "", "OTLP endpoint for OpenTelemetry traces. Traces are only emitted when set")
 	// Parse options from file
 	if _, err := os.Stat("config/config.json"); err == nil {
@@ -50,7 +90,18 @@ func main() {
 	}
 
 	// When default- and file-configs are read, parse command line options with highest priority
-	flag.Parse()
+	flag.CommandLine.Parse(args)
+	commandFlags.Parse(commandArgs)
+
+	if err := commandConfig.validate(command); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if command == commandAgent {
+		runAgent(controllerConfig)
+		return
+	}
 
 	controller, err := fipcontroller.NewController(controllerConfig)
 	if err != nil {
@@ -61,6 +112,14 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	if command != "" {
+		if err := runCommand(ctx, controller, command, commandConfig); err != nil {
+			fmt.Fprintln(os.Stderr, fmt.Errorf("could not run %s: %v", command, err))
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Initialise OpenTelemetry tracing. Traces are only emitted when an OTLP
 	// endpoint is configured; otherwise this is a no-op.
 	shutdownTracing, err := fipcontroller.InitTracing(ctx, controllerConfig.OtelExporterOtlpEndpoint, "hcloud-fip-controller", version)
@@ -86,3 +145,28 @@ func main() {
 
 	controller.RunWithLeaderElection(ctx)
 }
+
+// runAgent runs the node agent until it is stopped. The agent reports ready after its first successful
+// reconciliation.
+func runAgent(config *configuration.Configuration) {
+	agent, err := fipcontroller.NewAgent(config)
+	if err != nil {
+		fmt.Println(fmt.Errorf("could not initialise agent: %v", err))
+		os.Exit(1)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	healthServer := fipcontroller.NewHealthServer(config.HealthCheckAddress, agent.Logger)
+	agent.HealthServer = healthServer
+	go func() {
+		if err := healthServer.Run(ctx); err != nil {
+			agent.Logger.Errorf("health server stopped: %v", err)
+		}
+	}()
+
+	if err := agent.RunAgent(ctx); err != nil {
+		agent.Logger.Fatalf("Could not run agent: %v", err)
+	}
+}
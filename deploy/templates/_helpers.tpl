@@ -47,6 +47,15 @@ app.kubernetes.io/instance: {{ .Release.Name }}
 {{- end -}}
 {{- end -}}
 
+{{/* Service account name of the node agent */}}
+{{- define "hcloud-fip-controller.agentServiceAccountName" -}}
+{{- if .Values.serviceAccount.create -}}
+{{- default (printf "%s-agent" (include "hcloud-fip-controller.fullname" .)) .Values.agent.serviceAccountName -}}
+{{- else -}}
+{{- default "default" .Values.agent.serviceAccountName -}}
+{{- end -}}
+{{- end -}}
+
 {{/* Secret name holding the Hetzner Cloud API token */}}
 {{- define "hcloud-fip-controller.secretName" -}}
 {{- if .Values.existingSecretName -}}
@@ -61,3 +70,9 @@ app.kubernetes.io/instance: {{ .Release.Name }}
 {{- $tag := .Values.image.tag | default (printf "v%s" .Chart.AppVersion) -}}
 {{- printf "%s:%s" .Values.image.repository $tag -}}
 {{- end -}}
+
+{{/* Selector labels of the node agent, distinct so the controller does not select the agent pods */}}
+{{- define "hcloud-fip-controller.agentSelectorLabels" -}}
+app.kubernetes.io/name: {{ include "hcloud-fip-controller.name" . }}-agent
+app.kubernetes.io/instance: {{ .Release.Name }}
+{{- end -}}
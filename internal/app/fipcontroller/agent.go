@@ -0,0 +1,325 @@
+package fipcontroller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/cbeneke/hcloud-fip-controller/internal/pkg/configuration"
+)
+
+// annotationConfiguredNode on FloatingIPAssignment objects is set by the agent which configured the IP on the
+// interface of its node. The leader reports it as configured while it matches the node of the assignment.
+const annotationConfiguredNode = "fip.hcloud/configured-node"
+
+// triggerAssignment is the trigger of agent reconciliations caused by changed FloatingIPAssignments
+const triggerAssignment = "assignment"
+
+// Link manages the addresses of a network interface of the host
+type Link interface {
+	// Addresses returns all addresses configured on the interface
+	Addresses() ([]*net.IPNet, error)
+	// AgentAddresses returns the addresses on the interface which were added by AddAddress, also by earlier
+	// runs of the agent
+	AgentAddresses() ([]*net.IPNet, error)
+	// AddAddress configures the address on the interface and marks it as added by the agent. Adding a
+	// configured address is no error.
+	AddAddress(address *net.IPNet) error
+	// RemoveAddress removes the address from the interface. Removing a missing address is no error.
+	RemoveAddress(address *net.IPNet) error
+}
+
+// NewAgent creates a Controller running in agent mode. The agent runs on every node and configures the floating
+// IPs assigned to its node on the host interface, it does not need access to the hetzner cloud API.
+func NewAgent(config *configuration.Configuration) (*Controller, error) {
+	if err := config.ValidateAgent(); err != nil {
+		return nil, fmt.Errorf("agent config invalid: %w", err)
+	}
+
+	kubeConfig, err := newKubeConfig()
+	if err != nil {
+		return nil, fmt.Errorf("could not initialise kubernetes client: %w", err)
+	}
+	kubernetesClient, err := newKubernetesClient(kubeConfig)
+	if err != nil {
+		return nil, fmt.Errorf("could not initialise kubernetes client: %w", err)
+	}
+	dynamicClient, err := newDynamicClient(kubeConfig)
+	if err != nil {
+		return nil, fmt.Errorf("could not initialise kubernetes client: %w", err)
+	}
+
+	logger, err := newLogger(config)
+	if err != nil {
+		return nil, err
+	}
+
+	link, err := newLink(config.AgentInterface)
+	if err != nil {
+		return nil, fmt.Errorf("could not initialise interface '%s': %w", config.AgentInterface, err)
+	}
+
+	return &Controller{
+		KubernetesClient: kubernetesClient,
+		DynamicClient:    dynamicClient,
+		Configuration:    config,
+		Logger:           logger,
+		Backoff:          newBackoff(config),
+		Link:             link,
+	}, nil
+}
+
+// RunAgent configures the floating IPs of the node on every change of the FloatingIPAssignments and after the
+// resync interval, so addresses changed on the host are corrected as well. Failed reconciliations are retried
+// with the configured backoff, capped at the resync interval.
+func (controller *Controller) RunAgent(ctx context.Context) error {
+	if err := controller.startAgentInformer(ctx); err != nil {
+		return fmt.Errorf("could not start informer: %w", err)
+	}
+
+	resyncInterval := controller.resyncInterval()
+	retryBackoff := controller.retryBackoff(resyncInterval)
+
+	failed := controller.reconcileAgentAddresses(ctx)
+	controller.dropReconcileTrigger()
+	controller.Logger.Infof("Agent started. Configuring the floating IPs of node '%s' on interface '%s'", controller.Configuration.NodeName, controller.Configuration.AgentInterface)
+
+	for {
+		delay := resyncInterval
+		if failed {
+			delay = retryBackoff.Step()
+		} else {
+			retryBackoff = controller.retryBackoff(resyncInterval)
+		}
+
+		select {
+		case <-ctx.Done():
+			controller.Logger.Info("Context Done. Shutting down")
+			return nil
+		case trigger := <-controller.reconcileTriggers:
+			controller.Logger.Debugf("Reconciling after %s change", trigger)
+		case <-time.After(delay):
+		}
+		failed = controller.reconcileAgentAddresses(ctx)
+	}
+}
+
+// reconcileAgentAddresses runs a reconciliation of the agent and logs its error. The agent is ready after its
+// first successful reconciliation. Returns true if the reconciliation failed.
+func (controller *Controller) reconcileAgentAddresses(ctx context.Context) bool {
+	err := controller.ReconcileAgent(ctx)
+	if err != nil && ctx.Err() == nil {
+		controller.Logger.Errorf("Reconciliation failed, retrying: %v", err)
+	}
+	if err == nil && controller.HealthServer != nil {
+		controller.HealthServer.SetReady(true)
+	}
+	return err != nil
+}
+
+// ReconcileAgent adds the floating IPs assigned to the node to the interface and removes the floating IPs
+// assigned to other nodes or unassigned from it. Floating IPs configured by the agent whose FloatingIPAssignment
+// was deleted are removed as well. Addresses not managed by the controller are never touched.
+// Each configured floating IP is reported to the leader through its FloatingIPAssignment.
+func (controller *Controller) ReconcileAgent(ctx context.Context) error {
+	assignments, err := controller.agentAssignments(ctx)
+	if err != nil {
+		return fmt.Errorf("could not list floating IP assignments: %w", err)
+	}
+	configured, err := controller.Link.Addresses()
+	if err != nil {
+		return fmt.Errorf("could not list addresses of interface '%s': %w", controller.Configuration.AgentInterface, err)
+	}
+	// The addresses added by the agent are read from the interface, so they are found after a restart as well
+	added, err := controller.Link.AgentAddresses()
+	if err != nil {
+		return fmt.Errorf("could not list addresses of interface '%s': %w", controller.Configuration.AgentInterface, err)
+	}
+
+	var errs []error
+	// assigned are the floating IPs which still have a FloatingIPAssignment, regardless of their node
+	assigned := make(map[string]bool)
+	for _, assignment := range assignments {
+		// Primary IPs are configured by the hetzner cloud and alias IPs belong to the private network interface
+		if assignment.Status.Kind != kindFloatingIP {
+			continue
+		}
+		address := agentAddress(net.ParseIP(assignment.Status.IP))
+		if address == nil {
+			controller.Logger.Warnf("Ignoring floating IP assignment '%s' with invalid IP '%s'", assignment.Name, assignment.Status.IP)
+			continue
+		}
+		assigned[address.String()] = true
+
+		if assignment.Status.Node != controller.Configuration.NodeName {
+			errs = append(errs, controller.removeAgentAddress(configured, address))
+			continue
+		}
+
+		if !hasIPNet(configured, address) {
+			controller.Logger.Infof("Adding floating IP '%s' to interface '%s'", address.String(), controller.Configuration.AgentInterface)
+			if err := controller.Link.AddAddress(address); err != nil {
+				errs = append(errs, fmt.Errorf("could not add '%s' to interface '%s': %w", address.String(), controller.Configuration.AgentInterface, err))
+				continue
+			}
+		}
+		if err := controller.reportConfigured(ctx, assignment); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	// The FloatingIPAssignment of a floating IP is deleted once the floating IP is no longer managed
+	for _, address := range added {
+		if !assigned[address.String()] {
+			errs = append(errs, controller.removeAgentAddress(configured, address))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// removeAgentAddress removes the floating IP from the interface if it is configured
+func (controller *Controller) removeAgentAddress(configured []*net.IPNet, address *net.IPNet) error {
+	if hasIPNet(configured, address) {
+		controller.Logger.Infof("Removing floating IP '%s' from interface '%s'", address.String(), controller.Configuration.AgentInterface)
+		if err := controller.Link.RemoveAddress(address); err != nil {
+			return fmt.Errorf("could not remove '%s' from interface '%s': %w", address.String(), controller.Configuration.AgentInterface, err)
+		}
+	}
+	return nil
+}
+
+// reportConfigured marks the FloatingIPAssignment as configured on the node of the agent, unless it already is
+func (controller *Controller) reportConfigured(ctx context.Context, assignment *FloatingIPAssignment) error {
+	if assignment.Annotations[annotationConfiguredNode] == controller.Configuration.NodeName {
+		return nil
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{annotationConfiguredNode: controller.Configuration.NodeName},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("could not encode floating IP assignment '%s': %w", assignment.Name, err)
+	}
+
+	err = controller.retry(operationUpdateAssignment, func() error {
+		_, err := controller.DynamicClient.Resource(assignmentResource).Namespace(controller.Configuration.Namespace).
+			Patch(ctx, assignment.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("could not report floating IP assignment '%s' as configured: %w", assignment.Name, err)
+	}
+	return nil
+}
+
+// startAgentInformer starts an informer for the FloatingIPAssignments of the controller and waits for its cache
+// to sync. Changes of the status trigger a reconciliation, the annotations written by the agents do not.
+func (controller *Controller) startAgentInformer(ctx context.Context) error {
+	controller.reconcileTriggers = make(chan string, 1)
+
+	factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(controller.DynamicClient, 0, controller.Configuration.Namespace,
+		func(options *metav1.ListOptions) {
+			options.LabelSelector = controller.assignmentSelector()
+		})
+	informer := factory.ForResource(assignmentResource)
+	handler, err := informer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(_ interface{}) { controller.triggerReconcile(triggerAssignment) },
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldAssignment, oldOk := oldObj.(*unstructured.Unstructured)
+			newAssignment, newOk := newObj.(*unstructured.Unstructured)
+			if oldOk && newOk && !reflect.DeepEqual(oldAssignment.Object["status"], newAssignment.Object["status"]) {
+				controller.triggerReconcile(triggerAssignment)
+			}
+		},
+		DeleteFunc: func(_ interface{}) { controller.triggerReconcile(triggerAssignment) },
+	})
+	if err != nil {
+		return fmt.Errorf("could not watch floating IP assignments: %w", err)
+	}
+
+	factory.Start(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), handler.HasSynced) {
+		return fmt.Errorf("could not sync informer cache")
+	}
+	controller.assignmentLister = informer.Lister()
+	return nil
+}
+
+// List the FloatingIPAssignments of the controller, from the informer cache if it is running
+func (controller *Controller) agentAssignments(ctx context.Context) ([]*FloatingIPAssignment, error) {
+	var objects []runtime.Object
+	if controller.assignmentLister != nil {
+		var err error
+		if objects, err = controller.assignmentLister.List(labels.Everything()); err != nil {
+			return nil, err
+		}
+	} else {
+		var list *unstructured.UnstructuredList
+		var err error
+		err = controller.retry(operationListAssignments, func() error {
+			list, err = controller.DynamicClient.Resource(assignmentResource).Namespace(controller.Configuration.Namespace).
+				List(ctx, metav1.ListOptions{LabelSelector: controller.assignmentSelector()})
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		for i := range list.Items {
+			objects = append(objects, &list.Items[i])
+		}
+	}
+
+	var assignments []*FloatingIPAssignment
+	for _, object := range objects {
+		content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(object)
+		if err != nil {
+			return nil, err
+		}
+		assignment := &FloatingIPAssignment{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(content, assignment); err != nil {
+			controller.Logger.Warnf("Ignoring floating IP assignment which could not be decoded: %v", err)
+			continue
+		}
+		assignments = append(assignments, assignment)
+	}
+	return assignments, nil
+}
+
+// agentAddress returns the address the agent configures for a floating IP. IPv4 floating IPs are configured as
+// single address, for IPv6 floating IPs the first address of the /64 network is configured with the network.
+func agentAddress(ip net.IP) *net.IPNet {
+	if ip == nil {
+		return nil
+	}
+	if ipv4 := ip.To4(); ipv4 != nil {
+		return &net.IPNet{IP: ipv4, Mask: net.CIDRMask(32, 32)}
+	}
+	mask := net.CIDRMask(64, 128)
+	address := ip.Mask(mask)
+	address[len(address)-1] = 1
+	return &net.IPNet{IP: address, Mask: mask}
+}
+
+// Checks for an address with the same IP and prefix length in a slice
+func hasIPNet(slice []*net.IPNet, val *net.IPNet) bool {
+	ones, _ := val.Mask.Size()
+	for _, item := range slice {
+		if itemOnes, _ := item.Mask.Size(); item.IP.Equal(val.IP) && itemOnes == ones {
+			return true
+		}
+	}
+	return false
+}
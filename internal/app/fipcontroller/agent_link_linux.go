@@ -0,0 +1,119 @@
+package fipcontroller
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// netlinkLink manages the addresses of a network interface through netlink
+type netlinkLink struct {
+	name   string
+	handle *netlink.Handle
+}
+
+// newLink returns the interface with the given name in the network namespace of the agent
+func newLink(name string) (Link, error) {
+	handle, err := netlink.NewHandle()
+	if err != nil {
+		return nil, fmt.Errorf("could not open netlink socket: %w", err)
+	}
+	link := &netlinkLink{name: name, handle: handle}
+	if _, err := link.link(); err != nil {
+		return nil, err
+	}
+	return link, nil
+}
+
+// The interface is looked up on every call, so a recreated interface is configured again
+func (link *netlinkLink) link() (netlink.Link, error) {
+	found, err := link.handle.LinkByName(link.name)
+	if err != nil {
+		return nil, fmt.Errorf("could not find interface '%s': %w", link.name, err)
+	}
+	return found, nil
+}
+
+func (link *netlinkLink) Addresses() ([]*net.IPNet, error) {
+	found, err := link.link()
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := link.handle.AddrList(found, netlink.FAMILY_ALL)
+	if err != nil {
+		return nil, err
+	}
+	addresses := make([]*net.IPNet, 0, len(addrs))
+	for _, addr := range addrs {
+		addresses = append(addresses, addr.IPNet)
+	}
+	return addresses, nil
+}
+
+// AgentAddresses returns the IPv4 addresses with the agent label and the IPv6 addresses of the form added by the
+// agent without duplicate address detection, as IPv6 addresses have no labels
+func (link *netlinkLink) AgentAddresses() ([]*net.IPNet, error) {
+	found, err := link.link()
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := link.handle.AddrList(found, netlink.FAMILY_ALL)
+	if err != nil {
+		return nil, err
+	}
+	var addresses []*net.IPNet
+	for _, addr := range addrs {
+		if addr.IP.To4() != nil {
+			if label := link.label(); label != "" && addr.Label == label {
+				addresses = append(addresses, addr.IPNet)
+			}
+			continue
+		}
+		if addr.Flags&unix.IFA_F_NODAD != 0 && addr.IPNet.String() == agentAddress(addr.IP).String() {
+			addresses = append(addresses, addr.IPNet)
+		}
+	}
+	return addresses, nil
+}
+
+// label marks the IPv4 addresses added by the agent. Labels have to start with the interface name and fit
+// into an interface name, so addresses on interfaces with longer names are not labelled.
+func (link *netlinkLink) label() string {
+	label := link.name + ":fip"
+	if len(label) >= unix.IFNAMSIZ {
+		return ""
+	}
+	return label
+}
+
+func (link *netlinkLink) AddAddress(address *net.IPNet) error {
+	found, err := link.link()
+	if err != nil {
+		return err
+	}
+	addr := &netlink.Addr{IPNet: address}
+	if address.IP.To4() != nil {
+		addr.Label = link.label()
+	} else {
+		// Floating IPs are only routed to one server, so IPv6 duplicate address detection is skipped
+		addr.Flags = unix.IFA_F_NODAD
+	}
+	if err := link.handle.AddrAdd(found, addr); err != nil && !errors.Is(err, unix.EEXIST) {
+		return err
+	}
+	return nil
+}
+
+func (link *netlinkLink) RemoveAddress(address *net.IPNet) error {
+	found, err := link.link()
+	if err != nil {
+		return err
+	}
+	if err := link.handle.AddrDel(found, &netlink.Addr{IPNet: address}); err != nil && !errors.Is(err, unix.EADDRNOTAVAIL) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package fipcontroller
+
+import (
+	"net"
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+)
+
+// createTestLink creates a dummy interface in a new network namespace, so the host is not touched
+func createTestLink(t *testing.T) *netlinkLink {
+	if os.Geteuid() != 0 {
+		t.Skip("creating a network namespace requires root")
+	}
+	runtime.LockOSThread()
+	t.Cleanup(runtime.UnlockOSThread)
+
+	origin, err := netns.Get()
+	if err != nil {
+		t.Skipf("could not get network namespace: %v", err)
+	}
+	namespace, err := netns.New()
+	if err != nil {
+		origin.Close()
+		t.Skipf("could not create network namespace: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = netns.Set(origin)
+		origin.Close()
+		namespace.Close()
+	})
+
+	handle, err := netlink.NewHandleAt(namespace)
+	if err != nil {
+		t.Fatalf("error should be [nil] but was [%v]", err)
+	}
+	t.Cleanup(handle.Close)
+	dummy := &netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: "fip0"}}
+	if err := handle.LinkAdd(dummy); err != nil {
+		t.Skipf("could not create dummy interface: %v", err)
+	}
+	if err := handle.LinkSetUp(dummy); err != nil {
+		t.Fatalf("error should be [nil] but was [%v]", err)
+	}
+	return &netlinkLink{name: "fip0", handle: handle}
+}
+
+func TestNetlinkLink(t *testing.T) {
+	link := createTestLink(t)
+
+	for _, ip := range []string{"10.0.0.1", "2001:db8::"} {
+		address := agentAddress(net.ParseIP(ip))
+
+		// Adding and removing is idempotent
+		for i := 0; i < 2; i++ {
+			if err := link.AddAddress(address); err != nil {
+				t.Fatalf("error should be [nil] but was [%v]", err)
+			}
+		}
+		addresses, err := link.Addresses()
+		if err != nil {
+			t.Fatalf("error should be [nil] but was [%v]", err)
+		}
+		if !hasIPNet(addresses, address) {
+			t.Fatalf("addresses should contain %s but were %v", address.String(), addresses)
+		}
+		added, err := link.AgentAddresses()
+		if err != nil {
+			t.Fatalf("error should be [nil] but was [%v]", err)
+		}
+		if !hasIPNet(added, address) {
+			t.Fatalf("agent addresses should contain %s but were %v", address.String(), added)
+		}
+
+		for i := 0; i < 2; i++ {
+			if err := link.RemoveAddress(address); err != nil {
+				t.Fatalf("error should be [nil] but was [%v]", err)
+			}
+		}
+		addresses, err = link.Addresses()
+		if err != nil {
+			t.Fatalf("error should be [nil] but was [%v]", err)
+		}
+		if hasIPNet(addresses, address) {
+			t.Fatalf("addresses should not contain %s but were %v", address.String(), addresses)
+		}
+	}
+}
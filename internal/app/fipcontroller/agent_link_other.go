@@ -0,0 +1,12 @@
+//go:build !linux
+
+package fipcontroller
+
+import (
+	"fmt"
+)
+
+// newLink fails on other systems, as the agent configures interfaces through netlink
+func newLink(name string) (Link, error) {
+	return nil, fmt.Errorf("configuring interface '%s' needs netlink, which is only available on linux", name)
+}
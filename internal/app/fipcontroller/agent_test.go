@@ -0,0 +1,230 @@
+package fipcontroller
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+
+	"github.com/cbeneke/hcloud-fip-controller/internal/pkg/configuration"
+)
+
+// fakeLink keeps the addresses of the interface in memory. The addresses it is created with were not added by
+// the agent.
+type fakeLink struct {
+	addresses map[string]*net.IPNet
+	added     map[string]bool
+}
+
+func newFakeLink(addresses ...string) *fakeLink {
+	link := &fakeLink{addresses: make(map[string]*net.IPNet), added: make(map[string]bool)}
+	for _, address := range addresses {
+		ip, ipNet, _ := net.ParseCIDR(address)
+		ipNet.IP = ip
+		link.addresses[ipNet.String()] = ipNet
+	}
+	return link
+}
+
+func (link *fakeLink) Addresses() ([]*net.IPNet, error) {
+	var addresses []*net.IPNet
+	for _, address := range link.addresses {
+		addresses = append(addresses, address)
+	}
+	return addresses, nil
+}
+
+func (link *fakeLink) AgentAddresses() ([]*net.IPNet, error) {
+	var addresses []*net.IPNet
+	for key, address := range link.addresses {
+		if link.added[key] {
+			addresses = append(addresses, address)
+		}
+	}
+	return addresses, nil
+}
+
+func (link *fakeLink) AddAddress(address *net.IPNet) error {
+	if _, ok := link.addresses[address.String()]; !ok {
+		link.added[address.String()] = true
+	}
+	link.addresses[address.String()] = address
+	return nil
+}
+
+func (link *fakeLink) RemoveAddress(address *net.IPNet) error {
+	delete(link.addresses, address.String())
+	delete(link.added, address.String())
+	return nil
+}
+
+func createTestAgentAssignment(name, kind, ip, node string) *unstructured.Unstructured {
+	assignment := createTestAssignment(name, "fip")
+	assignment.Object["status"] = map[string]interface{}{"kind": kind, "ip": ip, "node": node}
+	return assignment
+}
+
+func TestReconcileAgent(t *testing.T) {
+	tests := []struct {
+		name       string
+		assignment *unstructured.Unstructured
+		addresses  []string
+		result     []string
+		configured string
+	}{
+		{
+			name:       "add address of the node",
+			assignment: createTestAgentAssignment("floating-10-0-0-1", kindFloatingIP, "10.0.0.1", "node-1"),
+			addresses:  []string{"1.1.1.1/24"},
+			result:     []string{"1.1.1.1/24", "10.0.0.1/32"},
+			configured: "node-1",
+		},
+		{
+			name:       "remove address of another node",
+			assignment: createTestAgentAssignment("floating-10-0-0-1", kindFloatingIP, "10.0.0.1", "node-2"),
+			addresses:  []string{"1.1.1.1/24", "10.0.0.1/32"},
+			result:     []string{"1.1.1.1/24"},
+		},
+		{
+			name:       "remove unassigned address",
+			assignment: createTestAgentAssignment("floating-10-0-0-1", kindFloatingIP, "10.0.0.1", ""),
+			addresses:  []string{"10.0.0.1/32"},
+		},
+		{
+			name:       "ignore primary IP",
+			assignment: createTestAgentAssignment("primary-10-0-0-1", kindPrimaryIP, "10.0.0.1", "node-1"),
+			addresses:  []string{"1.1.1.1/24"},
+			result:     []string{"1.1.1.1/24"},
+		},
+		{
+			name:       "add IPv6 network of the node",
+			assignment: createTestAgentAssignment("floating-2001-db8--0", kindFloatingIP, "2001:db8::", "node-1"),
+			result:     []string{"2001:db8::1/64"},
+			configured: "node-1",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+				map[schema.GroupVersionResource]string{assignmentResource: "FloatingIPAssignmentList"},
+				test.assignment,
+			)
+			link := newFakeLink(test.addresses...)
+			controller := Controller{
+				DynamicClient: dynamicClient,
+				Backoff: wait.Backoff{
+					Steps: 1,
+				},
+				Configuration: &configuration.Configuration{
+					LeaseName:      "fip",
+					Namespace:      "fip",
+					NodeName:       "node-1",
+					AgentInterface: "eth0",
+				},
+				Logger: logrus.New(),
+				Link:   link,
+			}
+
+			if err := controller.ReconcileAgent(context.Background()); err != nil {
+				t.Fatalf("error should be [nil] but was [%v]", err)
+			}
+
+			if len(link.addresses) != len(test.result) {
+				t.Fatalf("addresses should be %v but were %v", test.result, link.addresses)
+			}
+			for _, address := range test.result {
+				if _, ok := link.addresses[address]; !ok {
+					t.Fatalf("addresses should be %v but were %v", test.result, link.addresses)
+				}
+			}
+
+			object, err := dynamicClient.Resource(assignmentResource).Namespace("fip").Get(context.Background(), test.assignment.GetName(), metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("error should be [nil] but was [%v]", err)
+			}
+			if configured := object.GetAnnotations()[annotationConfiguredNode]; configured != test.configured {
+				t.Fatalf("configured node should be '%s' but was '%s'", test.configured, configured)
+			}
+		})
+	}
+}
+
+func TestReconcileAgentDeletedAssignment(t *testing.T) {
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{assignmentResource: "FloatingIPAssignmentList"},
+		createTestAgentAssignment("floating-10-0-0-1", kindFloatingIP, "10.0.0.1", "node-1"),
+	)
+	// 10.0.0.2 was configured by someone else, it is not touched without an assignment
+	link := newFakeLink("1.1.1.1/24", "10.0.0.2/32")
+	newAgent := func() *Controller {
+		return &Controller{
+			DynamicClient: dynamicClient,
+			Backoff: wait.Backoff{
+				Steps: 1,
+			},
+			Configuration: &configuration.Configuration{
+				LeaseName:      "fip",
+				Namespace:      "fip",
+				NodeName:       "node-1",
+				AgentInterface: "eth0",
+			},
+			Logger: logrus.New(),
+			Link:   link,
+		}
+	}
+
+	controller := newAgent()
+	if err := controller.ReconcileAgent(context.Background()); err != nil {
+		t.Fatalf("error should be [nil] but was [%v]", err)
+	}
+	if _, ok := link.addresses["10.0.0.1/32"]; !ok {
+		t.Fatalf("floating IP of the node should be added but addresses were %v", link.addresses)
+	}
+
+	// The assignment is deleted while the agent restarts, the restarted agent finds the IP on the interface
+	err := dynamicClient.Resource(assignmentResource).Namespace("fip").Delete(context.Background(), "floating-10-0-0-1", metav1.DeleteOptions{})
+	if err != nil {
+		t.Fatalf("error should be [nil] but was [%v]", err)
+	}
+	controller = newAgent()
+	if err := controller.ReconcileAgent(context.Background()); err != nil {
+		t.Fatalf("error should be [nil] but was [%v]", err)
+	}
+
+	expected := []string{"1.1.1.1/24", "10.0.0.2/32"}
+	if len(link.addresses) != len(expected) {
+		t.Fatalf("addresses should be %v but were %v", expected, link.addresses)
+	}
+	for _, address := range expected {
+		if _, ok := link.addresses[address]; !ok {
+			t.Fatalf("addresses should be %v but were %v", expected, link.addresses)
+		}
+	}
+}
+
+func TestAgentAddress(t *testing.T) {
+	tests := []struct {
+		ip     string
+		result string
+	}{
+		{ip: "10.0.0.1", result: "10.0.0.1/32"},
+		{ip: "2001:db8::", result: "2001:db8::1/64"},
+		{ip: "2001:db8:0:0:1::", result: "2001:db8::1/64"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.ip, func(t *testing.T) {
+			if address := agentAddress(net.ParseIP(test.ip)); address.String() != test.result {
+				t.Fatalf("address should be %s but was %s", test.result, address.String())
+			}
+		})
+	}
+}
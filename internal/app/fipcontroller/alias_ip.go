@@ -0,0 +1,215 @@
+package fipcontroller
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"net"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+
+	"github.com/cbeneke/hcloud-fip-controller/internal/pkg/configuration"
+)
+
+// aliasIPProvider manages virtual IPs inside an hcloud network, which are configured as alias IP of at most
+// one server at a time.
+//
+// An alias IP is moved by first removing it from the alias IPs of its current server, as an IP can only be
+// used once in a network, and then adding it to the alias IPs of the new server. Other alias IPs of the
+// servers are kept.
+type aliasIPProvider struct {
+	hcloudServers
+	// network is the name or ID of the network the alias IPs belong to
+	network  string
+	aliasIPs []string
+
+	// networkID is the ID of the network, known after listing the addresses
+	networkID int64
+}
+
+func newAliasIPProvider(client *hcloud.Client, config *configuration.Configuration) *aliasIPProvider {
+	return &aliasIPProvider{
+		hcloudServers: newHcloudServers(client, config),
+		network:       config.AliasIPNetwork,
+		aliasIPs:      config.AliasIPs,
+	}
+}
+
+func (provider *aliasIPProvider) Kind() string {
+	return kindAliasIP
+}
+
+// Addresses fetches the alias IP network and finds the servers currently holding the configured alias IPs
+func (provider *aliasIPProvider) Addresses(ctx context.Context) ([]*Address, error) {
+	network, err := provider.cache.network(ctx, provider.client, provider.network)
+	if err != nil {
+		return nil, fmt.Errorf("could not get network '%s': %w", provider.network, err)
+	}
+	if network == nil {
+		return nil, fmt.Errorf("network '%s' not found", provider.network)
+	}
+	provider.networkID = network.ID
+
+	// The holder of an alias IP might not be a running node anymore, so all servers are searched
+	servers, err := provider.Servers(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch servers: %w", err)
+	}
+
+	addresses := make([]*Address, 0, len(provider.aliasIPs))
+	for _, aliasIP := range provider.aliasIPs {
+		ip := net.ParseIP(aliasIP)
+		if ip == nil {
+			return nil, fmt.Errorf("alias IP '%s' is not a valid IP address", aliasIP)
+		}
+		addresses = append(addresses, &Address{
+			ID:     aliasIPID(ip),
+			IP:     ip,
+			Server: aliasIPHolder(servers, network.ID, ip),
+		})
+	}
+	return addresses, nil
+}
+
+// Placement returns the servers attached to the alias IP network
+func (provider *aliasIPProvider) Placement(servers []*hcloud.Server, _ *Address) (candidates []*hcloud.Server, _ string) {
+	for _, server := range servers {
+		if serverPrivateNet(server, provider.networkID) != nil {
+			candidates = append(candidates, server)
+		}
+	}
+	if len(candidates) < 1 {
+		return nil, placementNone
+	}
+	return candidates, placementNetwork
+}
+
+func (provider *aliasIPProvider) Assign(ctx context.Context, address *Address, server *hcloud.Server) (*hcloud.Action, error) {
+	if address.Server != nil {
+		action, err := provider.Unassign(ctx, address)
+		if err != nil {
+			return nil, err
+		}
+		if err := provider.WaitForAction(ctx, action); err != nil {
+			return nil, err
+		}
+		address.Server = nil
+	}
+
+	current, err := provider.currentServer(ctx, server)
+	if err != nil {
+		return nil, err
+	}
+	if current == nil {
+		return nil, fmt.Errorf("server '%s' does not exist", server.Name)
+	}
+	privateNet := serverPrivateNet(current, provider.networkID)
+	if privateNet == nil {
+		return nil, fmt.Errorf("server '%s' is not attached to network '%s'", server.Name, provider.network)
+	}
+
+	aliases := append(append([]net.IP{}, privateNet.Aliases...), address.IP)
+	return provider.changeAliasIPs(ctx, current, aliases)
+}
+
+// Unassign removes the alias IP from the alias IPs of its server
+func (provider *aliasIPProvider) Unassign(ctx context.Context, address *Address) (*hcloud.Action, error) {
+	current, err := provider.currentServer(ctx, address.Server)
+	if err != nil {
+		return nil, err
+	}
+	// Alias IPs of deleted servers are released with the server
+	if current == nil {
+		return nil, nil
+	}
+	privateNet := serverPrivateNet(current, provider.networkID)
+	if privateNet == nil {
+		return nil, nil
+	}
+	var aliases []net.IP
+	for _, alias := range privateNet.Aliases {
+		if !alias.Equal(address.IP) {
+			aliases = append(aliases, alias)
+		}
+	}
+
+	return provider.changeAliasIPs(ctx, current, aliases)
+}
+
+// Fetch the current state of the server, bypassing the cache. The alias IPs of a server can only be replaced
+// as a whole, so they are read right before they are changed to not drop alias IPs added in the meantime.
+// Returns nil if the server does not exist.
+func (provider *aliasIPProvider) currentServer(ctx context.Context, server *hcloud.Server) (*hcloud.Server, error) {
+	current, _, err := provider.client.Server.GetByID(ctx, server.ID)
+	if err != nil {
+		return nil, fmt.Errorf("could not get server '%s': %w", server.Name, err)
+	}
+	return current, nil
+}
+
+// Refresh reads the server the alias IP is expected on and checks whether the server holds it. Alias IPs have no
+// holder in the hcloud API, finding another holder would require listing all servers.
+func (provider *aliasIPProvider) Refresh(ctx context.Context, address *Address) (*Address, error) {
+	if address.Server == nil {
+		return nil, fmt.Errorf("alias IP '%s' has no server to check", address.IP.String())
+	}
+	current, err := provider.currentServer(ctx, address.Server)
+	if err != nil {
+		return nil, err
+	}
+	refreshed := *address
+	refreshed.Server = nil
+	if current != nil {
+		refreshed.Server = aliasIPHolder([]*hcloud.Server{current}, provider.networkID, address.IP)
+	}
+	return &refreshed, nil
+}
+
+func (provider *aliasIPProvider) UpdateLabels(_ context.Context, address *Address, _ map[string]string) error {
+	return fmt.Errorf("alias IP '%s' can not have labels", address.IP.String())
+}
+
+// Replace the alias IPs of the server in the alias IP network
+func (provider *aliasIPProvider) changeAliasIPs(ctx context.Context, server *hcloud.Server, aliases []net.IP) (*hcloud.Action, error) {
+	defer provider.cache.invalidate()
+	action, _, err := provider.client.Server.ChangeAliasIPs(ctx, server, hcloud.ServerChangeAliasIPsOpts{
+		Network:  &hcloud.Network{ID: provider.networkID},
+		AliasIPs: aliases,
+	})
+	return action, err
+}
+
+// aliasIPID derives the ID of an alias IP from the IP, as alias IPs have no ID in hcloud. The ID stays the same
+// when the configuration is reordered.
+func aliasIPID(ip net.IP) int64 {
+	hash := fnv.New64a()
+	hash.Write(ip.To16())
+	return int64(hash.Sum64() & math.MaxInt64)
+}
+
+// Return the server which has the IP configured as alias IP in the network, or nil if there is none
+func aliasIPHolder(servers []*hcloud.Server, networkID int64, ip net.IP) *hcloud.Server {
+	for _, server := range servers {
+		privateNet := serverPrivateNet(server, networkID)
+		if privateNet == nil {
+			continue
+		}
+		for _, alias := range privateNet.Aliases {
+			if alias.Equal(ip) {
+				return server
+			}
+		}
+	}
+	return nil
+}
+
+// Return the attachment of the server to the network, or nil if the server is not attached to it
+func serverPrivateNet(server *hcloud.Server, networkID int64) *hcloud.ServerPrivateNet {
+	for i := range server.PrivateNet {
+		if server.PrivateNet[i].Network != nil && server.PrivateNet[i].Network.ID == networkID {
+			return &server.PrivateNet[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package fipcontroller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	"github.com/hetznercloud/hcloud-go/v2/hcloud/schema"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"github.com/cbeneke/hcloud-fip-controller/internal/pkg/configuration"
+)
+
+func createTestPrivateServer(id int64, network *hcloud.Network, aliases ...string) *hcloud.Server {
+	privateNet := hcloud.ServerPrivateNet{Network: network}
+	for _, alias := range aliases {
+		privateNet.Aliases = append(privateNet.Aliases, net.ParseIP(alias))
+	}
+	return &hcloud.Server{
+		ID:         id,
+		Name:       fmt.Sprintf("server-%d", id),
+		PrivateNet: []hcloud.ServerPrivateNet{privateNet},
+	}
+}
+
+// Convert the server to its hcloud API representation, with its private networks only
+func schemaPrivateServer(server *hcloud.Server) schema.Server {
+	result := schema.Server{ID: server.ID, Name: server.Name}
+	for _, privateNet := range server.PrivateNet {
+		var aliases []string
+		for _, alias := range privateNet.Aliases {
+			aliases = append(aliases, alias.String())
+		}
+		result.PrivateNet = append(result.PrivateNet, schema.ServerPrivateNet{Network: privateNet.Network.ID, AliasIPs: aliases})
+	}
+	return result
+}
+
+func TestReconcileAliasIPs(t *testing.T) {
+	network := &hcloud.Network{ID: 1, Name: "internal"}
+	otherNetwork := &hcloud.Network{ID: 2, Name: "other"}
+
+	tests := []struct {
+		name           string
+		runningServers []*hcloud.Server
+		holder         *hcloud.Server
+		// backend are the servers as stored in hcloud, if they differ from the running servers and the holder
+		backend []*hcloud.Server
+		calls   []string
+	}{
+		{
+			name:           "held by running server",
+			runningServers: []*hcloud.Server{createTestPrivateServer(1, network, "10.0.0.100")},
+			holder:         createTestPrivateServer(1, network, "10.0.0.100"),
+			calls:          nil,
+		},
+		{
+			name:           "unassigned",
+			runningServers: []*hcloud.Server{createTestPrivateServer(1, network, "10.0.0.50")},
+			calls: []string{
+				"/servers/1/actions/change_alias_ips [10.0.0.50 10.0.0.100]",
+			},
+		},
+		{
+			name:           "failover from failed server",
+			runningServers: []*hcloud.Server{createTestPrivateServer(1, network)},
+			holder:         createTestPrivateServer(9, network, "10.0.0.50", "10.0.0.100"),
+			calls: []string{
+				"/servers/9/actions/change_alias_ips [10.0.0.50]",
+				"/servers/1/actions/change_alias_ips [10.0.0.100]",
+			},
+		},
+		{
+			name:           "unmanaged alias ips are kept",
+			runningServers: []*hcloud.Server{createTestPrivateServer(1, network, "10.0.0.50"), createTestPrivateServer(2, network)},
+			calls: []string{
+				"/servers/1/actions/change_alias_ips [10.0.0.50 10.0.0.100]",
+			},
+		},
+		{
+			name:           "alias ips added since the servers were listed are kept",
+			runningServers: []*hcloud.Server{createTestPrivateServer(1, network)},
+			backend:        []*hcloud.Server{createTestPrivateServer(1, network, "10.0.0.50")},
+			calls: []string{
+				"/servers/1/actions/change_alias_ips [10.0.0.50 10.0.0.100]",
+			},
+		},
+		{
+			name:           "no running server in network",
+			runningServers: []*hcloud.Server{createTestPrivateServer(1, otherNetwork)},
+			calls:          nil,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			testEnv := newTestEnv()
+			defer testEnv.Teardown()
+
+			backend := make(map[int64]schema.Server)
+			for _, server := range append(append([]*hcloud.Server{test.holder}, test.runningServers...), test.backend...) {
+				if server != nil {
+					backend[server.ID] = schemaPrivateServer(server)
+				}
+			}
+
+			var calls []string
+			testEnv.Mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+				// Assignments are verified by reading the target server, not by listing all servers
+				if r.Method == http.MethodGet && r.URL.Path == "/servers" {
+					t.Errorf("servers should not be listed")
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				var id int64
+				fmt.Sscanf(r.URL.Path, "/servers/%d", &id)
+				server := backend[id]
+				if r.Method == http.MethodGet {
+					json.NewEncoder(w).Encode(schema.ServerGetResponse{Server: server})
+					return
+				}
+				var request schema.ServerActionChangeAliasIPsRequest
+				json.NewDecoder(r.Body).Decode(&request)
+				calls = append(calls, fmt.Sprintf("%s %v", r.URL.Path, request.AliasIPs))
+				server.PrivateNet[0].AliasIPs = request.AliasIPs
+				backend[id] = server
+
+				w.WriteHeader(http.StatusCreated)
+				json.NewEncoder(w).Encode(schema.ServerActionChangeAliasIPsResponse{
+					Action: schema.Action{ID: 1, Status: "success"},
+				})
+			})
+
+			provider := newAliasIPProvider(testEnv.Client, &configuration.Configuration{AliasIPNetwork: network.Name})
+			provider.networkID = network.ID
+			controller := Controller{
+				Providers:     []IPProvider{provider},
+				Configuration: &configuration.Configuration{},
+				Backoff: wait.Backoff{
+					Steps: 1,
+				},
+				Logger: logrus.New(),
+			}
+			addresses := []*Address{{ID: 1, IP: net.ParseIP("10.0.0.100"), Server: test.holder}}
+			now := time.Now()
+			controller.observeUnhealthyServers(test.runningServers, addressHolders(addresses), now)
+
+			err := controller.reconcileAddresses(context.Background(), provider, test.runningServers, addresses, now)
+			if err != nil {
+				t.Fatalf("error should be [nil] but was [%v]", err)
+			}
+			if !reflect.DeepEqual(test.calls, calls) {
+				t.Fatalf("calls should be %v but were %v", test.calls, calls)
+			}
+		})
+	}
+}
+
+func TestAliasIPID(t *testing.T) {
+	first := aliasIPID(net.ParseIP("10.0.0.100"))
+	if first <= 0 {
+		t.Fatalf("id should be positive but was %d", first)
+	}
+	if id := aliasIPID(net.ParseIP("10.0.0.100")); id != first {
+		t.Fatalf("id should be %d but was %d", first, id)
+	}
+	if id := aliasIPID(net.ParseIP("10.0.0.101")); id == first {
+		t.Fatalf("ids of different IPs should differ but both were %d", id)
+	}
+
+	// Reordering the configuration keeps the IDs of the addresses
+	testEnv := newTestEnv()
+	defer testEnv.Teardown()
+	testEnv.Mux.HandleFunc("/networks/1", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(schema.NetworkGetResponse{Network: schema.Network{ID: 1, Name: "internal"}})
+	})
+	testEnv.Mux.HandleFunc("/servers", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(schema.ServerListResponse{})
+	})
+	ids := make(map[string]int64)
+	for _, aliasIPs := range [][]string{{"10.0.0.100", "10.0.0.101"}, {"10.0.0.101", "10.0.0.100"}} {
+		provider := newAliasIPProvider(testEnv.Client, &configuration.Configuration{AliasIPNetwork: "1", AliasIPs: aliasIPs})
+		addresses, err := provider.Addresses(context.Background())
+		if err != nil {
+			t.Fatalf("error should be [nil] but was [%v]", err)
+		}
+		for _, address := range addresses {
+			if id, ok := ids[address.IP.String()]; ok && id != address.ID {
+				t.Fatalf("id of %s should be %d but was %d", address.IP.String(), id, address.ID)
+			}
+			ids[address.IP.String()] = address.ID
+		}
+	}
+}
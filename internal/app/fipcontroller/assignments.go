@@ -0,0 +1,298 @@
+package fipcontroller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// assignmentResource is the FloatingIPAssignment custom resource, see deploy/crds/floatingipassignments.yaml
+var assignmentResource = schema.GroupVersionResource{Group: "fip.hcloud", Version: "v1alpha1", Resource: "floatingipassignments"}
+
+// Labels of the FloatingIPAssignment objects. The controller label holds the lease name, so controllers sharing a
+// namespace only manage their own objects.
+const (
+	labelAssignmentController = "fip.hcloud/controller"
+	labelAssignmentKind       = "fip.hcloud/kind"
+)
+
+// Health of an address reported in its FloatingIPAssignment
+const (
+	assignmentHealthy    = "Healthy"
+	assignmentUnhealthy  = "Unhealthy"
+	assignmentUnassigned = "Unassigned"
+	assignmentEvacuating = "Evacuating"
+)
+
+// Reasons of an assignment which was not (re)assigned by the controller. Assignments made by the controller
+// carry the reason of the reassignment, e.g. failover or rebalance.
+const (
+	// assignmentReasonObserved is the reason of addresses the controller found already assigned
+	assignmentReasonObserved = "observed"
+	// assignmentReasonExternal is the reason of addresses moved outside of the controller
+	assignmentReasonExternal = "external"
+)
+
+// FloatingIPAssignment reports the live state of a single address managed by the controller. It has no spec, the
+// objects are written by the leader only.
+type FloatingIPAssignment struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Status FloatingIPAssignmentStatus `json:"status,omitempty"`
+}
+
+// FloatingIPAssignmentStatus is the observed assignment of an address
+type FloatingIPAssignmentStatus struct {
+	// Kind is the kind of the address, one of floating, primary or alias
+	Kind     string `json:"kind"`
+	IP       string `json:"ip"`
+	HcloudID int64  `json:"hcloudID"`
+	// Pool is the "<namespace>/<name>" of the FloatingIPPool managing the address, if any
+	Pool     string `json:"pool,omitempty"`
+	Server   string `json:"server,omitempty"`
+	ServerID int64  `json:"serverID,omitempty"`
+	// Node is the kubernetes node backed by the server, if any
+	Node   string `json:"node,omitempty"`
+	Health string `json:"health"`
+	// Reason is the reason of the last change of the server
+	Reason string `json:"reason,omitempty"`
+	// LastTransitionTime is the time the server or the health of the address last changed
+	LastTransitionTime metav1.Time `json:"lastTransitionTime"`
+	// Configured is set once the agent of the node reported the address as configured on its interface
+	Configured bool `json:"configured,omitempty"`
+}
+
+// observedAddress is the state of an address after a reconciliation
+type observedAddress struct {
+	kind    string
+	address *Address
+	// server is the server holding the address, with its name and public net if it is known
+	server *hcloud.Server
+	node   string
+	health string
+	// reason is the reason the address was (re)assigned in the reconciliation, empty if it was not moved
+	reason string
+	pool   string
+}
+
+// observeAddresses records the state of the reconciled addresses of the provider for the FloatingIPAssignment
+// objects and the node labels. Servers not running anymore are looked up in the servers of the provider to report
+// their name and node.
+func (controller *Controller) observeAddresses(ctx context.Context, provider IPProvider, runningServers []*hcloud.Server, addresses []*Address) {
+	if !controller.Configuration.AssignmentObjects && !controller.Configuration.NodeLabels {
+		return
+	}
+
+	nodes, err := controller.nodes(ctx)
+	if err != nil {
+		controller.Logger.Warnf("Could not find the nodes of the %s IPs: %v", provider.Kind(), err)
+	}
+	servers := runningServers
+	for _, address := range addresses {
+		if address.Server != nil && !hasServerByID(servers, address.Server) {
+			if all, err := controller.providerServers(ctx, provider); err == nil {
+				servers = all
+			}
+			break
+		}
+	}
+
+	for _, address := range addresses {
+		observed := &observedAddress{
+			kind:    provider.Kind(),
+			address: address,
+			server:  address.Server,
+			health:  controller.addressHealth(address, runningServers),
+			reason:  controller.reassignedReasons[planKey(provider, address)],
+		}
+		if address.Server != nil {
+			for _, server := range servers {
+				if server.ID == address.Server.ID {
+					observed.server = server
+				}
+			}
+			if nodes != nil {
+				observed.node = controller.assignmentNode(nodes, observed.server)
+			}
+		}
+		controller.observedAddresses = append(controller.observedAddresses, observed)
+	}
+}
+
+// addressHealth returns the health of the address on its current server
+func (controller *Controller) addressHealth(address *Address, runningServers []*hcloud.Server) string {
+	switch {
+	case address.Server == nil:
+		return assignmentUnassigned
+	case !hasServerByID(runningServers, address.Server):
+		return assignmentUnhealthy
+	case controller.isServerEvacuating(address.Server):
+		return assignmentEvacuating
+	}
+	return assignmentHealthy
+}
+
+// syncAssignments writes a FloatingIPAssignment object for every address observed in the last reconciliation.
+// Objects of addresses which are not managed anymore are only deleted if deleteStale is set, i.e. if all
+// addresses could be discovered. Objects are only written if their status changed.
+func (controller *Controller) syncAssignments(ctx context.Context, deleteStale bool) error {
+	resource := controller.DynamicClient.Resource(assignmentResource).Namespace(controller.Configuration.Namespace)
+
+	var list *unstructured.UnstructuredList
+	var err error
+	err = controller.retry(operationListAssignments, func() error {
+		list, err = resource.List(ctx, metav1.ListOptions{LabelSelector: controller.assignmentSelector()})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("could not list floating IP assignments: %w", err)
+	}
+	existing := make(map[string]*FloatingIPAssignment, len(list.Items))
+	for _, item := range list.Items {
+		assignment := &FloatingIPAssignment{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(item.Object, assignment); err != nil {
+			controller.Logger.Warnf("Replacing floating IP assignment '%s' which could not be decoded: %v", item.GetName(), err)
+			assignment = &FloatingIPAssignment{ObjectMeta: metav1.ObjectMeta{Name: item.GetName(), ResourceVersion: item.GetResourceVersion()}}
+		}
+		existing[assignment.Name] = assignment
+	}
+
+	var errs []error
+	now := metav1.Now()
+	for _, observed := range controller.observedAddresses {
+		name := assignmentName(observed.kind, observed.address.IP.String())
+		previous, ok := existing[name]
+		delete(existing, name)
+
+		status := assignmentStatus(observed, previous, now)
+		if ok && previous.Status == status && previous.Labels[labelAssignmentKind] == observed.kind {
+			continue
+		}
+		if err := controller.writeAssignment(ctx, name, observed.kind, status, previous); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if deleteStale {
+		for name := range existing {
+			err := controller.retry(operationDeleteAssignment, func() error {
+				return resource.Delete(ctx, name, metav1.DeleteOptions{})
+			})
+			if err != nil && classifyError(err) != errorClassNotFound {
+				errs = append(errs, fmt.Errorf("could not delete floating IP assignment '%s': %w", name, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// assignmentSelector selects the FloatingIPAssignment objects of the controller
+func (controller *Controller) assignmentSelector() string {
+	return labelAssignmentController + "=" + controller.Configuration.LeaseName
+}
+
+// assignmentStatus returns the status of the observed address. The transition time and reason are kept from the
+// previous status unless the server or the health of the address changed.
+func assignmentStatus(observed *observedAddress, previous *FloatingIPAssignment, now metav1.Time) FloatingIPAssignmentStatus {
+	status := FloatingIPAssignmentStatus{
+		Kind:     observed.kind,
+		IP:       observed.address.IP.String(),
+		HcloudID: observed.address.ID,
+		Pool:     observed.pool,
+		Node:     observed.node,
+		Health:   observed.health,
+	}
+	if observed.server != nil {
+		status.Server = observed.server.Name
+		status.ServerID = observed.server.ID
+	}
+	if previous != nil && status.Node != "" {
+		status.Configured = previous.Annotations[annotationConfiguredNode] == status.Node
+	}
+
+	switch {
+	case previous == nil:
+		status.Reason = assignmentReasonObserved
+		status.LastTransitionTime = now
+	case previous.Status.ServerID != status.ServerID:
+		status.Reason = assignmentReasonExternal
+		status.LastTransitionTime = now
+	default:
+		status.Reason = previous.Status.Reason
+		status.LastTransitionTime = previous.Status.LastTransitionTime
+		if previous.Status.Health != status.Health {
+			status.LastTransitionTime = now
+		}
+	}
+	if observed.reason != "" {
+		status.Reason = observed.reason
+		status.LastTransitionTime = now
+	}
+	return status
+}
+
+// writeAssignment creates the FloatingIPAssignment object, or updates it if a previous version exists
+func (controller *Controller) writeAssignment(ctx context.Context, name, kind string, status FloatingIPAssignmentStatus, previous *FloatingIPAssignment) error {
+	assignment := &FloatingIPAssignment{
+		TypeMeta: metav1.TypeMeta{APIVersion: assignmentResource.GroupVersion().String(), Kind: "FloatingIPAssignment"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: controller.Configuration.Namespace,
+			Labels: map[string]string{
+				labelAssignmentController: controller.Configuration.LeaseName,
+				labelAssignmentKind:       kind,
+			},
+		},
+		Status: status,
+	}
+	// The annotations are written by the agents, they are kept as they are
+	if previous != nil {
+		assignment.ResourceVersion = previous.ResourceVersion
+		assignment.Annotations = previous.Annotations
+	}
+
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(assignment)
+	if err != nil {
+		return fmt.Errorf("could not encode floating IP assignment '%s': %w", name, err)
+	}
+	resource := controller.DynamicClient.Resource(assignmentResource).Namespace(controller.Configuration.Namespace)
+	err = controller.retry(operationUpdateAssignment, func() error {
+		if previous == nil {
+			_, err := resource.Create(ctx, &unstructured.Unstructured{Object: content}, metav1.CreateOptions{})
+			return err
+		}
+		_, err := resource.Update(ctx, &unstructured.Unstructured{Object: content}, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("could not write floating IP assignment '%s': %w", name, err)
+	}
+	return nil
+}
+
+// assignmentName returns the object name of the FloatingIPAssignment of an address, e.g. floating-10-0-0-1.
+// IPv6 addresses ending in "::" get a trailing zero, as names must end alphanumeric.
+func assignmentName(kind, ip string) string {
+	name := kind + "-" + strings.NewReplacer(".", "-", ":", "-").Replace(ip)
+	if strings.HasSuffix(name, "-") {
+		name += "0"
+	}
+	return name
+}
+
+// assignmentNode returns the name of the node backed by the server, or an empty string
+func (controller *Controller) assignmentNode(nodes *corev1.NodeList, server *hcloud.Server) string {
+	if node := controller.nodeForServer(nodes, server); node != nil {
+		return node.Name
+	}
+	return ""
+}
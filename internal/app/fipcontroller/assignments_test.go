@@ -0,0 +1,224 @@
+package fipcontroller
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/cbeneke/hcloud-fip-controller/internal/pkg/configuration"
+)
+
+func createTestAssignment(name, leaseName string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": assignmentResource.GroupVersion().String(),
+		"kind":       "FloatingIPAssignment",
+		"metadata": map[string]interface{}{
+			"namespace": "fip",
+			"name":      name,
+			"labels":    map[string]interface{}{labelAssignmentController: leaseName},
+		},
+	}}
+}
+
+func TestAssignmentName(t *testing.T) {
+	tests := []struct {
+		kind   string
+		ip     string
+		result string
+	}{
+		{kind: kindFloatingIP, ip: "10.0.0.1", result: "floating-10-0-0-1"},
+		{kind: kindFloatingIP, ip: "2001:db8::", result: "floating-2001-db8--0"},
+		{kind: kindAliasIP, ip: "2001:db8::1", result: "alias-2001-db8--1"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.ip, func(t *testing.T) {
+			if name := assignmentName(test.kind, test.ip); name != test.result {
+				t.Fatalf("name should be %s but was %s", test.result, name)
+			}
+		})
+	}
+}
+
+func TestAssignmentStatus(t *testing.T) {
+	before := metav1.NewTime(time.Now().Add(-time.Hour).Truncate(time.Second))
+	now := metav1.NewTime(time.Now().Truncate(time.Second))
+	server := &hcloud.Server{ID: 1, Name: "server-1"}
+	previous := &FloatingIPAssignment{Status: FloatingIPAssignmentStatus{
+		ServerID:           1,
+		Health:             assignmentHealthy,
+		Reason:             reasonFailover,
+		LastTransitionTime: before,
+	}}
+
+	tests := []struct {
+		name     string
+		observed *observedAddress
+		previous *FloatingIPAssignment
+		reason   string
+		time     metav1.Time
+	}{
+		{
+			name:     "first observation",
+			observed: &observedAddress{server: server, health: assignmentHealthy},
+			reason:   assignmentReasonObserved,
+			time:     now,
+		},
+		{
+			name:     "unchanged",
+			observed: &observedAddress{server: server, health: assignmentHealthy},
+			previous: previous,
+			reason:   reasonFailover,
+			time:     before,
+		},
+		{
+			name:     "health changed",
+			observed: &observedAddress{server: server, health: assignmentUnhealthy},
+			previous: previous,
+			reason:   reasonFailover,
+			time:     now,
+		},
+		{
+			name:     "moved outside of the controller",
+			observed: &observedAddress{server: &hcloud.Server{ID: 2, Name: "server-2"}, health: assignmentHealthy},
+			previous: previous,
+			reason:   assignmentReasonExternal,
+			time:     now,
+		},
+		{
+			name:     "moved by the controller",
+			observed: &observedAddress{server: &hcloud.Server{ID: 2, Name: "server-2"}, health: assignmentHealthy, reason: reasonRebalance},
+			previous: previous,
+			reason:   reasonRebalance,
+			time:     now,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.observed.kind = kindFloatingIP
+			test.observed.address = &Address{ID: 1, IP: net.ParseIP("10.0.0.1")}
+
+			status := assignmentStatus(test.observed, test.previous, now)
+			if status.Reason != test.reason {
+				t.Fatalf("reason should be %s but was %s", test.reason, status.Reason)
+			}
+			if !status.LastTransitionTime.Equal(&test.time) {
+				t.Fatalf("last transition time should be %v but was %v", test.time, status.LastTransitionTime)
+			}
+		})
+	}
+}
+
+func TestSyncAssignments(t *testing.T) {
+	servers := []*hcloud.Server{
+		{ID: 1, Name: "server-1", PublicNet: hcloud.ServerPublicNet{IPv4: hcloud.ServerPublicNetIPv4{IP: net.ParseIP("1.1.1.1")}}},
+	}
+	addresses := []*Address{
+		{ID: 1, IP: net.ParseIP("10.0.0.1")},
+		{ID: 2, IP: net.ParseIP("10.0.0.2"), Server: &hcloud.Server{ID: 1}},
+	}
+
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{assignmentResource: "FloatingIPAssignmentList"},
+		createTestAssignment("floating-10-0-0-3", "fip"),
+		createTestAssignment("floating-10-0-0-4", "other"),
+	)
+	controller := Controller{
+		Providers: []IPProvider{newFakeProvider(addresses, servers)},
+		KubernetesClient: fake.NewSimpleClientset(
+			createTestNode("node-1", []v1.NodeAddress{{Type: v1.NodeExternalIP, Address: "1.1.1.1"}}, v1.ConditionTrue),
+		),
+		DynamicClient: dynamicClient,
+		Backoff: wait.Backoff{
+			Steps: 1,
+		},
+		Configuration: &configuration.Configuration{
+			LeaseName:         "fip",
+			Namespace:         "fip",
+			NodeAddressType:   configuration.NodeAddressTypeExternal,
+			AssignmentObjects: true,
+		},
+		Logger: logrus.New(),
+	}
+
+	if err := controller.UpdateFloatingIPs(context.Background()); err != nil {
+		t.Fatalf("error should be [nil] but was [%v]", err)
+	}
+
+	resource := dynamicClient.Resource(assignmentResource).Namespace("fip")
+	expected := map[string]string{
+		"floating-10-0-0-1": reasonUnassigned,
+		"floating-10-0-0-2": assignmentReasonObserved,
+	}
+	for name, reason := range expected {
+		object, err := resource.Get(context.Background(), name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("error should be [nil] but was [%v]", err)
+		}
+		assignment := &FloatingIPAssignment{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(object.Object, assignment); err != nil {
+			t.Fatalf("error should be [nil] but was [%v]", err)
+		}
+		status := assignment.Status
+		if status.Server != "server-1" || status.Node != "node-1" || status.Health != assignmentHealthy || status.Reason != reason {
+			t.Fatalf("assignment '%s' should be healthy on server-1/node-1 with reason %s but was %+v", name, reason, status)
+		}
+	}
+	if _, err := resource.Get(context.Background(), "floating-10-0-0-3", metav1.GetOptions{}); err == nil {
+		t.Fatal("stale assignment should be deleted")
+	}
+	if _, err := resource.Get(context.Background(), "floating-10-0-0-4", metav1.GetOptions{}); err != nil {
+		t.Fatalf("assignment of another controller should be kept but was [%v]", err)
+	}
+
+	// Unchanged assignments are not written again
+	dynamicClient.ClearActions()
+	if err := controller.UpdateFloatingIPs(context.Background()); err != nil {
+		t.Fatalf("error should be [nil] but was [%v]", err)
+	}
+	for _, action := range dynamicClient.Actions() {
+		if action.GetVerb() != "list" {
+			t.Fatalf("only list actions should be made for unchanged assignments but got %s", action.GetVerb())
+		}
+	}
+}
+
+func TestAssignmentStatusConfigured(t *testing.T) {
+	previous := &FloatingIPAssignment{ObjectMeta: metav1.ObjectMeta{
+		Annotations: map[string]string{annotationConfiguredNode: "node-1"},
+	}}
+
+	tests := []struct {
+		name       string
+		node       string
+		previous   *FloatingIPAssignment
+		configured bool
+	}{
+		{name: "configured by the agent of the node", node: "node-1", previous: previous, configured: true},
+		{name: "configured on the previous node", node: "node-2", previous: previous},
+		{name: "not configured yet", node: "node-1"},
+		{name: "no node", previous: previous},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			observed := &observedAddress{kind: kindFloatingIP, address: &Address{ID: 1, IP: net.ParseIP("10.0.0.1")}, node: test.node}
+			if status := assignmentStatus(observed, test.previous, metav1.Now()); status.Configured != test.configured {
+				t.Fatalf("configured should be %t but was %t", test.configured, status.Configured)
+			}
+		})
+	}
+}
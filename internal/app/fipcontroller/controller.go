@@ -2,7 +2,10 @@ package fipcontroller
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math"
+	"net"
 	"os"
 	"time"
 
@@ -10,11 +13,15 @@ import (
 	"go.opentelemetry.io/otel/codes"
 	"go.opentelemetry.io/otel/trace"
 	"k8s.io/apimachinery/pkg/util/wait"
-	"k8s.io/client-go/util/retry"
 
 	"github.com/hetznercloud/hcloud-go/v2/hcloud"
 	"github.com/sirupsen/logrus"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	discoverylisters "k8s.io/client-go/listers/discovery/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 
 	"github.com/cbeneke/hcloud-fip-controller/internal/pkg/configuration"
 )
@@ -22,31 +29,110 @@ import (
 // Controller is the main struct used for all other functions in this package.
 // Holds all client configurations and loggers
 type Controller struct {
-	HetznerClient    *hcloud.Client
+	// Providers are the cloud backends of the managed addresses, one per address kind
+	Providers        []IPProvider
 	KubernetesClient kubernetes.Interface
-	Configuration    *configuration.Configuration
-	Logger           *logrus.Logger
-	Backoff          wait.Backoff
-	HealthServer     *HealthServer
+	// DynamicClient reads the FloatingIPPool custom resources and updates their status
+	DynamicClient dynamic.Interface
+	Configuration *configuration.Configuration
+	Logger        *logrus.Logger
+	Backoff       wait.Backoff
+	HealthServer  *HealthServer
+	Strategy      AssignmentStrategy
+	// Recorder emits kubernetes events on the nodes, services and pods affected by IP changes
+	Recorder record.EventRecorder
+	// Link is the host interface the floating IPs of the node are configured on in agent mode
+	Link Link
+
+	// lastRebalance is the time of the last rebalancing move, used to rate limit rebalancing
+	lastRebalance time.Time
+	// leadingSince is the time this instance became leader, used for the takeover grace period
+	leadingSince time.Time
+	// unhealthyServers tracks servers holding addresses which are currently not running
+	unhealthyServers map[int64]*unhealthyObservation
+	// evacuatingServers maps the IDs of running servers addresses are moved off to the reason of the evacuation
+	evacuatingServers map[int64]string
+	// nodeLister and podLister read nodes and pods from the informer caches once the informers are started.
+	// Without informers, e.g. for subcommands, nodes and pods are read from the API.
+	nodeLister corelisters.NodeLister
+	podLister  corelisters.PodLister
+	// serviceLister and endpointSliceLister read services and endpoint slices from the informer caches once the
+	// informers are started
+	serviceLister       corelisters.ServiceLister
+	endpointSliceLister discoverylisters.EndpointSliceLister
+	// followedServices are the services whose endpoints decided the placement in the last reconciliation. Changes
+	// of their endpoints trigger a reconciliation. Shared with the pool controllers.
+	followedServices *serviceKeys
+	// reconcileTriggers requests a reconciliation on relevant node and pod changes
+	reconcileTriggers chan string
+	// poolProvider creates the floating IP provider of a pool from the pool configuration
+	poolProvider func(config *configuration.Configuration) IPProvider
+	// poolLister reads the FloatingIPPool objects from the informer cache once the informers are started
+	poolLister cache.GenericLister
+	// assignmentLister reads the FloatingIPAssignment objects from the informer cache in agent mode
+	assignmentLister cache.GenericLister
+	// pools holds the controllers of the FloatingIPPools, keyed by "<namespace>/<name>"
+	pools map[string]*poolController
+	// reassignedReasons collects the reasons of the (re)assignments of the last reconciliation, keyed by planKey
+	reassignedReasons map[string]string
+	// pendingFailovers collects the addresses of the last reconciliation whose failover waits for the unhealthy
+	// threshold or duration of their server, keyed by planKey
+	pendingFailovers map[string]bool
+	// observedAddresses is the state of all addresses after the last reconciliation, written to the
+	// FloatingIPAssignment objects
+	observedAddresses []*observedAddress
 }
 
 // NewController creates a new Controller and with it the client configurations and loggers
 func NewController(config *configuration.Configuration) (*Controller, error) {
 	// Validate controller config
 	if err := config.Validate(); err != nil {
-		return nil, fmt.Errorf("controller config invalid: %v", err)
+		return nil, fmt.Errorf("controller config invalid: %w", err)
 	}
 
-	hetznerClient, err := newHetznerClient(config.HcloudAPIToken)
+	hetznerClient, err := newHetznerClient(config.HcloudAPIToken, config.HcloudRateLimitHeadroom)
 	if err != nil {
-		return nil, fmt.Errorf("could not initialise hetzner client: %v", err)
+		return nil, fmt.Errorf("could not initialise hetzner client: %w", err)
 	}
 
-	kubernetesClient, err := newKubernetesClient()
+	kubeConfig, err := newKubeConfig()
+	if err != nil {
+		return nil, fmt.Errorf("could not initialise kubernetes client: %w", err)
+	}
+	kubernetesClient, err := newKubernetesClient(kubeConfig)
 	if err != nil {
-		return nil, fmt.Errorf("could not initialise kubernetes client: %v", err)
+		return nil, fmt.Errorf("could not initialise kubernetes client: %w", err)
 	}
+	dynamicClient, err := newDynamicClient(kubeConfig)
+	if err != nil {
+		return nil, fmt.Errorf("could not initialise kubernetes client: %w", err)
+	}
+
+	logger, err := newLogger(config)
+	if err != nil {
+		return nil, err
+	}
+
+	strategy, err := newAssignmentStrategy(config)
+	if err != nil {
+		return nil, fmt.Errorf("could not initialise assignment strategy: %w", err)
+	}
+
+	return &Controller{
+		Providers:        newHcloudProviders(hetznerClient, config),
+		KubernetesClient: kubernetesClient,
+		DynamicClient:    dynamicClient,
+		Configuration:    config,
+		Logger:           logger,
+		Backoff:          newBackoff(config),
+		Strategy:         strategy,
+		Recorder:         newEventRecorder(kubernetesClient),
+		poolProvider:     newHcloudPoolProvider(hetznerClient, config),
+	}, nil
+}
 
+// newLogger creates the logger with the configured log level
+func newLogger(config *configuration.Configuration) (*logrus.Logger, error) {
 	logger := logrus.New()
 	logger.SetFormatter(&logrus.TextFormatter{
 		DisableColors: true,
@@ -57,49 +143,97 @@ func NewController(config *configuration.Configuration) (*Controller, error) {
 
 	loglevel, err := logrus.ParseLevel(config.LogLevel)
 	if err != nil {
-		return nil, fmt.Errorf("could not parse log level: %v", err)
+		return nil, fmt.Errorf("could not parse log level: %w", err)
 	}
 	logger.SetLevel(loglevel)
+	return logger, nil
+}
 
-	backoff := wait.Backoff{
+// newBackoff creates the backoff of retried calls from the configuration
+func newBackoff(config *configuration.Configuration) wait.Backoff {
+	return wait.Backoff{
 		Duration: config.BackoffDuration,
 		Factor:   config.BackoffFactor,
 		Steps:    config.BackoffSteps,
 	}
+}
 
-	return &Controller{
-		HetznerClient:    hetznerClient,
-		KubernetesClient: kubernetesClient,
-		Configuration:    config,
-		Logger:           logger,
-		Backoff:          backoff,
-	}, nil
+// defaultResyncInterval is the maximum time between two reconciliations if no resync interval is configured
+const defaultResyncInterval = 30 * time.Second
+
+// resyncInterval returns the configured resync interval or its default
+func (controller *Controller) resyncInterval() time.Duration {
+	if controller.Configuration.ResyncInterval == 0 {
+		return defaultResyncInterval
+	}
+	return controller.Configuration.ResyncInterval
 }
 
-// Run updates Floating IPs once initially and every 30s afterwards
+// Run updates Floating IPs once initially and afterwards on every relevant node or pod change, or after the
+// resync interval passed without a change. Failed reconciliations do not stop the controller, they are retried
+// with the configured backoff, capped at the resync interval.
 //
 // === Main Thread ===
 func (controller *Controller) Run(ctx context.Context) error {
-	if err := controller.UpdateFloatingIPs(ctx); err != nil {
-		return err
+	if err := controller.startInformers(ctx); err != nil {
+		return fmt.Errorf("could not start informers: %w", err)
 	}
+
+	resyncInterval := controller.resyncInterval()
+	retryBackoff := controller.retryBackoff(resyncInterval)
+
+	failed := controller.updateFloatingIPs(ctx)
+	// The initial reconciliation already covers the nodes and pods found while starting the informers
+	controller.dropReconcileTrigger()
 	controller.Logger.Info("Initialization complete. Starting reconciliation")
 
 	for {
+		delay := resyncInterval
+		if failed {
+			delay = retryBackoff.Step()
+		} else {
+			retryBackoff = controller.retryBackoff(resyncInterval)
+		}
+
+		var trigger string
 		select {
 		case <-ctx.Done():
 			controller.Logger.Info("Context Done. Shutting down")
 			return nil
-		case <-time.After(30 * time.Second):
-			if err := controller.UpdateFloatingIPs(ctx); err != nil {
-				return err
-			}
+		case trigger = <-controller.reconcileTriggers:
+			controller.Logger.Debugf("Reconciling after %s change", trigger)
+		case <-time.After(delay):
+			trigger = triggerResync
 		}
+		reconcileTriggersTotal.WithLabelValues(trigger).Inc()
+		failed = controller.updateFloatingIPs(ctx)
+	}
+}
+
+// updateFloatingIPs runs a reconciliation and logs its error. Returns true if the reconciliation failed.
+func (controller *Controller) updateFloatingIPs(ctx context.Context) bool {
+	err := controller.UpdateFloatingIPs(ctx)
+	if err != nil && ctx.Err() == nil {
+		controller.Logger.Errorf("Reconciliation failed, retrying: %v", err)
+	}
+	return err != nil
+}
+
+// retryBackoff returns the backoff between retries of failed reconciliations. It grows with the configured
+// backoff factor up to the resync interval.
+func (controller *Controller) retryBackoff(resyncInterval time.Duration) wait.Backoff {
+	backoff := controller.Backoff
+	if backoff.Duration <= 0 || backoff.Duration > resyncInterval {
+		backoff.Duration = resyncInterval
 	}
+	backoff.Cap = resyncInterval
+	backoff.Steps = math.MaxInt32
+	return backoff
 }
 
-// UpdateFloatingIPs searches for running hetzner cloud servers and sort them by fewest assigned floating ips.
-// It then (re)assigns all unassigned ips or ips that are assigned to non running servers to the sorted running serves.
+// UpdateFloatingIPs searches for running hetzner cloud servers and (re)assigns all unassigned addresses of all
+// providers or addresses that are assigned to non running servers to the running server chosen by the configured
+// assignment strategy.
 func (controller *Controller) UpdateFloatingIPs(ctx context.Context) (err error) {
 	controller.Logger.Debugf("Checking floating IPs")
 
@@ -118,84 +252,299 @@ func (controller *Controller) UpdateFloatingIPs(ctx context.Context) (err error)
 		span.End()
 	}()
 
-	// Get running servers for floating ip assignment
+	var errs []error
+	controller.observedAddresses = nil
+	if len(controller.Providers) > 0 {
+		errs = append(errs, controller.reconcileProviders(ctx))
+	}
+	// Pools are reconciled even if the providers of the controller failed, they only depend on their own spec
+	if controller.Configuration.FloatingIPPools {
+		errs = append(errs, controller.reconcilePools(ctx))
+	}
+	err = errors.Join(errs...)
+
+	// Objects and labels of addresses which were not discovered are kept, as the addresses may still be managed
+	complete := err == nil
+	controller.followedServices.update(complete)
+	if controller.Configuration.AssignmentObjects && !controller.Configuration.DryRun {
+		if syncErr := controller.syncAssignments(ctx, complete); syncErr != nil {
+			controller.Logger.Errorf("Could not update floating IP assignments: %v", syncErr)
+			err = errors.Join(err, syncErr)
+		}
+	}
+	if controller.Configuration.NodeLabels && !controller.Configuration.DryRun {
+		if syncErr := controller.syncNodeLabels(ctx, complete); syncErr != nil {
+			controller.Logger.Errorf("Could not update node labels: %v", syncErr)
+			err = errors.Join(err, syncErr)
+		}
+	}
+	return err
+}
+
+// reconcileProviders discovers and reconciles the addresses of the providers of the controller
+func (controller *Controller) reconcileProviders(ctx context.Context) error {
+	runningServers, addresses, err := controller.discover(ctx)
+	if runningServers == nil {
+		return err
+	}
+	return errors.Join(err, controller.reconcile(ctx, runningServers, addresses))
+}
+
+// discover returns the running servers and the addresses of all providers, indexed like the providers.
+// Providers failing the discovery are skipped, their running servers and addresses are nil and their error
+// is returned along with the results of the other providers.
+func (controller *Controller) discover(ctx context.Context) (runningServers [][]*hcloud.Server, addresses [][]*Address, err error) {
+	// Get running servers for address assignment
 	nodeAddressList, err := controller.nodeAddressList(ctx, controller.Configuration.NodeAddressType)
 	if err != nil {
-		return fmt.Errorf("could not get addressList for active kubernetes nodes: %v", err)
+		return nil, nil, fmt.Errorf("could not get addressList for active kubernetes nodes: %w", err)
 	}
 
 	if nodeAddressList == nil || len(nodeAddressList) < 1 {
-		return fmt.Errorf("Could not find any ips")
+		return nil, nil, fmt.Errorf("Could not find any ips")
 	}
 
-	runningServers, err := controller.servers(ctx, nodeAddressList)
+	span := trace.SpanFromContext(ctx)
+	runningServers = make([][]*hcloud.Server, len(controller.Providers))
+	addresses = make([][]*Address, len(controller.Providers))
+	var errs []error
+	for i, provider := range controller.Providers {
+		servers, providerAddresses, err := controller.discoverProvider(ctx, provider, nodeAddressList)
+		if err != nil {
+			controller.Logger.WithField("kind", provider.Kind()).Errorf("Skipping %s IPs: %v", provider.Kind(), err)
+			errs = append(errs, fmt.Errorf("could not discover %s IPs: %w", provider.Kind(), err))
+			continue
+		}
+		runningServers[i], addresses[i] = servers, providerAddresses
+
+		managedFloatingIPs.WithLabelValues(provider.Kind()).Set(float64(len(addresses[i])))
+		span.SetAttributes(
+			attribute.Int(provider.Kind()+"_ips", len(addresses[i])),
+			attribute.Int("running_servers", len(runningServers[i])),
+		)
+	}
+	return runningServers, addresses, errors.Join(errs...)
+}
+
+// discoverProvider returns the running servers and the addresses of the provider
+func (controller *Controller) discoverProvider(ctx context.Context, provider IPProvider, nodeAddressList [][]net.IP) ([]*hcloud.Server, []*Address, error) {
+	runningServers, err := controller.servers(ctx, provider, nodeAddressList)
 	if err != nil {
-		return fmt.Errorf("Could not get server objects for addressList: %v", err)
+		return nil, nil, fmt.Errorf("Could not get server objects for addressList: %w", err)
+	}
+
+	if len(runningServers) < 1 {
+		return nil, nil, fmt.Errorf("No server objects were found")
+	}
+
+	addresses, err := controller.addresses(ctx, provider)
+	if err != nil {
+		return nil, nil, err
+	}
+	return runningServers, addresses, nil
+}
+
+// reconcile the discovered addresses of all providers. The unhealthy servers are observed for all providers
+// first, as they share the unhealthy server tracking. A failing provider does not stop the reconciliation of
+// the others, all errors are returned joined.
+func (controller *Controller) reconcile(ctx context.Context, runningServers [][]*hcloud.Server, addresses [][]*Address) error {
+	var running, holders []*hcloud.Server
+	for i := range controller.Providers {
+		running = append(running, runningServers[i]...)
+		holders = append(holders, addressHolders(addresses[i])...)
 	}
 
-	if runningServers == nil || len(runningServers) < 1 {
-		return fmt.Errorf("No server objects were found")
+	var errs []error
+	now := time.Now()
+	controller.reassignedReasons = make(map[string]string)
+	controller.pendingFailovers = make(map[string]bool)
+	controller.observedAddresses = nil
+	controller.observeUnhealthyServers(running, holders, now)
+	if err := controller.observeEvacuatingServers(ctx, running); err != nil {
+		// Without the evacuation rules, addresses still fail over, they are just not moved off evacuated servers
+		controller.Logger.Errorf("Could not find servers to evacuate: %v", err)
+		errs = append(errs, fmt.Errorf("could not find servers to evacuate: %w", err))
 	}
 
-	// Get floatingIPs from config if specified, otherwise from hetzner api
-	floatingIPs, err := controller.getFloatingIPs(ctx)
+	for i, provider := range controller.Providers {
+		// Providers without running servers failed the discovery
+		if runningServers[i] == nil {
+			continue
+		}
+		if err := controller.reconcileAddresses(ctx, provider, runningServers[i], addresses[i], now); err != nil {
+			errs = append(errs, err)
+		}
+		controller.observeAddresses(ctx, provider, runningServers[i], addresses[i])
+	}
+	return errors.Join(errs...)
+}
+
+// reconcileAddresses (re)assigns all addresses of the provider that are unassigned, assigned to a failed server
+// or assigned to a server not allowed by their policy, and rebalances them if enabled. Each address is
+// reconciled independently, a failing address does not stop the reconciliation of the others.
+func (controller *Controller) reconcileAddresses(ctx context.Context, provider IPProvider, runningServers []*hcloud.Server, addresses []*Address, now time.Time) error {
+	// In service IPAM mode the floating IPs are placed by the services they are allocated to
+	if controller.Configuration.ServiceIPAM && provider.Kind() == kindFloatingIP {
+		return controller.reconcileServiceIPs(ctx, provider, runningServers, addresses, now)
+	}
+
+	span := trace.SpanFromContext(ctx)
+	logger := controller.Logger.WithField("kind", provider.Kind())
+
+	// Apply the placement policies from the address labels
+	var errs []error
+	addresses, policies, err := controller.addressPolicies(ctx, provider, runningServers, addresses)
+	if policies == nil {
+		return fmt.Errorf("Could not resolve %s IP policies: %w", provider.Kind(), err)
+	}
 	if err != nil {
-		return fmt.Errorf("Could not get floatingIPs: %v", err)
+		errs = append(errs, err)
 	}
 
-	managedFloatingIPs.Set(float64(len(floatingIPs)))
-	span.SetAttributes(
-		attribute.Int("floating_ips", len(floatingIPs)),
-		attribute.Int("running_servers", len(runningServers)),
-	)
+	assignments := addressAssignments(runningServers, addresses)
+	inGracePeriod := controller.inTakeoverGracePeriod(now)
 
-	for _, floatingIP := range floatingIPs {
-		controller.Logger.Debugf("Checking floating IP: %s", floatingIP.IP.String())
+	for _, address := range addresses {
+		logger.Debugf("Checking address: %s", address.IP.String())
+		policy := policies[address.ID]
 
-		// (Re)assign floatingIP if no server is assigned or the assigned server is not running
+		// (Re)assign address if no server is assigned, the assigned server is not running or the assigned
+		// server is not allowed by the address policy.
 		// Since we already have all running server in a slice we can just search through it
-		if floatingIP.Server == nil || !hasServerByID(runningServers, floatingIP.Server) {
-			// Get the server with the lowest amount of fips (cant be nil since we know that servers can't be empty)
-			server := findServerWithLowestFIP(runningServers)
-
-			controller.Logger.Infof("Switching address '%s' to server '%s'", floatingIP.IP.String(), server.Name)
-			var response *hcloud.Response
-			err = retry.OnError(controller.Backoff, alwaysRetry, func() error {
-				_, response, err = controller.HetznerClient.FloatingIP.Assign(ctx, floatingIP, server)
-				return err
-			})
-			if err != nil {
-				return fmt.Errorf("could not update floating IP '%s': %v", floatingIP.IP.String(), err)
-			}
-			if response.StatusCode != 201 {
-				return fmt.Errorf("could not update floating IP '%s': Got HTTP Code %d, expected 201", floatingIP.IP.String(), response.StatusCode)
+		var reason string
+		switch {
+		case address.Server == nil:
+			reason = reasonUnassigned
+		case !hasServerByID(runningServers, address.Server):
+			reason = reasonFailover
+		case controller.isServerEvacuating(address.Server):
+			reason = reasonEvacuation
+		case !hasServerByID(policy.servers, address.Server):
+			reason = reasonPolicy
+		default:
+			continue
+		}
+
+		// Unassigned addresses carry no traffic and are assigned right away. Assigned ones are only
+		// moved once their server is considered failed, to avoid connection resets on short flaps.
+		if reason != reasonUnassigned && inGracePeriod {
+			logger.Infof("Not moving address '%s' away from server %d during takeover grace period", address.IP.String(), address.Server.ID)
+			continue
+		}
+		if reason == reasonFailover && !controller.isServerFailed(address.Server, now) {
+			observation := controller.unhealthyServers[address.Server.ID]
+			logger.Infof("Server %d of address '%s' unhealthy for %d observations since %s, waiting before failover",
+				address.Server.ID, address.IP.String(), observation.observations, observation.since.Format(time.RFC3339))
+			controller.recordPendingFailover(provider, address)
+			continue
+		}
+
+		// Moving primary IPs shuts down the servers, which is only done for failed servers
+		if provider.Kind() == kindPrimaryIP {
+			if blocked := primaryIPMoveBlocked(address, reason); blocked != nil {
+				logger.Warnf("Could not move address '%s': %v", address.IP.String(), blocked)
+				blockedReassignmentsTotal.WithLabelValues(provider.Kind(), blocked.Reason).Inc()
+				continue
 			}
-			// Add placeholder floating ip to server so that findServerWithLowestFIP will always get a correct server
-			server.PublicNet.FloatingIPs = append(server.PublicNet.FloatingIPs, &hcloud.FloatingIP{})
+		}
+
+		if len(policy.servers) < 1 {
+			logger.Warnf("No running server matches the policy of address '%s'", address.IP.String())
+			controller.eventNoHealthyTarget(ctx, provider, address, policy.followService, "no running server matches its policy")
+			continue
+		}
 
-			reassignmentsTotal.Inc()
-			span.AddEvent("reassigned floating ip", trace.WithAttributes(
-				attribute.String("floating_ip", floatingIP.IP.String()),
-				attribute.String("server", server.Name),
+		// Addresses are never placed on servers which are evacuated
+		candidates, placement := provider.Placement(controller.withoutEvacuatingServers(policy.servers), address)
+		if len(candidates) < 1 {
+			logger.Warnf("No running server can hold address '%s' from location '%s'", address.IP.String(), addressLocation(address))
+			blockedReassignmentsTotal.WithLabelValues(provider.Kind(), blockedNoCandidate).Inc()
+			controller.eventNoHealthyTarget(ctx, provider, address, policy.followService, fmt.Sprintf("no running server can hold it from location '%s'", addressLocation(address)))
+			span.AddEvent("no placement for address", trace.WithAttributes(
+				attribute.String("kind", provider.Kind()),
+				attribute.String("address", address.IP.String()),
+				attribute.String("location", addressLocation(address)),
 			))
+			continue
 		}
 
+		server := policy.strategy.SelectServer(candidates, address, assignments)
+		if server == nil {
+			logger.Warnf("Assignment strategy found no server for address '%s'", address.IP.String())
+			controller.eventNoHealthyTarget(ctx, provider, address, policy.followService, "the assignment strategy found no server")
+			continue
+		}
+
+		previous := address.Server
+		if reason == reasonEvacuation {
+			logger.Infof("Evacuating address '%s' from server %d, as its %s", address.IP.String(), previous.ID, controller.evacuatingServers[previous.ID])
+		}
+		logger.Infof("Switching address '%s' to server '%s' in location '%s' (reason: %s, placement: %s)", address.IP.String(), server.Name, serverLocation(server), reason, placement)
+		if err := controller.assignAddress(withAssignmentReason(ctx, reason), provider, address, server); err != nil {
+			if blocked := blockedError(err); blocked != nil {
+				logger.Warnf("Could not move address '%s': %v", address.IP.String(), blocked)
+				blockedReassignmentsTotal.WithLabelValues(provider.Kind(), blocked.Reason).Inc()
+				continue
+			}
+			controller.eventAssignFailed(ctx, provider, address, server, policy.followService, err)
+			errs = append(errs, controller.addressError(provider, address, err))
+			continue
+		}
+		// Track the new assignment so that the strategy sees the correct load for the next address
+		assignments[server.ID]++
+		if previous != nil {
+			assignments[previous.ID]--
+		}
+
+		controller.recordReassignment(ctx, provider, address, server, reason, placement)
+		controller.eventMoved(ctx, provider, address, previous, server, reason, policy.followService)
+	}
+
+	// Moving primary IPs requires powering off servers, so they are never rebalanced
+	if controller.Configuration.Rebalance && !inGracePeriod && provider.Kind() != kindPrimaryIP {
+		if err := controller.rebalanceAddresses(ctx, provider, controller.withoutEvacuatingServers(runningServers), addresses, policies, assignments); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// addressError logs and counts the failed reconciliation of a single address and returns the error annotated
+// with the address
+func (controller *Controller) addressError(provider IPProvider, address *Address, err error) error {
+	controller.Logger.WithField("kind", provider.Kind()).Errorf("Could not reconcile address '%s': %v", address.IP.String(), err)
+	addressErrorsTotal.WithLabelValues(provider.Kind()).Inc()
+	return fmt.Errorf("could not reconcile %s IP '%s': %w", provider.Kind(), address.IP.String(), err)
+}
+
+// Remember that the failover of the address waits for its server to be considered failed
+func (controller *Controller) recordPendingFailover(provider IPProvider, address *Address) {
+	if controller.pendingFailovers != nil {
+		controller.pendingFailovers[planKey(provider, address)] = true
 	}
-	return nil
 }
 
-// Find the server with the lowest amount of floating IPs
-func findServerWithLowestFIP(servers []*hcloud.Server) *hcloud.Server {
-	if len(servers) < 1 {
-		return nil
+// Count the (re)assignment of the address to the server and add it as event to the current span.
+// In dry run mode the skipped (re)assignment is counted separately.
+func (controller *Controller) recordReassignment(ctx context.Context, provider IPProvider, address *Address, server *hcloud.Server, reason string, placement string, attributes ...attribute.KeyValue) {
+	if controller.reassignedReasons != nil {
+		controller.reassignedReasons[planKey(provider, address)] = reason
 	}
-	server := servers[0]
-	for _, s := range servers {
-		if len(s.PublicNet.FloatingIPs) < len(server.PublicNet.FloatingIPs) {
-			server = s
-		}
+	if controller.Configuration.DryRun {
+		dryRunReassignmentsTotal.WithLabelValues(provider.Kind(), reason).Inc()
+	} else {
+		reassignmentsTotal.WithLabelValues(provider.Kind(), reason).Inc()
 	}
-	return server
+	attributes = append([]attribute.KeyValue{
+		attribute.String("kind", provider.Kind()),
+		attribute.String("address", address.IP.String()),
+		attribute.String("server", server.Name),
+		attribute.String("reason", reason),
+		attribute.String("location", serverLocation(server)),
+		attribute.String("placement", placement),
+		attribute.Bool("dry_run", controller.Configuration.DryRun),
+	}, attributes...)
+	trace.SpanFromContext(ctx).AddEvent("reassigned address", trace.WithAttributes(attributes...))
 }
 
 // Checks for a server in a slice by its id
@@ -208,7 +557,3 @@ func hasServerByID(slice []*hcloud.Server, val *hcloud.Server) bool {
 	}
 	return false
 }
-
-func alwaysRetry(_ error) bool {
-	return true
-}
@@ -169,10 +169,17 @@ func TestUpdateFloatingIPs(t *testing.T) {
 				})
 			})
 
+			testEnv.Mux.HandleFunc("/floating_ips/1", func(w http.ResponseWriter, r *http.Request) {
+				serverID := int64(1)
+				json.NewEncoder(w).Encode(schema.FloatingIPGetResponse{
+					FloatingIP: schema.FloatingIP{ID: 1, Type: "ipv4", IP: "1.2.3.4", Server: &serverID},
+				})
+			})
+
 			kubernetesFakeClient := fake.NewSimpleClientset(test.objects...)
 
 			controller := Controller{
-				HetznerClient:    testEnv.Client,
+				Providers:        []IPProvider{newFloatingIPProvider(testEnv.Client, &configuration.Configuration{})},
 				KubernetesClient: kubernetesFakeClient,
 				Backoff: wait.Backoff{
 					Steps: 1,
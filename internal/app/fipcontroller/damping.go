@@ -0,0 +1,62 @@
+package fipcontroller
+
+import (
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+)
+
+// unhealthyObservation tracks how long a server holding addresses has been observed as unhealthy
+type unhealthyObservation struct {
+	since time.Time
+	// counted is the time of the last counted observation
+	counted      time.Time
+	observations int
+}
+
+// observeUnhealthyServers records an unhealthy observation for each server holding an IP that is not in the
+// list of running servers. Reconciliations are also triggered by node and pod changes, so at most one
+// observation is counted per resync interval, otherwise a burst of changes would reach the unhealthy threshold
+// within seconds. Servers that are running again are forgotten, so only consecutive observations are counted.
+func (controller *Controller) observeUnhealthyServers(runningServers []*hcloud.Server, holders []*hcloud.Server, now time.Time) {
+	observed := make(map[int64]*unhealthyObservation)
+	for _, holder := range holders {
+		if hasServerByID(runningServers, holder) {
+			continue
+		}
+		if _, ok := observed[holder.ID]; ok {
+			continue
+		}
+
+		observation, ok := controller.unhealthyServers[holder.ID]
+		if !ok {
+			observation = &unhealthyObservation{since: now, counted: now, observations: 1}
+		} else if now.Sub(observation.counted) >= controller.resyncInterval() {
+			observation.counted = now
+			observation.observations++
+		}
+		observed[holder.ID] = observation
+	}
+	controller.unhealthyServers = observed
+}
+
+// isServerFailed reports whether a server has been unhealthy for long enough to move its addresses away.
+// This requires the configured number of consecutive unhealthy observations, one per resync interval, as well
+// as the configured unhealthy duration to be reached.
+func (controller *Controller) isServerFailed(server *hcloud.Server, now time.Time) bool {
+	observation, ok := controller.unhealthyServers[server.ID]
+	if !ok {
+		return false
+	}
+	return observation.observations >= controller.Configuration.UnhealthyThreshold &&
+		now.Sub(observation.since) >= controller.Configuration.UnhealthyDuration
+}
+
+// inTakeoverGracePeriod reports whether the controller became leader less than the configured takeover
+// grace period ago. During that time no assigned addresses are moved.
+func (controller *Controller) inTakeoverGracePeriod(now time.Time) bool {
+	if controller.leadingSince.IsZero() {
+		return false
+	}
+	return now.Sub(controller.leadingSince) < controller.Configuration.TakeoverGracePeriod
+}
@@ -0,0 +1,131 @@
+package fipcontroller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	"github.com/sirupsen/logrus"
+
+	"github.com/cbeneke/hcloud-fip-controller/internal/pkg/configuration"
+)
+
+func TestIsServerFailed(t *testing.T) {
+	start := time.Now()
+	tests := []struct {
+		name         string
+		threshold    int
+		duration     time.Duration
+		observations []time.Time
+		failed       bool
+	}{
+		{
+			name:         "default configuration fails immediately",
+			observations: []time.Time{start},
+			failed:       true,
+		},
+		{
+			name:         "threshold not reached",
+			threshold:    3,
+			observations: []time.Time{start, start.Add(defaultResyncInterval)},
+			failed:       false,
+		},
+		{
+			name:         "threshold reached",
+			threshold:    3,
+			observations: []time.Time{start, start.Add(defaultResyncInterval), start.Add(2 * defaultResyncInterval)},
+			failed:       true,
+		},
+		{
+			name:         "burst of reconciliations counts once per resync interval",
+			threshold:    3,
+			observations: []time.Time{start, start.Add(time.Second), start.Add(2 * time.Second), start.Add(3 * time.Second)},
+			failed:       false,
+		},
+		{
+			name:         "duration not reached",
+			duration:     time.Minute,
+			observations: []time.Time{start, start.Add(30 * time.Second)},
+			failed:       false,
+		},
+		{
+			name:         "duration reached",
+			duration:     time.Minute,
+			observations: []time.Time{start, start.Add(time.Minute)},
+			failed:       true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			controller := Controller{
+				Configuration: &configuration.Configuration{
+					UnhealthyThreshold: test.threshold,
+					UnhealthyDuration:  test.duration,
+				},
+				Logger: logrus.New(),
+			}
+
+			failedServer := &hcloud.Server{ID: 2}
+			runningServers := []*hcloud.Server{{ID: 1}}
+			addresses := []*Address{{ID: 1, Server: failedServer}, {ID: 2, Server: failedServer}}
+
+			var now time.Time
+			for _, now = range test.observations {
+				controller.observeUnhealthyServers(runningServers, addressHolders(addresses), now)
+			}
+
+			failed := controller.isServerFailed(failedServer, now)
+			if failed != test.failed {
+				t.Fatalf("server failed should be %t but was %t", test.failed, failed)
+			}
+		})
+	}
+}
+
+func TestObserveUnhealthyServersResets(t *testing.T) {
+	controller := Controller{
+		Configuration: &configuration.Configuration{UnhealthyThreshold: 2},
+		Logger:        logrus.New(),
+	}
+
+	server := &hcloud.Server{ID: 1}
+	addresses := []*Address{{ID: 1, Server: server}}
+	now := time.Now()
+
+	controller.observeUnhealthyServers(nil, addressHolders(addresses), now)
+	// Server is running again, which resets the consecutive observations
+	controller.observeUnhealthyServers([]*hcloud.Server{server}, addressHolders(addresses), now)
+	controller.observeUnhealthyServers(nil, addressHolders(addresses), now)
+
+	if controller.isServerFailed(server, now) {
+		t.Fatal("server should not be failed after its observations were reset")
+	}
+}
+
+func TestInTakeoverGracePeriod(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name         string
+		leadingSince time.Time
+		grace        time.Duration
+		result       bool
+	}{
+		{name: "not leading", grace: time.Minute, result: false},
+		{name: "within grace period", leadingSince: now.Add(-30 * time.Second), grace: time.Minute, result: true},
+		{name: "after grace period", leadingSince: now.Add(-2 * time.Minute), grace: time.Minute, result: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			controller := Controller{
+				Configuration: &configuration.Configuration{TakeoverGracePeriod: test.grace},
+				leadingSince:  test.leadingSince,
+			}
+
+			if result := controller.inTakeoverGracePeriod(now); result != test.result {
+				t.Fatalf("grace period should be %t but was %t", test.result, result)
+			}
+		})
+	}
+}
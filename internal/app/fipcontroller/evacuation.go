@@ -0,0 +1,92 @@
+package fipcontroller
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/cbeneke/hcloud-fip-controller/internal/pkg/configuration"
+)
+
+// evacuationEnabled reports whether any evacuation rule is configured
+func (controller *Controller) evacuationEnabled() bool {
+	config := controller.Configuration
+	return config.EvacuateUnschedulable || config.EvacuateNoExecute || len(config.EvacuateTaints) > 0
+}
+
+// observeEvacuatingServers finds the running servers backing nodes which match an evacuation rule. Addresses
+// are moved off these servers although they are still running, and no addresses are assigned to them.
+func (controller *Controller) observeEvacuatingServers(ctx context.Context, runningServers []*hcloud.Server) error {
+	controller.evacuatingServers = nil
+	if !controller.evacuationEnabled() {
+		return nil
+	}
+
+	nodes, err := controller.nodes(ctx)
+	if err != nil {
+		return err
+	}
+
+	evacuating := make(map[int64]string)
+	for _, node := range nodes.Items {
+		rule := controller.evacuationRule(node)
+		if rule == "" {
+			continue
+		}
+		for _, server := range controller.serversForNodes([]corev1.Node{node}, runningServers) {
+			evacuating[server.ID] = fmt.Sprintf("node '%s' is %s", node.Name, rule)
+		}
+	}
+	controller.Logger.Debugf("Found %d servers to evacuate", len(evacuating))
+	controller.evacuatingServers = evacuating
+	return nil
+}
+
+// evacuationRule returns the description of the evacuation rule the node matches, or an empty string if
+// addresses can stay on the node
+func (controller *Controller) evacuationRule(node corev1.Node) string {
+	config := controller.Configuration
+	if config.EvacuateUnschedulable && node.Spec.Unschedulable {
+		return "unschedulable"
+	}
+	for _, taint := range node.Spec.Taints {
+		if config.EvacuateNoExecute && taint.Effect == corev1.TaintEffectNoExecute {
+			return fmt.Sprintf("tainted with '%s'", taint.ToString())
+		}
+		for _, rule := range config.EvacuateTaints {
+			taintRule, err := configuration.ParseTaintRule(rule)
+			if err == nil && taintRuleMatches(taintRule, taint) {
+				return fmt.Sprintf("tainted with '%s'", taint.ToString())
+			}
+		}
+	}
+	return ""
+}
+
+// Check if the taint matches the rule. Empty values and effects of the rule match any value and effect
+func taintRuleMatches(rule configuration.TaintRule, taint corev1.Taint) bool {
+	return taint.Key == rule.Key &&
+		(rule.Value == "" || taint.Value == rule.Value) &&
+		(rule.Effect == "" || string(taint.Effect) == rule.Effect)
+}
+
+// isServerEvacuating reports whether addresses should be moved off the server
+func (controller *Controller) isServerEvacuating(server *hcloud.Server) bool {
+	_, ok := controller.evacuatingServers[server.ID]
+	return ok
+}
+
+// Return the given servers without the servers which are evacuated
+func (controller *Controller) withoutEvacuatingServers(servers []*hcloud.Server) (result []*hcloud.Server) {
+	if len(controller.evacuatingServers) < 1 {
+		return servers
+	}
+	for _, server := range servers {
+		if !controller.isServerEvacuating(server) {
+			result = append(result, server)
+		}
+	}
+	return result
+}
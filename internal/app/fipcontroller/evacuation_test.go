@@ -0,0 +1,162 @@
+package fipcontroller
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/cbeneke/hcloud-fip-controller/internal/pkg/configuration"
+)
+
+func TestEvacuationRule(t *testing.T) {
+	maintenance := v1.Taint{Key: "maintenance", Value: "planned", Effect: v1.TaintEffectNoSchedule}
+	noExecute := v1.Taint{Key: "example.com/drain", Effect: v1.TaintEffectNoExecute}
+
+	tests := []struct {
+		name          string
+		config        *configuration.Configuration
+		unschedulable bool
+		taints        []v1.Taint
+		evacuate      bool
+	}{
+		{
+			name:          "cordoned node without rule",
+			config:        &configuration.Configuration{},
+			unschedulable: true,
+			evacuate:      false,
+		},
+		{
+			name:          "cordoned node",
+			config:        &configuration.Configuration{EvacuateUnschedulable: true},
+			unschedulable: true,
+			evacuate:      true,
+		},
+		{
+			name:     "schedulable node",
+			config:   &configuration.Configuration{EvacuateUnschedulable: true},
+			evacuate: false,
+		},
+		{
+			name:     "matching taint key",
+			config:   &configuration.Configuration{EvacuateTaints: []string{"maintenance"}},
+			taints:   []v1.Taint{maintenance},
+			evacuate: true,
+		},
+		{
+			name:     "matching taint key, value and effect",
+			config:   &configuration.Configuration{EvacuateTaints: []string{"maintenance=planned:NoSchedule"}},
+			taints:   []v1.Taint{maintenance},
+			evacuate: true,
+		},
+		{
+			name:     "other taint value",
+			config:   &configuration.Configuration{EvacuateTaints: []string{"maintenance=unplanned"}},
+			taints:   []v1.Taint{maintenance},
+			evacuate: false,
+		},
+		{
+			name:     "other taint effect",
+			config:   &configuration.Configuration{EvacuateTaints: []string{"maintenance:NoExecute"}},
+			taints:   []v1.Taint{maintenance},
+			evacuate: false,
+		},
+		{
+			name:     "no execute taint",
+			config:   &configuration.Configuration{EvacuateNoExecute: true},
+			taints:   []v1.Taint{maintenance, noExecute},
+			evacuate: true,
+		},
+		{
+			name:     "no execute rule ignores other effects",
+			config:   &configuration.Configuration{EvacuateNoExecute: true},
+			taints:   []v1.Taint{maintenance},
+			evacuate: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			controller := Controller{
+				Configuration: test.config,
+				Logger:        logrus.New(),
+			}
+			node := v1.Node{Spec: v1.NodeSpec{Unschedulable: test.unschedulable, Taints: test.taints}}
+
+			rule := controller.evacuationRule(node)
+			if (rule != "") != test.evacuate {
+				t.Fatalf("evacuation should be %t but rule was '%s'", test.evacuate, rule)
+			}
+		})
+	}
+}
+
+func TestReconcileEvacuation(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    *configuration.Configuration
+		resultIDs map[int64]int64
+	}{
+		{
+			name:      "evacuate address off cordoned node",
+			config:    &configuration.Configuration{EvacuateUnschedulable: true},
+			resultIDs: map[int64]int64{1: 2, 2: 2},
+		},
+		{
+			name:      "keep address without evacuation rule",
+			config:    &configuration.Configuration{},
+			resultIDs: map[int64]int64{2: 2},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			servers := []*hcloud.Server{
+				{ID: 1, Name: "server-1", PublicNet: hcloud.ServerPublicNet{IPv4: hcloud.ServerPublicNetIPv4{IP: net.ParseIP("1.1.1.1")}}},
+				{ID: 2, Name: "server-2", PublicNet: hcloud.ServerPublicNet{IPv4: hcloud.ServerPublicNetIPv4{IP: net.ParseIP("2.2.2.2")}}},
+			}
+			cordoned := createTestNode("node-1", []v1.NodeAddress{{Type: v1.NodeExternalIP, Address: "1.1.1.1"}}, v1.ConditionTrue)
+			cordoned.Spec.Unschedulable = true
+
+			provider := newFakeProvider([]*Address{
+				{ID: 1, IP: net.ParseIP("10.0.0.1"), Server: servers[0]},
+				{ID: 2, IP: net.ParseIP("10.0.0.2")},
+			}, servers)
+			controller := Controller{
+				Providers: []IPProvider{provider},
+				KubernetesClient: fake.NewSimpleClientset(
+					cordoned,
+					createTestNode("node-2", []v1.NodeAddress{{Type: v1.NodeExternalIP, Address: "2.2.2.2"}}, v1.ConditionTrue),
+				),
+				Backoff: wait.Backoff{
+					Steps: 1,
+				},
+				Configuration: test.config,
+				Logger:        logrus.New(),
+			}
+
+			if err := controller.observeEvacuatingServers(context.Background(), servers); err != nil {
+				t.Fatalf("error should be [nil] but was [%v]", err)
+			}
+			err := controller.reconcileAddresses(context.Background(), provider, servers, provider.addresses, time.Now())
+			if err != nil {
+				t.Fatalf("error should be [nil] but was [%v]", err)
+			}
+
+			if len(provider.assigned) != len(test.resultIDs) {
+				t.Fatalf("assignments should be %v but were %v", test.resultIDs, provider.assigned)
+			}
+			for id, serverID := range test.resultIDs {
+				if provider.assigned[id] != serverID {
+					t.Fatalf("assignments should be %v but were %v", test.resultIDs, provider.assigned)
+				}
+			}
+		})
+	}
+}
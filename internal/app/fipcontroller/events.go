@@ -0,0 +1,160 @@
+package fipcontroller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// Reasons of the kubernetes events emitted on nodes and on followed services and pods
+const (
+	eventIPMovedIn       = "IPMovedIn"
+	eventIPMovedOut      = "IPMovedOut"
+	eventNoHealthyTarget = "NoHealthyTarget"
+	eventAssignFailed    = "AssignFailed"
+)
+
+// eventMoved emits events for the move of the address from the previous server to the new one on both nodes, and
+// on the followed service and pods on the new node
+func (controller *Controller) eventMoved(ctx context.Context, provider IPProvider, address *Address, previous, server *hcloud.Server, reason string, followService string) {
+	if !controller.eventsEnabled() {
+		return
+	}
+	to := controller.serverNode(ctx, provider, server)
+	from := "no server"
+	if previous != nil {
+		previousNode := controller.serverNode(ctx, provider, previous)
+		from = eventServerName(previousNode, previous)
+		controller.eventf(previousNode, corev1.EventTypeNormal, eventIPMovedOut, "Moved %s IP '%s' to %s (reason: %s)",
+			provider.Kind(), address.IP.String(), eventServerName(to, server), reason)
+	}
+	controller.eventf(to, corev1.EventTypeNormal, eventIPMovedIn, "Moved %s IP '%s' here from %s (reason: %s)",
+		provider.Kind(), address.IP.String(), from, reason)
+
+	for _, object := range controller.followedObjects(ctx, to, followService) {
+		controller.eventf(object, corev1.EventTypeNormal, eventIPMovedIn, "Moved %s IP '%s' to %s from %s (reason: %s)",
+			provider.Kind(), address.IP.String(), eventServerName(to, server), from, reason)
+	}
+}
+
+// eventNoHealthyTarget emits a warning that the address can not be (re)assigned on the node currently holding it
+// and on the followed service
+func (controller *Controller) eventNoHealthyTarget(ctx context.Context, provider IPProvider, address *Address, followService string, message string) {
+	if !controller.eventsEnabled() {
+		return
+	}
+	var node *corev1.Node
+	if address.Server != nil {
+		node = controller.serverNode(ctx, provider, address.Server)
+	}
+	objects := append([]runtime.Object{node}, controller.followedObjects(ctx, nil, followService)...)
+	for _, object := range objects {
+		controller.eventf(object, corev1.EventTypeWarning, eventNoHealthyTarget, "No healthy target for %s IP '%s': %s",
+			provider.Kind(), address.IP.String(), message)
+	}
+}
+
+// eventAssignFailed emits a warning on the node the address could not be assigned to and on the followed service
+// and pods on that node
+func (controller *Controller) eventAssignFailed(ctx context.Context, provider IPProvider, address *Address, server *hcloud.Server, followService string, err error) {
+	if !controller.eventsEnabled() {
+		return
+	}
+	node := controller.serverNode(ctx, provider, server)
+	objects := append([]runtime.Object{node}, controller.followedObjects(ctx, node, followService)...)
+	for _, object := range objects {
+		controller.eventf(object, corev1.EventTypeWarning, eventAssignFailed, "Could not assign %s IP '%s' to %s: %v",
+			provider.Kind(), address.IP.String(), eventServerName(node, server), err)
+	}
+}
+
+// Events are skipped without a recorder, e.g. in tests, and in dry run mode
+func (controller *Controller) eventsEnabled() bool {
+	return controller.Recorder != nil && !controller.Configuration.DryRun
+}
+
+// eventf emits the event on the object. Nil objects, e.g. servers which are no node, are skipped.
+func (controller *Controller) eventf(object runtime.Object, eventType, reason, messageFmt string, args ...interface{}) {
+	switch typed := object.(type) {
+	case nil:
+		return
+	case *corev1.Node:
+		if typed == nil {
+			return
+		}
+	}
+	controller.Recorder.Eventf(object, eventType, reason, messageFmt, args...)
+}
+
+// serverNode returns the kubernetes node backed by the server, or nil if the server is no node. Servers without
+// public net, e.g. the partial servers of addresses, are looked up in the servers of the provider first.
+func (controller *Controller) serverNode(ctx context.Context, provider IPProvider, server *hcloud.Server) *corev1.Node {
+	nodes, err := controller.nodes(ctx)
+	if err != nil {
+		controller.Logger.Debugf("Could not find the node of server %d for events: %v", server.ID, err)
+		return nil
+	}
+	if node := controller.nodeForServer(nodes, server); node != nil {
+		return node
+	}
+
+	servers, err := controller.providerServers(ctx, provider)
+	if err != nil {
+		controller.Logger.Debugf("Could not find the node of server %d for events: %v", server.ID, err)
+		return nil
+	}
+	for _, candidate := range servers {
+		if candidate.ID == server.ID {
+			return controller.nodeForServer(nodes, candidate)
+		}
+	}
+	return nil
+}
+
+// followedObjects returns the service followed by the address and, with a pod label selector configured, the
+// running pods the address follows on the given node
+func (controller *Controller) followedObjects(ctx context.Context, node *corev1.Node, followService string) (objects []runtime.Object) {
+	if namespace, name, ok := strings.Cut(followService, "/"); ok {
+		var service *corev1.Service
+		var err error
+		err = controller.retry(operationGetService, func() error {
+			service, err = controller.KubernetesClient.CoreV1().Services(namespace).Get(ctx, name, metav1.GetOptions{})
+			return err
+		})
+		if err != nil {
+			controller.Logger.Debugf("Could not get service '%s' for events: %v", followService, err)
+		} else {
+			objects = append(objects, service)
+		}
+	}
+
+	if node != nil && controller.Configuration.PodLabelSelector != "" {
+		pods, err := controller.listRunningPods(ctx, controller.Configuration.PodLabelSelector)
+		if err != nil {
+			controller.Logger.Debugf("Could not list pods on node '%s' for events: %v", node.Name, err)
+			return objects
+		}
+		for i := range pods.Items {
+			if pods.Items[i].Spec.NodeName == node.Name {
+				objects = append(objects, &pods.Items[i])
+			}
+		}
+	}
+	return objects
+}
+
+// eventServerName describes the server in event messages by its node, or by its name if it is no node
+func eventServerName(node *corev1.Node, server *hcloud.Server) string {
+	if node != nil {
+		return fmt.Sprintf("node '%s'", node.Name)
+	}
+	if server.Name == "" {
+		return fmt.Sprintf("server %d", server.ID)
+	}
+	return fmt.Sprintf("server '%s'", server.Name)
+}
@@ -0,0 +1,153 @@
+package fipcontroller
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/cbeneke/hcloud-fip-controller/internal/pkg/configuration"
+)
+
+func TestReconcileEvents(t *testing.T) {
+	tests := []struct {
+		name    string
+		server  *hcloud.Server
+		labels  map[string]string
+		failing bool
+		dryRun  bool
+		events  []string
+	}{
+		{
+			name:   "fail over address",
+			server: &hcloud.Server{ID: 3},
+			events: []string{
+				"Normal IPMovedOut Moved floating IP '10.0.0.1' to node 'node-1' (reason: failover)",
+				"Normal IPMovedIn Moved floating IP '10.0.0.1' here from node 'node-3' (reason: failover)",
+			},
+		},
+		{
+			name:    "assign failed",
+			failing: true,
+			events: []string{
+				"Warning AssignFailed Could not assign floating IP '10.0.0.1' to node 'node-1'",
+			},
+		},
+		{
+			name:   "no healthy target",
+			server: &hcloud.Server{ID: 1},
+			labels: map[string]string{LabelNodeSelectorPrefix + "role": "edge"},
+			events: []string{
+				"Warning NoHealthyTarget No healthy target for floating IP '10.0.0.1': no running server matches its policy",
+			},
+		},
+		{
+			name:   "dry run emits no events",
+			server: &hcloud.Server{ID: 3},
+			dryRun: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			servers := []*hcloud.Server{
+				{ID: 1, Name: "server-1", PublicNet: hcloud.ServerPublicNet{IPv4: hcloud.ServerPublicNetIPv4{IP: net.ParseIP("1.1.1.1")}}},
+				{ID: 3, Name: "server-3", PublicNet: hcloud.ServerPublicNet{IPv4: hcloud.ServerPublicNetIPv4{IP: net.ParseIP("3.3.3.3")}}},
+			}
+			address := &Address{ID: 1, IP: net.ParseIP("10.0.0.1"), Server: test.server, Labels: test.labels}
+			provider := newFakeProvider([]*Address{address}, servers)
+			if test.failing {
+				provider.failing = map[int64]error{1: errors.New("server error")}
+			}
+
+			recorder := record.NewFakeRecorder(10)
+			controller := Controller{
+				Providers: []IPProvider{provider},
+				KubernetesClient: fake.NewSimpleClientset(
+					createTestNode("node-1", []v1.NodeAddress{{Type: v1.NodeExternalIP, Address: "1.1.1.1"}}, v1.ConditionTrue),
+					createTestNode("node-3", []v1.NodeAddress{{Type: v1.NodeExternalIP, Address: "3.3.3.3"}}, v1.ConditionFalse),
+				),
+				Backoff: wait.Backoff{
+					Steps: 1,
+				},
+				Configuration: &configuration.Configuration{
+					NodeAddressType: configuration.NodeAddressTypeExternal,
+					DryRun:          test.dryRun,
+				},
+				Logger:   logrus.New(),
+				Recorder: recorder,
+			}
+
+			running := servers[:1]
+			now := time.Now()
+			controller.observeUnhealthyServers(running, addressHolders(provider.addresses), now)
+			_ = controller.reconcileAddresses(context.Background(), provider, running, provider.addresses, now)
+
+			close(recorder.Events)
+			var events []string
+			for event := range recorder.Events {
+				events = append(events, event)
+			}
+			if len(events) != len(test.events) {
+				t.Fatalf("events should be %v but were %v", test.events, events)
+			}
+			for i, event := range test.events {
+				if !strings.HasPrefix(events[i], event) {
+					t.Fatalf("event should start with '%s' but was '%s'", event, events[i])
+				}
+			}
+		})
+	}
+}
+
+func TestFollowedObjects(t *testing.T) {
+	node := createTestNode("node-1", nil, v1.ConditionTrue)
+	controller := Controller{
+		KubernetesClient: fake.NewSimpleClientset(
+			&v1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "ingress", Name: "web"}},
+			&v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "ingress", Name: "web-1", Labels: map[string]string{"app": "web"}},
+				Spec:       v1.PodSpec{NodeName: "node-1"},
+				Status:     v1.PodStatus{Phase: v1.PodRunning},
+			},
+			&v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "ingress", Name: "web-2", Labels: map[string]string{"app": "web"}},
+				Spec:       v1.PodSpec{NodeName: "node-2"},
+				Status:     v1.PodStatus{Phase: v1.PodRunning},
+			},
+		),
+		Backoff: wait.Backoff{
+			Steps: 1,
+		},
+		Configuration: &configuration.Configuration{
+			Namespace:        "ingress",
+			PodLabelSelector: "app=web",
+		},
+		Logger: logrus.New(),
+	}
+
+	objects := controller.followedObjects(context.Background(), node, "ingress/web")
+	if len(objects) != 2 {
+		t.Fatalf("followed service and pod on the node should be returned but were %v", objects)
+	}
+	if service, ok := objects[0].(*v1.Service); !ok || service.Name != "web" {
+		t.Fatalf("first object should be service 'web' but was %v", objects[0])
+	}
+	if pod, ok := objects[1].(*v1.Pod); !ok || pod.Name != "web-1" {
+		t.Fatalf("second object should be pod 'web-1' but was %v", objects[1])
+	}
+
+	if objects := controller.followedObjects(context.Background(), node, "ingress/missing"); len(objects) != 1 {
+		t.Fatalf("only the pod should be returned for a missing service but were %v", objects)
+	}
+}
@@ -3,62 +3,238 @@ package fipcontroller
 import (
 	"context"
 	"fmt"
-	"k8s.io/client-go/util/retry"
 	"net"
+	"net/http"
 
 	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+
+	"github.com/cbeneke/hcloud-fip-controller/internal/pkg/configuration"
 )
 
-func newHetznerClient(token string) (*hcloud.Client, error) {
-	hetznerClient := hcloud.NewClient(hcloud.WithToken(token))
+func newHetznerClient(token string, rateLimitHeadroom int) (*hcloud.Client, error) {
+	hetznerClient := hcloud.NewClient(
+		hcloud.WithToken(token),
+		hcloud.WithHTTPClient(&http.Client{Transport: newRateLimitTransport(http.DefaultTransport, rateLimitHeadroom)}),
+	)
 	return hetznerClient, nil
 }
 
-// Search and return the hcloud floatingIP object for a given string representation of a IPv4 or IPv6 address
-func (controller *Controller) floatingIP(ctx context.Context, ipAddress string) (ip *hcloud.FloatingIP, err error) {
-	var ips []*hcloud.FloatingIP
-	err = retry.OnError(controller.Backoff, alwaysRetry, func() error {
-		ips, err = controller.HetznerClient.FloatingIP.All(ctx)
-		return err
-	})
+// Create the hcloud IPProviders for all address kinds enabled in the configuration.
+// Floating IPs are managed unless they are managed through FloatingIPPools.
+func newHcloudProviders(client *hcloud.Client, config *configuration.Configuration) []IPProvider {
+	// The providers share their servers and with it the cache, so servers are only listed once
+	servers := newHcloudServers(client, config)
+
+	var providers []IPProvider
+	if !config.FloatingIPPools {
+		floatingIPs := newFloatingIPProvider(client, config)
+		floatingIPs.hcloudServers = servers
+		providers = append(providers, floatingIPs)
+	}
+	if config.PrimaryIPLabelSelector != "" {
+		primaryIPs := newPrimaryIPProvider(client, config)
+		primaryIPs.hcloudServers = servers
+		providers = append(providers, primaryIPs)
+	}
+	if len(config.AliasIPs) > 0 {
+		aliasIPs := newAliasIPProvider(client, config)
+		aliasIPs.hcloudServers = servers
+		providers = append(providers, aliasIPs)
+	}
+	return providers
+}
+
+// Create the factory of the floating IP providers of FloatingIPPools. The providers of all pools share their
+// servers and with it the cache.
+func newHcloudPoolProvider(client *hcloud.Client, config *configuration.Configuration) func(*configuration.Configuration) IPProvider {
+	servers := newHcloudServers(client, config)
+	return func(poolConfig *configuration.Configuration) IPProvider {
+		floatingIPs := newFloatingIPProvider(client, poolConfig)
+		floatingIPs.hcloudServers = servers
+		return floatingIPs
+	}
+}
+
+// hcloudServers implements the server and action handling shared by all hcloud IPProviders
+type hcloudServers struct {
+	client *hcloud.Client
+	// cache holds the results of hcloud list calls between reconciliations
+	cache *hcloudCache
+	// labelSelector restricts the servers, e.g. to the servers of the cluster. All servers are used if empty
+	labelSelector string
+}
+
+func newHcloudServers(client *hcloud.Client, config *configuration.Configuration) hcloudServers {
+	return hcloudServers{
+		client:        client,
+		cache:         newHcloudCache(config.HcloudCacheTTL),
+		labelSelector: config.ServerLabelSelector,
+	}
+}
+
+func (provider hcloudServers) Servers(ctx context.Context) ([]*hcloud.Server, error) {
+	return provider.cache.servers(ctx, provider.client, provider.labelSelector)
+}
+
+// ServerByID gets the server with the given ID, regardless of the server label selector. Returns nil if the
+// server does not exist.
+func (provider hcloudServers) ServerByID(ctx context.Context, id int64) (*hcloud.Server, error) {
+	return provider.cache.server(ctx, provider.client, id)
+}
+
+// ServerByName gets the server with the given name, regardless of the server label selector. Returns nil if
+// the server does not exist.
+func (provider hcloudServers) ServerByName(ctx context.Context, name string) (*hcloud.Server, error) {
+	return provider.cache.serverByName(ctx, provider.client, name)
+}
+
+func (provider hcloudServers) WaitForAction(ctx context.Context, action *hcloud.Action) error {
+	if action == nil {
+		return nil
+	}
+	return provider.client.Action.WaitFor(ctx, action)
+}
+
+// floatingIPProvider manages hcloud floating IPs
+type floatingIPProvider struct {
+	hcloudServers
+	// floatingIPs are the configured floating IP addresses. If empty, floatingIPs are discovered by the label selector
+	floatingIPs   []string
+	labelSelector string
+}
+
+func newFloatingIPProvider(client *hcloud.Client, config *configuration.Configuration) *floatingIPProvider {
+	return &floatingIPProvider{
+		hcloudServers: newHcloudServers(client, config),
+		floatingIPs:   config.HcloudFloatingIPs,
+		labelSelector: config.FloatingIPLabelSelector,
+	}
+}
+
+func (provider *floatingIPProvider) Kind() string {
+	return kindFloatingIP
+}
+
+// Addresses fetches all floatingIPs from hetzner api with optional label selector.
+// For backwards compatibility this still uses hardcoded ips if specified in config
+func (provider *floatingIPProvider) Addresses(ctx context.Context) ([]*Address, error) {
+	// Fetch ips from hetzner api with optional LabelSelector. It is ignored for hardcoded ips
+	labelSelector := ""
+	if len(provider.floatingIPs) < 1 {
+		labelSelector = provider.labelSelector
+	}
+	floatingIPs, err := provider.cache.floatingIPs(ctx, provider.client, labelSelector)
 	if err != nil {
-		return nil, fmt.Errorf("could not fetch floating IPs: %v", err)
+		return nil, err
 	}
-	controller.Logger.Debugf("Fetched %d IP addresses", len(ips))
 
-	for _, ip := range ips {
-		if ip.Type == hcloud.FloatingIPTypeIPv4 && ip.IP.Equal(net.ParseIP(ipAddress)) {
-			return ip, nil
+	var addresses []*Address
+	if len(provider.floatingIPs) > 0 {
+		for _, floatingIPAddr := range provider.floatingIPs {
+			address, err := floatingIPAddress(floatingIPs, floatingIPAddr)
+			if err != nil {
+				return nil, err
+			}
+			addresses = append(addresses, address)
 		}
-		if ip.Type == hcloud.FloatingIPTypeIPv6 && ip.Network.Contains(net.ParseIP(ipAddress)) {
-			return ip, nil
+		return addresses, nil
+	}
+
+	for _, floatingIP := range floatingIPs {
+		addresses = append(addresses, addressFromFloatingIP(floatingIP))
+	}
+	return addresses, nil
+}
+
+func (provider *floatingIPProvider) Placement(servers []*hcloud.Server, address *Address) ([]*hcloud.Server, string) {
+	return placementCandidates(servers, address)
+}
+
+func (provider *floatingIPProvider) Assign(ctx context.Context, address *Address, server *hcloud.Server) (*hcloud.Action, error) {
+	defer provider.cache.invalidate()
+	action, response, err := provider.client.FloatingIP.Assign(ctx, &hcloud.FloatingIP{ID: address.ID}, server)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != 201 {
+		return nil, fmt.Errorf("got HTTP Code %d, expected 201", response.StatusCode)
+	}
+	return action, nil
+}
+
+func (provider *floatingIPProvider) Unassign(ctx context.Context, address *Address) (*hcloud.Action, error) {
+	defer provider.cache.invalidate()
+	action, _, err := provider.client.FloatingIP.Unassign(ctx, &hcloud.FloatingIP{ID: address.ID})
+	return action, err
+}
+
+func (provider *floatingIPProvider) Refresh(ctx context.Context, address *Address) (*Address, error) {
+	floatingIP, _, err := provider.client.FloatingIP.GetByID(ctx, address.ID)
+	if err != nil {
+		return nil, err
+	}
+	if floatingIP == nil {
+		return nil, fmt.Errorf("floating IP %d not found", address.ID)
+	}
+	return addressFromFloatingIP(floatingIP), nil
+}
+
+func (provider *floatingIPProvider) UpdateLabels(ctx context.Context, address *Address, labels map[string]string) error {
+	defer provider.cache.invalidate()
+	_, _, err := provider.client.FloatingIP.Update(ctx, &hcloud.FloatingIP{ID: address.ID}, hcloud.FloatingIPUpdateOpts{Labels: labels})
+	return err
+}
+
+// Search and return the address of the floating IP for a given string representation of a IPv4 or IPv6 address
+func floatingIPAddress(floatingIPs []*hcloud.FloatingIP, ipAddress string) (*Address, error) {
+	for _, floatingIP := range floatingIPs {
+		address := addressFromFloatingIP(floatingIP)
+		if addressMatches(address, net.ParseIP(ipAddress)) {
+			return address, nil
 		}
 	}
 	return nil, fmt.Errorf("IP address '%s' not allocated", ipAddress)
 }
 
-// Search and return the hcloud Server objects for a given list of IP addresses.
-// The IP Addresses can be public IPv4, IPv6 addresses or private addresses attached to any private network interface
-func (controller *Controller) servers(ctx context.Context, ips [][]net.IP) (serverList []*hcloud.Server, err error) {
+// Convert the hcloud floating IP to an address
+func addressFromFloatingIP(floatingIP *hcloud.FloatingIP) *Address {
+	address := &Address{
+		ID:       floatingIP.ID,
+		IP:       floatingIP.IP,
+		Labels:   floatingIP.Labels,
+		Location: floatingIP.HomeLocation,
+		Server:   floatingIP.Server,
+	}
+	if floatingIP.Type == hcloud.FloatingIPTypeIPv6 {
+		address.Network = floatingIP.Network
+	}
+	return address
+}
+
+// Search and return the hcloud Server objects of the provider for a given list of IP addresses.
+// The IP Addresses can be public IPv4, IPv6 addresses or private addresses attached to any private network interface.
+// IP addresses without a matching server are skipped with a warning.
+func (controller *Controller) servers(ctx context.Context, provider IPProvider, ips [][]net.IP) (serverList []*hcloud.Server, err error) {
 	// Fetch all hetzner servers
-	var servers []*hcloud.Server
-	err = retry.OnError(controller.Backoff, alwaysRetry, func() error {
-		servers, err = controller.HetznerClient.Server.All(ctx)
-		return err
-	})
+	servers, err := controller.providerServers(ctx, provider)
 	if err != nil {
-		return nil, fmt.Errorf("could not fetch servers: %v", err)
+		return nil, err
 	}
 	controller.Logger.Debugf("Fetched %d servers", len(servers))
 
+	unmatched := 0
 	for _, ip := range ips {
-		// check if a server was found for the given ip, if not throw error
+		// Nodes without a server, e.g. nodes of another cloud or servers filtered by the server label selector,
+		// can not hold addresses and are skipped
 		server := controller.searchServerForIP(servers, ip)
 		if server == nil {
-			return nil, fmt.Errorf("Could not find an a server for ip '%s'", ip)
+			controller.Logger.WithField("kind", provider.Kind()).Warnf("Skipping node with IPs %v, no server found for it", ip)
+			unmatched++
+			continue
 		}
 		serverList = append(serverList, server)
 	}
+	unmatchedNodes.WithLabelValues(provider.Kind()).Set(float64(unmatched))
 	return serverList, nil
 }
 
@@ -83,40 +259,3 @@ func (controller *Controller) searchServerForIP(servers []*hcloud.Server, ips []
 	}
 	return nil
 }
-
-// Fetches all floatingIPs from hetzner api with optional label selector.
-// For backwards compatibility this still uses hardcoded ips if specified in config
-func (controller *Controller) getFloatingIPs(ctx context.Context) ([]*hcloud.FloatingIP, error) {
-	// Use hardcoded ips if specified
-	// TODO fetch FloatingIPs once beforehand (maybe????)
-	if len(controller.Configuration.HcloudFloatingIPs) > 0 {
-		floatingIPs := []*hcloud.FloatingIP{}
-		for _, floatingIPAddr := range controller.Configuration.HcloudFloatingIPs {
-			floatingIP, err := controller.floatingIP(ctx, floatingIPAddr)
-			if err != nil {
-				return nil, fmt.Errorf("could not get floating IP '%s': %v", floatingIPAddr, err)
-			}
-			floatingIPs = append(floatingIPs, floatingIP)
-		}
-		return floatingIPs, nil
-	}
-
-	// Fetch ips from hetzner api with optional LabelSelector
-	floatingIPListOpts := hcloud.FloatingIPListOpts{}
-	if controller.Configuration.FloatingIPLabelSelector != "" {
-		listOpts := hcloud.ListOpts{}
-		listOpts.LabelSelector = controller.Configuration.FloatingIPLabelSelector
-		floatingIPListOpts = hcloud.FloatingIPListOpts{ListOpts: listOpts}
-	}
-	var floatingIPs []*hcloud.FloatingIP
-	var err error
-
-	err = retry.OnError(retry.DefaultBackoff, alwaysRetry, func() error {
-		floatingIPs, err = controller.HetznerClient.FloatingIP.AllWithOpts(ctx, floatingIPListOpts)
-		return err
-	})
-	if err != nil {
-		return nil, fmt.Errorf("could not get floating IPs: %v", err)
-	}
-	return floatingIPs, nil
-}
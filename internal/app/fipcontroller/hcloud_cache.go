@@ -0,0 +1,158 @@
+package fipcontroller
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+)
+
+// Resources cached by the hcloud cache, used as resource label values
+const (
+	cacheResourceServers     = "servers"
+	cacheResourceFloatingIPs = "floating_ips"
+	cacheResourcePrimaryIPs  = "primary_ips"
+	cacheResourceNetworks    = "networks"
+)
+
+// hcloudCache caches the results of hcloud list and get calls shared by all hcloud IPProviders for a TTL.
+// All entries are invalidated whenever an address is changed, so the next reconciliation sees the change.
+// A TTL of 0 disables the cache.
+type hcloudCache struct {
+	ttl time.Duration
+
+	mutex   sync.Mutex
+	entries map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	value   interface{}
+	expires time.Time
+}
+
+func newHcloudCache(ttl time.Duration) *hcloudCache {
+	return &hcloudCache{
+		ttl:     ttl,
+		entries: make(map[string]cacheEntry),
+	}
+}
+
+// invalidate drops all cached entries
+func (cache *hcloudCache) invalidate() {
+	if cache == nil {
+		return
+	}
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	cache.entries = make(map[string]cacheEntry)
+}
+
+// cached returns the cached value of the resource with the given key, or fetches and caches it if it is
+// missing or expired. Errors are not cached.
+func cached[T any](cache *hcloudCache, resource, key string, fetch func() (T, error)) (T, error) {
+	if cache == nil || cache.ttl <= 0 {
+		return fetch()
+	}
+
+	cacheKey := resource + "/" + key
+	cache.mutex.Lock()
+	entry, ok := cache.entries[cacheKey]
+	cache.mutex.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		hcloudCacheRequestsTotal.WithLabelValues(resource, "hit").Inc()
+		return entry.value.(T), nil
+	}
+	hcloudCacheRequestsTotal.WithLabelValues(resource, "miss").Inc()
+
+	value, err := fetch()
+	if err != nil {
+		return value, err
+	}
+	cache.mutex.Lock()
+	cache.entries[cacheKey] = cacheEntry{value: value, expires: time.Now().Add(cache.ttl)}
+	cache.mutex.Unlock()
+	return value, nil
+}
+
+// servers lists the servers matching the label selector
+func (cache *hcloudCache) servers(ctx context.Context, client *hcloud.Client, labelSelector string) ([]*hcloud.Server, error) {
+	return cached(cache, cacheResourceServers, labelSelector, func() ([]*hcloud.Server, error) {
+		opts := hcloud.ServerListOpts{}
+		opts.LabelSelector = labelSelector
+		return client.Server.AllWithOpts(ctx, opts)
+	})
+}
+
+// server gets the server with the given ID, nil if it does not exist. The cached server listings are searched
+// first, so the server is only fetched by itself if it is not listed.
+func (cache *hcloudCache) server(ctx context.Context, client *hcloud.Client, id int64) (*hcloud.Server, error) {
+	return cached(cache, cacheResourceServers, "id:"+strconv.FormatInt(id, 10), func() (*hcloud.Server, error) {
+		if server := cache.listedServer(func(server *hcloud.Server) bool { return server.ID == id }); server != nil {
+			return server, nil
+		}
+		server, _, err := client.Server.GetByID(ctx, id)
+		return server, err
+	})
+}
+
+// serverByName gets the server with the given name, nil if it does not exist. The cached server listings are
+// searched first, so the server is only fetched by itself if it is not listed.
+func (cache *hcloudCache) serverByName(ctx context.Context, client *hcloud.Client, name string) (*hcloud.Server, error) {
+	return cached(cache, cacheResourceServers, "name:"+name, func() (*hcloud.Server, error) {
+		if server := cache.listedServer(func(server *hcloud.Server) bool { return server.Name == name }); server != nil {
+			return server, nil
+		}
+		server, _, err := client.Server.GetByName(ctx, name)
+		return server, err
+	})
+}
+
+// listedServer returns the first server of the cached server listings the given function matches
+func (cache *hcloudCache) listedServer(matches func(*hcloud.Server) bool) *hcloud.Server {
+	if cache == nil {
+		return nil
+	}
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	now := time.Now()
+	for _, entry := range cache.entries {
+		servers, ok := entry.value.([]*hcloud.Server)
+		if !ok || !now.Before(entry.expires) {
+			continue
+		}
+		for _, server := range servers {
+			if matches(server) {
+				return server
+			}
+		}
+	}
+	return nil
+}
+
+// floatingIPs lists the floating IPs matching the label selector
+func (cache *hcloudCache) floatingIPs(ctx context.Context, client *hcloud.Client, labelSelector string) ([]*hcloud.FloatingIP, error) {
+	return cached(cache, cacheResourceFloatingIPs, labelSelector, func() ([]*hcloud.FloatingIP, error) {
+		opts := hcloud.FloatingIPListOpts{}
+		opts.LabelSelector = labelSelector
+		return client.FloatingIP.AllWithOpts(ctx, opts)
+	})
+}
+
+// primaryIPs lists the primary IPs matching the label selector
+func (cache *hcloudCache) primaryIPs(ctx context.Context, client *hcloud.Client, labelSelector string) ([]*hcloud.PrimaryIP, error) {
+	return cached(cache, cacheResourcePrimaryIPs, labelSelector, func() ([]*hcloud.PrimaryIP, error) {
+		opts := hcloud.PrimaryIPListOpts{}
+		opts.LabelSelector = labelSelector
+		return client.PrimaryIP.AllWithOpts(ctx, opts)
+	})
+}
+
+// network gets the network with the given name or ID, nil if it does not exist
+func (cache *hcloudCache) network(ctx context.Context, client *hcloud.Client, idOrName string) (*hcloud.Network, error) {
+	return cached(cache, cacheResourceNetworks, idOrName, func() (*hcloud.Network, error) {
+		network, _, err := client.Network.Get(ctx, idOrName)
+		return network, err
+	})
+}
@@ -0,0 +1,148 @@
+package fipcontroller
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	"github.com/hetznercloud/hcloud-go/v2/hcloud/schema"
+
+	"github.com/cbeneke/hcloud-fip-controller/internal/pkg/configuration"
+)
+
+func TestHcloudCache(t *testing.T) {
+	tests := []struct {
+		name string
+		ttl  time.Duration
+		// assign assigns the floating IP between the listings
+		assign bool
+		// requests is the expected number of server and floating IP list requests
+		requests int
+	}{
+		{
+			name:     "cached",
+			ttl:      time.Minute,
+			requests: 1,
+		},
+		{
+			name:     "invalidated by assignment",
+			ttl:      time.Minute,
+			assign:   true,
+			requests: 2,
+		},
+		{
+			name:     "disabled",
+			ttl:      0,
+			requests: 2,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			testEnv := newTestEnv()
+			defer testEnv.Teardown()
+
+			serverRequests, floatingIPRequests := 0, 0
+			testEnv.Mux.HandleFunc("/servers", func(w http.ResponseWriter, r *http.Request) {
+				serverRequests++
+				json.NewEncoder(w).Encode(schema.ServerListResponse{
+					Servers: []schema.Server{{ID: 1, Name: "server-1"}},
+				})
+			})
+			testEnv.Mux.HandleFunc("/floating_ips", func(w http.ResponseWriter, r *http.Request) {
+				floatingIPRequests++
+				json.NewEncoder(w).Encode(schema.FloatingIPListResponse{
+					FloatingIPs: []schema.FloatingIP{{ID: 1, IP: "10.0.0.1", Type: "ipv4"}},
+				})
+			})
+			testEnv.Mux.HandleFunc("/floating_ips/1/actions/assign", func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(201)
+				json.NewEncoder(w).Encode(schema.FloatingIPActionAssignResponse{
+					Action: schema.Action{ID: 1},
+				})
+			})
+
+			provider := newHcloudProviders(testEnv.Client, &configuration.Configuration{HcloudCacheTTL: test.ttl})[0]
+			for i := 0; i < 2; i++ {
+				if _, err := provider.Servers(context.Background()); err != nil {
+					t.Fatalf("error should be [nil] but was [%v]", err)
+				}
+				if _, err := provider.Addresses(context.Background()); err != nil {
+					t.Fatalf("error should be [nil] but was [%v]", err)
+				}
+				if test.assign && i == 0 {
+					address := &Address{ID: 1, IP: net.ParseIP("10.0.0.1")}
+					if _, err := provider.Assign(context.Background(), address, &hcloud.Server{ID: 1}); err != nil {
+						t.Fatalf("error should be [nil] but was [%v]", err)
+					}
+				}
+			}
+
+			if serverRequests != test.requests || floatingIPRequests != test.requests {
+				t.Fatalf("servers and floating IPs should be listed %d times but were listed %d and %d times", test.requests, serverRequests, floatingIPRequests)
+			}
+		})
+	}
+}
+
+func TestHcloudCacheServerLookups(t *testing.T) {
+	testEnv := newTestEnv()
+	defer testEnv.Teardown()
+
+	requests := make(map[string]int)
+	testEnv.Mux.HandleFunc("/servers", func(w http.ResponseWriter, r *http.Request) {
+		requests[r.URL.String()]++
+		if r.URL.Query().Get("name") == "server-2" {
+			json.NewEncoder(w).Encode(schema.ServerListResponse{
+				Servers: []schema.Server{{ID: 2, Name: "server-2"}},
+			})
+			return
+		}
+		json.NewEncoder(w).Encode(schema.ServerListResponse{
+			Servers: []schema.Server{{ID: 1, Name: "server-1"}},
+		})
+	})
+	testEnv.Mux.HandleFunc("/servers/3", func(w http.ResponseWriter, r *http.Request) {
+		requests[r.URL.String()]++
+		json.NewEncoder(w).Encode(schema.ServerGetResponse{Server: schema.Server{ID: 3, Name: "server-3"}})
+	})
+
+	servers := newHcloudServers(testEnv.Client, &configuration.Configuration{HcloudCacheTTL: time.Minute})
+	if _, err := servers.Servers(context.Background()); err != nil {
+		t.Fatalf("error should be [nil] but was [%v]", err)
+	}
+	for i := 0; i < 2; i++ {
+		for _, lookup := range []struct {
+			name   string
+			lookup func() (*hcloud.Server, error)
+		}{
+			{name: "server-1", lookup: func() (*hcloud.Server, error) { return servers.ServerByID(context.Background(), 1) }},
+			{name: "server-1", lookup: func() (*hcloud.Server, error) { return servers.ServerByName(context.Background(), "server-1") }},
+			{name: "server-2", lookup: func() (*hcloud.Server, error) { return servers.ServerByName(context.Background(), "server-2") }},
+			{name: "server-3", lookup: func() (*hcloud.Server, error) { return servers.ServerByID(context.Background(), 3) }},
+		} {
+			server, err := lookup.lookup()
+			if err != nil {
+				t.Fatalf("error should be [nil] but was [%v]", err)
+			}
+			if server == nil || server.Name != lookup.name {
+				t.Fatalf("server should be %s but was %v", lookup.name, server)
+			}
+		}
+	}
+
+	// Listed servers are not fetched again, other servers are fetched once
+	expected := map[string]int{
+		"/servers?page=1&per_page=50": 1,
+		"/servers?name=server-2":      1,
+		"/servers/3":                  1,
+	}
+	if !reflect.DeepEqual(expected, requests) {
+		t.Fatalf("requests should be %v but were %v", expected, requests)
+	}
+}
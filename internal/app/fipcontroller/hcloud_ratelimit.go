@@ -0,0 +1,139 @@
+package fipcontroller
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Reasons why hcloud requests are delayed, used as reason label values
+const (
+	throttledLowBudget   = "low_budget"
+	throttledRateLimited = "rate_limited"
+)
+
+// rateLimitTransport tracks the hcloud API rate limit from the RateLimit-* headers of all responses.
+//
+// Once the remaining budget drops to the headroom, reads like the discovery of servers and addresses are slowed
+// down to the rate the budget refills, so the headroom stays available for changes, e.g. assigning an address
+// on failover. Requests made while (un)assigning an address, including waiting for its action and verifying
+// it, are never slowed down. After a request was rejected with 429, all requests wait until the rate limit
+// resets.
+type rateLimitTransport struct {
+	next     http.RoundTripper
+	headroom int
+
+	mutex sync.Mutex
+	// known is set once a response with rate limit headers was seen
+	known     bool
+	limit     int
+	remaining int
+	// reset is the time the budget is completely refilled
+	reset time.Time
+	// blockedUntil is set after a request was rejected because the rate limit was exceeded
+	blockedUntil time.Time
+}
+
+func newRateLimitTransport(next http.RoundTripper, headroom int) *rateLimitTransport {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &rateLimitTransport{
+		next:     next,
+		headroom: headroom,
+	}
+}
+
+func (transport *rateLimitTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	if delay := transport.delay(request, time.Now()); delay > 0 {
+		timer := time.NewTimer(delay)
+		select {
+		case <-request.Context().Done():
+			timer.Stop()
+			return nil, request.Context().Err()
+		case <-timer.C:
+		}
+	}
+
+	response, err := transport.next.RoundTrip(request)
+	if err != nil {
+		return nil, err
+	}
+	transport.observe(response, time.Now())
+	return response, nil
+}
+
+// delay returns how long the request has to wait before it is sent
+func (transport *rateLimitTransport) delay(request *http.Request, now time.Time) time.Duration {
+	transport.mutex.Lock()
+	defer transport.mutex.Unlock()
+
+	if now.Before(transport.blockedUntil) {
+		hcloudThrottledRequestsTotal.WithLabelValues(throttledRateLimited).Inc()
+		return transport.blockedUntil.Sub(now)
+	}
+	// Changes and requests of (un)assignments are never delayed, they may use the headroom
+	if request.Method != http.MethodGet || isAssignmentRequest(request.Context()) ||
+		!transport.known || transport.remaining > transport.headroom {
+		return 0
+	}
+	refill := transport.refillInterval(now)
+	if refill <= 0 {
+		return 0
+	}
+	// Let reads through only as fast as the budget refills
+	hcloudThrottledRequestsTotal.WithLabelValues(throttledLowBudget).Inc()
+	return refill
+}
+
+// refillInterval returns the time the budget needs to refill by one request, or 0 if it is full
+func (transport *rateLimitTransport) refillInterval(now time.Time) time.Duration {
+	missing := transport.limit - transport.remaining
+	if missing <= 0 || !now.Before(transport.reset) {
+		return 0
+	}
+	return transport.reset.Sub(now) / time.Duration(missing)
+}
+
+// observe updates the rate limit from the headers of the response
+func (transport *rateLimitTransport) observe(response *http.Response, now time.Time) {
+	limit, limitErr := strconv.Atoi(response.Header.Get("RateLimit-Limit"))
+	remaining, remainingErr := strconv.Atoi(response.Header.Get("RateLimit-Remaining"))
+	reset, resetErr := strconv.ParseInt(response.Header.Get("RateLimit-Reset"), 10, 64)
+
+	transport.mutex.Lock()
+	defer transport.mutex.Unlock()
+
+	if limitErr == nil && remainingErr == nil && resetErr == nil {
+		transport.known = true
+		transport.limit = limit
+		transport.remaining = remaining
+		transport.reset = time.Unix(reset, 0)
+		hcloudRateLimitRemaining.Set(float64(remaining))
+	}
+
+	// Without a known reset time, requests wait a second before trying again
+	if response.StatusCode == http.StatusTooManyRequests {
+		transport.blockedUntil = now.Add(time.Second)
+		if transport.known && transport.reset.After(now) {
+			transport.blockedUntil = transport.reset
+		}
+	}
+}
+
+// assignmentRequestKey marks contexts of requests made while (un)assigning an address
+type assignmentRequestKey struct{}
+
+// withAssignmentRequests marks the requests made with the context as part of an (un)assignment, which are not
+// slowed down when the rate limit budget is low
+func withAssignmentRequests(ctx context.Context) context.Context {
+	return context.WithValue(ctx, assignmentRequestKey{}, true)
+}
+
+// isAssignmentRequest reports whether the context was marked by withAssignmentRequests
+func isAssignmentRequest(ctx context.Context) bool {
+	marked, _ := ctx.Value(assignmentRequestKey{}).(bool)
+	return marked
+}
@@ -0,0 +1,111 @@
+package fipcontroller
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// roundTripperFunc answers requests with the given function
+type roundTripperFunc func(request *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(request *http.Request) (*http.Response, error) {
+	return f(request)
+}
+
+func createTestRateLimitResponse(statusCode int, limit, remaining int, reset time.Time) *http.Response {
+	response := &http.Response{StatusCode: statusCode, Header: http.Header{}}
+	response.Header.Set("RateLimit-Limit", strconv.Itoa(limit))
+	response.Header.Set("RateLimit-Remaining", strconv.Itoa(remaining))
+	response.Header.Set("RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+	return response
+}
+
+func TestRateLimitDelay(t *testing.T) {
+	now := time.Unix(1000, 0)
+	tests := []struct {
+		name       string
+		method     string
+		assignment bool
+		response   *http.Response
+		delay      time.Duration
+	}{
+		{
+			name:     "enough budget",
+			method:   http.MethodGet,
+			response: createTestRateLimitResponse(200, 3600, 500, now.Add(3100*time.Second)),
+			delay:    0,
+		},
+		{
+			name:     "read with low budget",
+			method:   http.MethodGet,
+			response: createTestRateLimitResponse(200, 3600, 50, now.Add(3550*time.Second)),
+			delay:    time.Second,
+		},
+		{
+			name:       "assignment read with low budget",
+			method:     http.MethodGet,
+			assignment: true,
+			response:   createTestRateLimitResponse(200, 3600, 50, now.Add(3550*time.Second)),
+			delay:      0,
+		},
+		{
+			name:     "change with low budget",
+			method:   http.MethodPost,
+			response: createTestRateLimitResponse(200, 3600, 50, now.Add(3550*time.Second)),
+			delay:    0,
+		},
+		{
+			name:     "change after rate limit exceeded",
+			method:   http.MethodPost,
+			response: createTestRateLimitResponse(http.StatusTooManyRequests, 3600, 0, now.Add(3600*time.Second)),
+			delay:    3600 * time.Second,
+		},
+		{
+			name:       "assignment read after rate limit exceeded",
+			method:     http.MethodGet,
+			assignment: true,
+			response:   createTestRateLimitResponse(http.StatusTooManyRequests, 3600, 0, now.Add(30*time.Second)),
+			delay:      30 * time.Second,
+		},
+		{
+			name:     "rate limit exceeded without headers",
+			method:   http.MethodGet,
+			response: &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{}},
+			delay:    time.Second,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			transport := newRateLimitTransport(nil, 100)
+			transport.observe(test.response, now)
+
+			ctx := context.Background()
+			if test.assignment {
+				ctx = withAssignmentRequests(ctx)
+			}
+			request, _ := http.NewRequestWithContext(ctx, test.method, "https://api.hetzner.cloud/v1/servers", nil)
+			if delay := transport.delay(request, now); delay != test.delay {
+				t.Fatalf("delay should be %s but was %s", test.delay, delay)
+			}
+		})
+	}
+}
+
+func TestRateLimitTransport(t *testing.T) {
+	reset := time.Now().Add(time.Hour)
+	transport := newRateLimitTransport(roundTripperFunc(func(request *http.Request) (*http.Response, error) {
+		return createTestRateLimitResponse(200, 3600, 3599, reset), nil
+	}), 100)
+
+	request, _ := http.NewRequest(http.MethodGet, "https://api.hetzner.cloud/v1/servers", nil)
+	if _, err := transport.RoundTrip(request); err != nil {
+		t.Fatalf("error should be [nil] but was [%v]", err)
+	}
+	if !transport.known || transport.remaining != 3599 || transport.limit != 3600 || transport.reset.Unix() != reset.Unix() {
+		t.Fatalf("rate limit should be 3599/3600 until %s but was %d/%d until %s", reset, transport.remaining, transport.limit, transport.reset)
+	}
+}
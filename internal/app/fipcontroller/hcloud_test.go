@@ -114,19 +114,12 @@ func TestFloatingIPs(t *testing.T) {
 				})
 			})
 
-			controller := Controller{
-				HetznerClient:    testEnv.Client,
-				Backoff: wait.Backoff{
-					Steps: 1,
-				},
-				Configuration:    &configuration.Configuration{
-					FloatingIPLabelSelector: test.confFloatingIPsLabelSelector,
-					HcloudFloatingIPs: test.confFloatingIPs,
-				},
-				Logger:           logrus.New(),
-			}
+			provider := newFloatingIPProvider(testEnv.Client, &configuration.Configuration{
+				FloatingIPLabelSelector: test.confFloatingIPsLabelSelector,
+				HcloudFloatingIPs: test.confFloatingIPs,
+			})
 
-			ps, err := controller.getFloatingIPs(context.Background())
+			ps, err := provider.Addresses(context.Background())
 
 			if err != nil {
 				t.Fatalf("Error should be [nil] but was %v", err)
@@ -210,15 +203,15 @@ func TestFloatingIp(t *testing.T) {
 					})
 			})
 
-			controller := Controller{
-				HetznerClient:    testEnv.Client,
-				Backoff: wait.Backoff{
-					Steps: 1,
-				},
-				Logger:           logrus.New(),
-			}
+			provider := newFloatingIPProvider(testEnv.Client, &configuration.Configuration{
+				HcloudFloatingIPs: []string{test.inputIP},
+			})
 
-			ip, err := controller.floatingIP(context.Background(), test.inputIP)
+			ips, err := provider.Addresses(context.Background())
+			var ip *Address
+			if len(ips) > 0 {
+				ip = ips[0]
+			}
 
 			if !reflect.DeepEqual(test.err, err) {
 				t.Fatalf("error should be [%v] but was [%v]", test.err, err)
@@ -294,6 +287,32 @@ func TestServer(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "test unmatched ip is skipped",
+			inputIPS: [][]net.IP{
+				{
+					net.ParseIP("1.2.3.4"),
+				},
+				{
+					net.ParseIP("5.6.7.8"),
+				},
+			},
+			servers: []schema.Server{
+				{
+					ID: 1,
+					PublicNet: schema.ServerPublicNet{
+						IPv4: schema.ServerPublicNetIPv4{
+							IP: "1.2.3.4",
+						},
+					},
+				},
+			},
+			resultServers: []*hcloud.Server{
+				{
+					ID: 1,
+				},
+			},
+		},
 	}
 
 	for _, test := range tests {
@@ -308,7 +327,6 @@ func TestServer(t *testing.T) {
 			})
 
 			controller := Controller{
-				HetznerClient:    testEnv.Client,
 				Backoff: wait.Backoff{
 					Steps: 1,
 				},
@@ -317,7 +335,7 @@ func TestServer(t *testing.T) {
 				Logger:           logrus.New(),
 			}
 
-			servers, err := controller.servers(context.Background(), test.inputIPS)
+			servers, err := controller.servers(context.Background(), newFloatingIPProvider(testEnv.Client, &configuration.Configuration{}), test.inputIPS)
 
 			if !reflect.DeepEqual(test.err, err) {
 				t.Fatalf("error should be [%v] but was [%v]", test.err, err)
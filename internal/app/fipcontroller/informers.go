@@ -0,0 +1,441 @@
+package fipcontroller
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	discoveryinformers "k8s.io/client-go/informers/discovery/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Triggers of reconciliations, used as trigger label values
+const (
+	triggerNode      = "node"
+	triggerPod       = "pod"
+	triggerPool      = "pool"
+	triggerService   = "service"
+	triggerEndpoints = "endpoints"
+	triggerResync    = "resync"
+)
+
+// informerSyncTimeout is the time the informer caches get to sync when the controller starts
+const informerSyncTimeout = 2 * time.Minute
+
+// startInformers starts shared informers for all nodes, the pods in the controller namespace and, if enabled, all
+// services and endpoint slices and the FloatingIPPools and waits for their caches to sync. Relevant changes trigger a
+// reconciliation, and the objects are read from the caches afterwards instead of listing them from the API on
+// every reconciliation.
+func (controller *Controller) startInformers(ctx context.Context) error {
+	controller.reconcileTriggers = make(chan string, 1)
+	if controller.followedServices == nil {
+		controller.followedServices = &serviceKeys{}
+	}
+
+	nodeFactory := informers.NewSharedInformerFactory(controller.KubernetesClient, 0)
+	nodeInformer := nodeFactory.Core().V1().Nodes()
+	nodeHandler, err := nodeInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(_ interface{}) { controller.triggerReconcile(triggerNode) },
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldNode, oldOk := oldObj.(*corev1.Node)
+			newNode, newOk := newObj.(*corev1.Node)
+			if oldOk && newOk && nodeChanged(oldNode, newNode) {
+				controller.triggerReconcile(triggerNode)
+			}
+		},
+		DeleteFunc: func(_ interface{}) { controller.triggerReconcile(triggerNode) },
+	})
+	if err != nil {
+		return fmt.Errorf("could not watch nodes: %w", err)
+	}
+
+	podFactory := informers.NewSharedInformerFactoryWithOptions(controller.KubernetesClient, 0, informers.WithNamespace(controller.Configuration.Namespace))
+	podInformer := podFactory.Core().V1().Pods()
+	podHandler, err := podInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(_ interface{}) { controller.triggerReconcile(triggerPod) },
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldPod, oldOk := oldObj.(*corev1.Pod)
+			newPod, newOk := newObj.(*corev1.Pod)
+			if oldOk && newOk && podChanged(oldPod, newPod) {
+				controller.triggerReconcile(triggerPod)
+			}
+		},
+		DeleteFunc: func(_ interface{}) { controller.triggerReconcile(triggerPod) },
+	})
+	if err != nil {
+		return fmt.Errorf("could not watch pods: %w", err)
+	}
+
+	synced := []cache.InformerSynced{nodeHandler.HasSynced, podHandler.HasSynced}
+
+	// Services are watched cluster-wide, which is only needed if addresses can follow or be allocated to them
+	var serviceInformer coreinformers.ServiceInformer
+	var endpointSliceInformer discoveryinformers.EndpointSliceInformer
+	if controller.watchesServices() {
+		serviceInformer = nodeFactory.Core().V1().Services()
+		serviceHandler, err := serviceInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				if service, ok := obj.(*corev1.Service); ok && controller.serviceRelevant(service) {
+					controller.triggerReconcile(triggerService)
+				}
+			},
+			UpdateFunc: func(oldObj, newObj interface{}) {
+				oldService, oldOk := oldObj.(*corev1.Service)
+				newService, newOk := newObj.(*corev1.Service)
+				if oldOk && newOk && serviceChanged(oldService, newService) &&
+					(controller.serviceRelevant(oldService) || controller.serviceRelevant(newService)) {
+					controller.triggerReconcile(triggerService)
+				}
+			},
+			DeleteFunc: func(obj interface{}) {
+				if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+					obj = tombstone.Obj
+				}
+				if service, ok := obj.(*corev1.Service); !ok || controller.serviceRelevant(service) {
+					controller.triggerReconcile(triggerService)
+				}
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("could not watch services: %w", err)
+		}
+
+		endpointSliceInformer = nodeFactory.Discovery().V1().EndpointSlices()
+		endpointSliceHandler, err := endpointSliceInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				if endpointSlice, ok := obj.(*discoveryv1.EndpointSlice); ok && controller.endpointSliceFollowed(endpointSlice) {
+					controller.triggerReconcile(triggerEndpoints)
+				}
+			},
+			UpdateFunc: func(oldObj, newObj interface{}) {
+				oldSlice, oldOk := oldObj.(*discoveryv1.EndpointSlice)
+				newSlice, newOk := newObj.(*discoveryv1.EndpointSlice)
+				if oldOk && newOk && controller.endpointSliceFollowed(newSlice) && endpointSliceChanged(oldSlice, newSlice) {
+					controller.triggerReconcile(triggerEndpoints)
+				}
+			},
+			DeleteFunc: func(obj interface{}) {
+				if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+					obj = tombstone.Obj
+				}
+				if endpointSlice, ok := obj.(*discoveryv1.EndpointSlice); !ok || controller.endpointSliceFollowed(endpointSlice) {
+					controller.triggerReconcile(triggerEndpoints)
+				}
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("could not watch endpoint slices: %w", err)
+		}
+		synced = append(synced, serviceHandler.HasSynced, endpointSliceHandler.HasSynced)
+	}
+
+	var poolInformer informers.GenericInformer
+	if controller.Configuration.FloatingIPPools {
+		poolFactory := dynamicinformer.NewDynamicSharedInformerFactory(controller.DynamicClient, 0)
+		poolInformer = poolFactory.ForResource(poolResource)
+		poolHandler, err := poolInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc: func(_ interface{}) { controller.triggerReconcile(triggerPool) },
+			UpdateFunc: func(oldObj, newObj interface{}) {
+				oldPool, oldOk := oldObj.(metav1.Object)
+				newPool, newOk := newObj.(metav1.Object)
+				// Status updates written by the controller do not change the generation
+				if oldOk && newOk && oldPool.GetGeneration() != newPool.GetGeneration() {
+					controller.triggerReconcile(triggerPool)
+				}
+			},
+			DeleteFunc: func(_ interface{}) { controller.triggerReconcile(triggerPool) },
+		})
+		if err != nil {
+			return fmt.Errorf("could not watch floating IP pools: %w", err)
+		}
+		poolFactory.Start(ctx.Done())
+		synced = append(synced, poolHandler.HasSynced)
+	}
+
+	nodeFactory.Start(ctx.Done())
+	podFactory.Start(ctx.Done())
+	// Wait until the handlers got the initial objects as well, so later triggers are caused by changes. Caches
+	// which never sync, e.g. because of a missing CRD or RBAC rule, fail the start instead of blocking it.
+	syncCtx, cancel := context.WithTimeout(ctx, informerSyncTimeout)
+	defer cancel()
+	if !cache.WaitForCacheSync(syncCtx.Done(), synced...) {
+		return fmt.Errorf("could not sync informer caches within %s", informerSyncTimeout)
+	}
+
+	controller.nodeLister = nodeInformer.Lister()
+	controller.podLister = podInformer.Lister()
+	if serviceInformer != nil {
+		controller.serviceLister = serviceInformer.Lister()
+		controller.endpointSliceLister = endpointSliceInformer.Lister()
+	}
+	if poolInformer != nil {
+		controller.poolLister = poolInformer.Lister()
+	}
+	controller.Logger.Debug("Informer caches synced")
+	return nil
+}
+
+// watchesServices reports whether services and endpoint slices are watched, which is the case in service IPAM
+// mode, if addresses follow services or if pools are enabled, as their addresses can follow services. Services
+// followed only by address labels are read from the API and picked up by the periodic resync.
+func (controller *Controller) watchesServices() bool {
+	config := controller.Configuration
+	return config.ServiceIPAM || config.FollowServiceAnnotations || config.FollowService != "" || config.FloatingIPPools
+}
+
+// triggerReconcile requests a reconciliation without blocking. Triggers arriving while a reconciliation is
+// already requested are merged into it.
+func (controller *Controller) triggerReconcile(trigger string) {
+	select {
+	case controller.reconcileTriggers <- trigger:
+	default:
+	}
+}
+
+// Drop a requested reconciliation, e.g. the one requested by the initial nodes and pods of the informers
+func (controller *Controller) dropReconcileTrigger() {
+	select {
+	case <-controller.reconcileTriggers:
+	default:
+	}
+}
+
+// nodeChanged reports whether the node changed in a way relevant to the assignments. Periodic status updates
+// like heartbeats are ignored.
+func nodeChanged(oldNode, newNode *corev1.Node) bool {
+	return isNodeHealthy(*oldNode) != isNodeHealthy(*newNode) ||
+		oldNode.Spec.Unschedulable != newNode.Spec.Unschedulable ||
+		!reflect.DeepEqual(oldNode.Spec.Taints, newNode.Spec.Taints) ||
+		!reflect.DeepEqual(oldNode.Status.Addresses, newNode.Status.Addresses) ||
+		!labels.Equals(withoutHolderLabel(oldNode.Labels), withoutHolderLabel(newNode.Labels))
+}
+
+// withoutHolderLabel returns the labels without the holder label, which is set by the controller itself
+func withoutHolderLabel(nodeLabels map[string]string) map[string]string {
+	if _, ok := nodeLabels[NodeLabelHolder]; !ok {
+		return nodeLabels
+	}
+	result := make(map[string]string, len(nodeLabels))
+	for key, value := range nodeLabels {
+		if key != NodeLabelHolder {
+			result[key] = value
+		}
+	}
+	return result
+}
+
+// podChanged reports whether the pod changed in a way relevant to the assignments
+func podChanged(oldPod, newPod *corev1.Pod) bool {
+	return oldPod.Status.Phase != newPod.Status.Phase ||
+		oldPod.Spec.NodeName != newPod.Spec.NodeName ||
+		!reflect.DeepEqual(oldPod.Labels, newPod.Labels)
+}
+
+// serviceKeys is a set of "<namespace>/<name>" service keys, safe for concurrent use. Keys added during a
+// reconciliation replace the previous set once the reconciliation finished.
+type serviceKeys struct {
+	mutex   sync.Mutex
+	current map[string]bool
+	next    map[string]bool
+}
+
+// add the key to the set of the running reconciliation. Nil sets and empty keys are ignored.
+func (keys *serviceKeys) add(key string) {
+	if keys == nil || key == "" {
+		return
+	}
+	keys.mutex.Lock()
+	defer keys.mutex.Unlock()
+	if keys.next == nil {
+		keys.next = make(map[string]bool)
+	}
+	keys.next[key] = true
+}
+
+// update replaces the set with the keys added since the last update. If the reconciliation was not complete,
+// the previous keys are kept as well, as their addresses may not have been reconciled.
+func (keys *serviceKeys) update(complete bool) {
+	if keys == nil {
+		return
+	}
+	keys.mutex.Lock()
+	defer keys.mutex.Unlock()
+	if keys.next == nil {
+		keys.next = make(map[string]bool)
+	}
+	if !complete {
+		for key := range keys.current {
+			keys.next[key] = true
+		}
+	}
+	keys.current, keys.next = keys.next, nil
+}
+
+// has reports whether the key is in the set
+func (keys *serviceKeys) has(key string) bool {
+	if keys == nil {
+		return false
+	}
+	keys.mutex.Lock()
+	defer keys.mutex.Unlock()
+	return keys.current[key]
+}
+
+// serviceRelevant reports whether the service is followed by an address, handled in service IPAM mode or
+// claims floating IPs with its annotation
+func (controller *Controller) serviceRelevant(service *corev1.Service) bool {
+	if controller.followedServices.has(service.Namespace + "/" + service.Name) {
+		return true
+	}
+	if controller.Configuration.ServiceIPAM && controller.handlesLoadBalancer(service) {
+		return true
+	}
+	_, annotated := service.Annotations[AnnotationFloatingIPs]
+	return controller.Configuration.FollowServiceAnnotations && annotated
+}
+
+// serviceChanged reports whether the service changed in a way relevant to the assignments. Status updates,
+// e.g. the ingress written in service IPAM mode, are ignored.
+func serviceChanged(oldService, newService *corev1.Service) bool {
+	return oldService.Spec.Type != newService.Spec.Type ||
+		!reflect.DeepEqual(oldService.Spec.LoadBalancerClass, newService.Spec.LoadBalancerClass) ||
+		oldService.Annotations[AnnotationFloatingIPs] != newService.Annotations[AnnotationFloatingIPs]
+}
+
+// endpointSliceFollowed reports whether the endpoint slice belongs to a followed service
+func (controller *Controller) endpointSliceFollowed(endpointSlice *discoveryv1.EndpointSlice) bool {
+	name := endpointSlice.Labels[discoveryv1.LabelServiceName]
+	return name != "" && controller.followedServices.has(endpointSlice.Namespace+"/"+name)
+}
+
+// endpointSliceChanged reports whether the nodes hosting ready endpoints of the slice changed
+func endpointSliceChanged(oldSlice, newSlice *discoveryv1.EndpointSlice) bool {
+	oldNodes := endpointSliceNodeNames(oldSlice, nil)
+	newNodes := endpointSliceNodeNames(newSlice, nil)
+	sort.Strings(oldNodes)
+	sort.Strings(newNodes)
+	return !reflect.DeepEqual(oldNodes, newNodes)
+}
+
+// List the nodes matching the label selector, from the informer cache if it is running
+func (controller *Controller) listNodes(ctx context.Context, labelSelector string) (nodes *corev1.NodeList, err error) {
+	if controller.nodeLister != nil {
+		selector, err := labels.Parse(labelSelector)
+		if err != nil {
+			return nil, err
+		}
+		cached, err := controller.nodeLister.List(selector)
+		if err != nil {
+			return nil, err
+		}
+		nodes = &corev1.NodeList{Items: make([]corev1.Node, 0, len(cached))}
+		for _, node := range cached {
+			nodes.Items = append(nodes.Items, *node)
+		}
+		return nodes, nil
+	}
+
+	err = controller.retry(operationListNodes, func() error {
+		nodes, err = controller.KubernetesClient.CoreV1().Nodes().List(ctx, metav1.ListOptions{LabelSelector: labelSelector})
+		return err
+	})
+	return nodes, err
+}
+
+// Get the node with the given name, from the informer cache if it is running
+func (controller *Controller) getNode(ctx context.Context, name string) (node *corev1.Node, err error) {
+	if controller.nodeLister != nil {
+		return controller.nodeLister.Get(name)
+	}
+
+	err = controller.retry(operationGetNode, func() error {
+		node, err = controller.KubernetesClient.CoreV1().Nodes().Get(ctx, name, metav1.GetOptions{})
+		return err
+	})
+	return node, err
+}
+
+// List the running pods in the controller namespace matching the label selector, from the informer cache if
+// it is running
+func (controller *Controller) listRunningPods(ctx context.Context, labelSelector string) (pods *corev1.PodList, err error) {
+	if controller.podLister != nil {
+		selector, err := labels.Parse(labelSelector)
+		if err != nil {
+			return nil, err
+		}
+		cached, err := controller.podLister.Pods(controller.Configuration.Namespace).List(selector)
+		if err != nil {
+			return nil, err
+		}
+		pods = &corev1.PodList{}
+		for _, pod := range cached {
+			if pod.Status.Phase == corev1.PodRunning {
+				pods.Items = append(pods.Items, *pod)
+			}
+		}
+		return pods, nil
+	}
+
+	listOptions := metav1.ListOptions{
+		LabelSelector: labelSelector,
+		FieldSelector: "status.phase=Running",
+	}
+	err = controller.retry(operationListPods, func() error {
+		pods, err = controller.KubernetesClient.CoreV1().Pods(controller.Configuration.Namespace).List(ctx, listOptions)
+		return err
+	})
+	return pods, err
+}
+
+// List all services, from the informer cache if it is running
+func (controller *Controller) listServices(ctx context.Context) (services *corev1.ServiceList, err error) {
+	if controller.serviceLister != nil {
+		cached, err := controller.serviceLister.List(labels.Everything())
+		if err != nil {
+			return nil, err
+		}
+		services = &corev1.ServiceList{Items: make([]corev1.Service, 0, len(cached))}
+		for _, service := range cached {
+			services.Items = append(services.Items, *service)
+		}
+		return services, nil
+	}
+
+	err = controller.retry(operationListServices, func() error {
+		services, err = controller.KubernetesClient.CoreV1().Services(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+		return err
+	})
+	return services, err
+}
+
+// List the endpoint slices of the service, from the informer cache if it is running
+func (controller *Controller) listEndpointSlices(ctx context.Context, namespace, name string) (endpointSlices *discoveryv1.EndpointSliceList, err error) {
+	selector := labels.SelectorFromSet(labels.Set{discoveryv1.LabelServiceName: name})
+	if controller.endpointSliceLister != nil {
+		cached, err := controller.endpointSliceLister.EndpointSlices(namespace).List(selector)
+		if err != nil {
+			return nil, err
+		}
+		endpointSlices = &discoveryv1.EndpointSliceList{Items: make([]discoveryv1.EndpointSlice, 0, len(cached))}
+		for _, endpointSlice := range cached {
+			endpointSlices.Items = append(endpointSlices.Items, *endpointSlice)
+		}
+		return endpointSlices, nil
+	}
+
+	err = controller.retry(operationListEndpointSlices, func() error {
+		endpointSlices, err = controller.KubernetesClient.DiscoveryV1().EndpointSlices(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+		return err
+	})
+	return endpointSlices, err
+}
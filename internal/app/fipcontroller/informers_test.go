@@ -0,0 +1,321 @@
+package fipcontroller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/cbeneke/hcloud-fip-controller/internal/pkg/configuration"
+)
+
+func TestNodeChanged(t *testing.T) {
+	tests := []struct {
+		name    string
+		update  func(node *v1.Node)
+		changed bool
+	}{
+		{
+			name: "heartbeat",
+			update: func(node *v1.Node) {
+				node.Status.Conditions[0].LastHeartbeatTime = metav1.Now()
+			},
+			changed: false,
+		},
+		{
+			name: "not ready",
+			update: func(node *v1.Node) {
+				node.Status.Conditions[0].Status = v1.ConditionFalse
+			},
+			changed: true,
+		},
+		{
+			name: "cordoned",
+			update: func(node *v1.Node) {
+				node.Spec.Unschedulable = true
+			},
+			changed: true,
+		},
+		{
+			name: "tainted",
+			update: func(node *v1.Node) {
+				node.Spec.Taints = []v1.Taint{{Key: "maintenance", Effect: v1.TaintEffectNoSchedule}}
+			},
+			changed: true,
+		},
+		{
+			name: "labelled",
+			update: func(node *v1.Node) {
+				node.Labels = map[string]string{"foo": "bar"}
+			},
+			changed: true,
+		},
+		{
+			name: "holder labelled",
+			update: func(node *v1.Node) {
+				node.Labels = map[string]string{NodeLabelHolder: "true"}
+			},
+			changed: false,
+		},
+		{
+			name: "address changed",
+			update: func(node *v1.Node) {
+				node.Status.Addresses = []v1.NodeAddress{{Type: v1.NodeExternalIP, Address: "2.2.2.2"}}
+			},
+			changed: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			oldNode := createTestNode("node-1", []v1.NodeAddress{{Type: v1.NodeExternalIP, Address: "1.1.1.1"}}, v1.ConditionTrue)
+			newNode := oldNode.DeepCopy()
+			test.update(newNode)
+
+			if changed := nodeChanged(oldNode, newNode); changed != test.changed {
+				t.Fatalf("node change should be %t but was %t", test.changed, changed)
+			}
+		})
+	}
+}
+
+func TestPodChanged(t *testing.T) {
+	tests := []struct {
+		name    string
+		update  func(pod *v1.Pod)
+		changed bool
+	}{
+		{
+			name: "annotated",
+			update: func(pod *v1.Pod) {
+				pod.Annotations = map[string]string{"foo": "bar"}
+			},
+			changed: false,
+		},
+		{
+			name: "failed",
+			update: func(pod *v1.Pod) {
+				pod.Status.Phase = v1.PodFailed
+			},
+			changed: true,
+		},
+		{
+			name: "rescheduled",
+			update: func(pod *v1.Pod) {
+				pod.Spec.NodeName = "node-2"
+			},
+			changed: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			oldPod := &v1.Pod{
+				Spec:   v1.PodSpec{NodeName: "node-1"},
+				Status: v1.PodStatus{Phase: v1.PodRunning},
+			}
+			newPod := oldPod.DeepCopy()
+			test.update(newPod)
+
+			if changed := podChanged(oldPod, newPod); changed != test.changed {
+				t.Fatalf("pod change should be %t but was %t", test.changed, changed)
+			}
+		})
+	}
+}
+
+func TestServiceChanged(t *testing.T) {
+	class := "fip"
+	tests := []struct {
+		name    string
+		update  func(service *v1.Service)
+		changed bool
+	}{
+		{
+			name: "ingress published",
+			update: func(service *v1.Service) {
+				service.Status.LoadBalancer.Ingress = []v1.LoadBalancerIngress{{IP: "10.0.0.1"}}
+			},
+			changed: false,
+		},
+		{
+			name: "type changed",
+			update: func(service *v1.Service) {
+				service.Spec.Type = v1.ServiceTypeClusterIP
+			},
+			changed: true,
+		},
+		{
+			name: "load balancer class changed",
+			update: func(service *v1.Service) {
+				service.Spec.LoadBalancerClass = &class
+			},
+			changed: true,
+		},
+		{
+			name: "floating IPs annotated",
+			update: func(service *v1.Service) {
+				service.Annotations = map[string]string{AnnotationFloatingIPs: "10.0.0.1"}
+			},
+			changed: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			oldService := &v1.Service{Spec: v1.ServiceSpec{Type: v1.ServiceTypeLoadBalancer}}
+			newService := oldService.DeepCopy()
+			test.update(newService)
+
+			if changed := serviceChanged(oldService, newService); changed != test.changed {
+				t.Fatalf("service change should be %t but was %t", test.changed, changed)
+			}
+		})
+	}
+}
+
+func TestEndpointSliceChanged(t *testing.T) {
+	oldSlice := createTestEndpointSlice("web", "ingress", "node-1")
+
+	moved := createTestEndpointSlice("web", "ingress", "node-2")
+	if !endpointSliceChanged(oldSlice, moved) {
+		t.Fatal("endpoint moved to another node should be a change")
+	}
+
+	readded := oldSlice.DeepCopy()
+	readded.Endpoints[0].Addresses = []string{"10.0.0.2"}
+	if endpointSliceChanged(oldSlice, readded) {
+		t.Fatal("endpoint replaced on the same node should be no change")
+	}
+
+	notReady := oldSlice.DeepCopy()
+	ready := false
+	notReady.Endpoints[0].Conditions.Ready = &ready
+	if !endpointSliceChanged(oldSlice, notReady) {
+		t.Fatal("endpoint becoming not ready should be a change")
+	}
+}
+
+func TestServiceKeys(t *testing.T) {
+	keys := &serviceKeys{}
+	keys.add("web/ingress")
+	if keys.has("web/ingress") {
+		t.Fatal("keys should only be visible after the update")
+	}
+	keys.update(true)
+	if !keys.has("web/ingress") {
+		t.Fatal("key should be followed after the update")
+	}
+
+	keys.add("web/api")
+	keys.update(false)
+	if !keys.has("web/ingress") || !keys.has("web/api") {
+		t.Fatal("previous keys should be kept after an incomplete reconciliation")
+	}
+	keys.update(true)
+	if keys.has("web/ingress") {
+		t.Fatal("keys which are not followed anymore should be removed after a complete reconciliation")
+	}
+}
+
+func TestInformers(t *testing.T) {
+	node := createTestNode("node-1", []v1.NodeAddress{{Type: v1.NodeExternalIP, Address: "1.1.1.1"}}, v1.ConditionTrue)
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod-1", Namespace: "default", Labels: map[string]string{"app": "fip"}},
+		Spec:       v1.PodSpec{NodeName: "node-1"},
+		Status:     v1.PodStatus{Phase: v1.PodRunning},
+	}
+	kubernetesFakeClient := fake.NewSimpleClientset(node, pod)
+	controller := Controller{
+		KubernetesClient: kubernetesFakeClient,
+		Backoff: wait.Backoff{
+			Steps: 1,
+		},
+		Configuration: &configuration.Configuration{Namespace: "default", FollowServiceAnnotations: true},
+		Logger:        logrus.New(),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := controller.startInformers(ctx); err != nil {
+		t.Fatalf("error should be [nil] but was [%v]", err)
+	}
+	controller.dropReconcileTrigger()
+
+	nodes, err := controller.nodes(ctx)
+	if err != nil {
+		t.Fatalf("error should be [nil] but was [%v]", err)
+	}
+	if len(nodes.Items) != 1 || nodes.Items[0].Name != "node-1" {
+		t.Fatalf("nodes should be [node-1] but were %v", nodes.Items)
+	}
+	pods, err := controller.listRunningPods(ctx, "app=fip")
+	if err != nil {
+		t.Fatalf("error should be [nil] but was [%v]", err)
+	}
+	if len(pods.Items) != 1 || pods.Items[0].Name != "pod-1" {
+		t.Fatalf("pods should be [pod-1] but were %v", pods.Items)
+	}
+
+	notReady := node.DeepCopy()
+	notReady.Status.Conditions[0].Status = v1.ConditionFalse
+	if _, err := kubernetesFakeClient.CoreV1().Nodes().Update(ctx, notReady, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("error should be [nil] but was [%v]", err)
+	}
+
+	select {
+	case trigger := <-controller.reconcileTriggers:
+		if trigger != triggerNode {
+			t.Fatalf("trigger should be %s but was %s", triggerNode, trigger)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("node going not ready should trigger a reconciliation")
+	}
+
+	// Endpoints of followed services trigger a reconciliation, they are read from the cache afterwards
+	controller.followedServices.add("default/ingress")
+	controller.followedServices.update(true)
+	if _, err := kubernetesFakeClient.DiscoveryV1().EndpointSlices("default").Create(ctx, createTestEndpointSlice("default", "ingress", "node-1"), metav1.CreateOptions{}); err != nil {
+		t.Fatalf("error should be [nil] but was [%v]", err)
+	}
+	select {
+	case trigger := <-controller.reconcileTriggers:
+		if trigger != triggerEndpoints {
+			t.Fatalf("trigger should be %s but was %s", triggerEndpoints, trigger)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("new endpoints of a followed service should trigger a reconciliation")
+	}
+	nodeNames, err := controller.serviceEndpointNodeNames(ctx, "default", "ingress")
+	if err != nil {
+		t.Fatalf("error should be [nil] but was [%v]", err)
+	}
+	if len(nodeNames) != 1 || nodeNames[0] != "node-1" {
+		t.Fatalf("endpoint nodes should be [node-1] but were %v", nodeNames)
+	}
+}
+
+func TestInformersWithoutServices(t *testing.T) {
+	controller := Controller{
+		KubernetesClient: fake.NewSimpleClientset(),
+		Configuration:    &configuration.Configuration{Namespace: "default"},
+		Logger:           logrus.New(),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := controller.startInformers(ctx); err != nil {
+		t.Fatalf("error should be [nil] but was [%v]", err)
+	}
+	if controller.nodeLister == nil {
+		t.Fatalf("nodes should be watched")
+	}
+	if controller.serviceLister != nil || controller.endpointSliceLister != nil {
+		t.Fatalf("services and endpoint slices should not be watched without service modes")
+	}
+}
@@ -3,32 +3,63 @@ package fipcontroller
 import (
 	"context"
 	"fmt"
-	"k8s.io/client-go/util/retry"
 	"net"
 	"strings"
 
 	"github.com/cbeneke/hcloud-fip-controller/internal/pkg/configuration"
 
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
 	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/record"
 )
 
-func newKubernetesClient() (*kubernetes.Clientset, error) {
+func newKubeConfig() (*rest.Config, error) {
 	kubeConfig, err := rest.InClusterConfig()
 	if err != nil {
-		return nil, fmt.Errorf("could not get kubeconfig: %v", err)
+		// Outside of a cluster, e.g. when planning from a workstation, use the kubeconfig of the user
+		loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+		kubeConfig, err = clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, &clientcmd.ConfigOverrides{}).ClientConfig()
+		if err != nil {
+			return nil, fmt.Errorf("could not get kubeconfig: %w", err)
+		}
 	}
+	return kubeConfig, nil
+}
 
+func newKubernetesClient(kubeConfig *rest.Config) (*kubernetes.Clientset, error) {
 	kubernetesClient, err := kubernetes.NewForConfig(kubeConfig)
 	if err != nil {
-		return nil, fmt.Errorf("could not get kubernetes client: %v", err)
+		return nil, fmt.Errorf("could not get kubernetes client: %w", err)
 	}
 
 	return kubernetesClient, nil
 }
 
+// The dynamic client reads and updates the FloatingIPPool custom resources
+func newDynamicClient(kubeConfig *rest.Config) (dynamic.Interface, error) {
+	dynamicClient, err := dynamic.NewForConfig(kubeConfig)
+	if err != nil {
+		return nil, fmt.Errorf("could not get dynamic kubernetes client: %w", err)
+	}
+
+	return dynamicClient, nil
+}
+
+// The event recorder emits events on nodes, services and pods through the kubernetes client
+func newEventRecorder(kubernetesClient kubernetes.Interface) record.EventRecorder {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kubernetesClient.CoreV1().Events("")})
+	return broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "hcloud-fip-controller"})
+}
+
 // Search and return the IP address of a given kubernetes node name.
 // Will return first found internal or external IP depending on nodeAddressType parameter
 func (controller *Controller) nodeAddressList(ctx context.Context, nodeAddressType configuration.NodeAddressType) (addressList [][]net.IP, err error) {
@@ -38,17 +69,9 @@ func (controller *Controller) nodeAddressList(ctx context.Context, nodeAddressTy
 	}
 
 	// Try to get deployment pods if certain label is specified
-	listOptions := metav1.ListOptions{}
-	listOptions.LabelSelector = podLabelSelector
-	listOptions.FieldSelector = "status.phase=Running"
-	var pods *corev1.PodList
-
-	err = retry.OnError(controller.Backoff, alwaysRetry, func() error {
-		pods, err = controller.KubernetesClient.CoreV1().Pods(controller.Configuration.Namespace).List(ctx, listOptions)
-		return err
-	})
+	pods, err := controller.listRunningPods(ctx, podLabelSelector)
 	if err != nil {
-		return nil, fmt.Errorf("could not list nodes: %v", err)
+		return nil, fmt.Errorf("could not list nodes: %w", err)
 	}
 	controller.Logger.Debugf("Found %d pods", len(pods.Items))
 
@@ -58,13 +81,9 @@ func (controller *Controller) nodeAddressList(ctx context.Context, nodeAddressTy
 	}
 
 	if len(nodeNames) > 0 {
-		var nodes *corev1.NodeList
-		err = retry.OnError(controller.Backoff, alwaysRetry, func() error {
-			nodes, err = controller.KubernetesClient.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
-			return err
-		})
+		nodes, err := controller.listNodes(ctx, "")
 		if err != nil {
-			return nil, fmt.Errorf("could not list nodes: %v", err)
+			return nil, fmt.Errorf("could not list nodes: %w", err)
 		}
 		for _, node := range nodes.Items {
 			if hasNodeName(nodeNames, node.Name) {
@@ -78,19 +97,10 @@ func (controller *Controller) nodeAddressList(ctx context.Context, nodeAddressTy
 		return
 	}
 
-	// Create list options with optional labelSelector
-	listOptions = metav1.ListOptions{}
-	if controller.Configuration.NodeLabelSelector != "" {
-		listOptions.LabelSelector = controller.Configuration.NodeLabelSelector
-	}
-	var nodes *corev1.NodeList
-
-	err = retry.OnError(controller.Backoff, alwaysRetry, func() error {
-		nodes, err = controller.KubernetesClient.CoreV1().Nodes().List(ctx, listOptions)
-		return err
-	})
+	// List nodes with optional labelSelector
+	nodes, err := controller.listNodes(ctx, controller.Configuration.NodeLabelSelector)
 	if err != nil {
-		return nil, fmt.Errorf("could not list nodes: %v", err)
+		return nil, fmt.Errorf("could not list nodes: %w", err)
 	}
 	controller.Logger.Debugf("Found %d nodes", len(nodes.Items))
 
@@ -159,12 +169,12 @@ func (controller *Controller) createPodLabelSelector(ctx context.Context) (strin
 
 	var pod *corev1.Pod
 	var err error
-	err = retry.OnError(controller.Backoff, alwaysRetry, func() error {
+	err = controller.retry(operationGetPod, func() error {
 		pod, err = controller.KubernetesClient.CoreV1().Pods(controller.Configuration.Namespace).Get(ctx, controller.Configuration.PodName, metav1.GetOptions{})
 		return err
 	})
 	if err != nil {
-		return "", fmt.Errorf("Could not get pod information: %v", err)
+		return "", fmt.Errorf("Could not get pod information: %w", err)
 	}
 	if len(pod.Labels) < 1 {
 		controller.Logger.Warnf("fip-controller pod has no labels, all pods in namespace will be used")
@@ -180,3 +190,62 @@ func (controller *Controller) createPodLabelSelector(ctx context.Context) (strin
 	controller.Logger.Debugf("pod label selector created: %s", labelSelector)
 	return labelSelector, nil
 }
+
+// Return the names of all nodes hosting a ready endpoint of the given service
+func (controller *Controller) serviceEndpointNodeNames(ctx context.Context, namespace, name string) (nodeNames []string, err error) {
+	endpointSlices, err := controller.listEndpointSlices(ctx, namespace, name)
+	if err != nil {
+		return nil, fmt.Errorf("could not list endpoint slices of service '%s/%s': %w", namespace, name, err)
+	}
+
+	for i := range endpointSlices.Items {
+		nodeNames = endpointSliceNodeNames(&endpointSlices.Items[i], nodeNames)
+	}
+	controller.Logger.Debugf("Found %d nodes with ready endpoints for service '%s/%s'", len(nodeNames), namespace, name)
+	return nodeNames, nil
+}
+
+// endpointSliceNodeNames appends the names of the nodes hosting a ready endpoint of the slice to the node names
+func endpointSliceNodeNames(endpointSlice *discoveryv1.EndpointSlice, nodeNames []string) []string {
+	for _, endpoint := range endpointSlice.Endpoints {
+		// Endpoints without a ready condition are considered ready
+		if endpoint.Conditions.Ready != nil && !*endpoint.Conditions.Ready {
+			continue
+		}
+		if endpoint.NodeName != nil && !hasNodeName(nodeNames, *endpoint.NodeName) {
+			nodeNames = append(nodeNames, *endpoint.NodeName)
+		}
+	}
+	return nodeNames
+}
+
+// Return the servers backing the nodes with the given names. Only servers from the given list are returned,
+// so passing the running servers will skip nodes that are not healthy.
+func (controller *Controller) serversForNodeNames(ctx context.Context, nodeNames []string, servers []*hcloud.Server) (result []*hcloud.Server, err error) {
+	if len(nodeNames) < 1 {
+		return nil, nil
+	}
+
+	nodes, err := controller.listNodes(ctx, "")
+	if err != nil {
+		return nil, fmt.Errorf("could not list nodes: %w", err)
+	}
+
+	var matching []corev1.Node
+	for _, node := range nodes.Items {
+		if hasNodeName(nodeNames, node.Name) {
+			matching = append(matching, node)
+		}
+	}
+	return controller.serversForNodes(matching, servers), nil
+}
+
+// Return the servers backing the given nodes. Nodes without a server in the given list are skipped.
+func (controller *Controller) serversForNodes(nodes []corev1.Node, servers []*hcloud.Server) (result []*hcloud.Server) {
+	for _, node := range nodes {
+		if server := controller.searchServerForIP(servers, searchForAddresses(node.Status.Addresses)); server != nil && !hasServerByID(result, server) {
+			result = append(result, server)
+		}
+	}
+	return result
+}
@@ -114,7 +114,7 @@ func TestNodeAddressList(t *testing.T) {
 		t.Run(test.name, func(t *testing.T) {
 			kubernetesFakeClient := fake.NewSimpleClientset(test.objects...)
 			controller := Controller{
-				HetznerClient:    nil,
+				Providers:        nil,
 				KubernetesClient: kubernetesFakeClient,
 				Backoff: wait.Backoff{
 					Steps: 1,
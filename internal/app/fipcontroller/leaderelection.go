@@ -54,8 +54,18 @@ func (controller *Controller) RunWithLeaderElection(ctx context.Context) {
 func (controller *Controller) onStartedLeading(ctx context.Context) {
 	controller.Logger.Info("Started leading")
 	leaderGauge.Set(1)
-	err := controller.Run(ctx)
-	if err != nil {
+	// A new leader has no history of unhealthy observations and starts its takeover grace period
+	controller.leadingSince = time.Now()
+	controller.unhealthyServers = nil
+	controller.pools = nil
+	// Failed reconciliations are retried by Run, it only returns an error if it could not start at all. If
+	// leadership was lost while starting, the next leader takes over. Otherwise this instance would keep the
+	// lease without reconciling, so it exits to let another replica take over.
+	if err := controller.Run(ctx); err != nil {
+		if ctx.Err() != nil {
+			controller.Logger.Errorf("Could not run controller: %v", err)
+			return
+		}
 		controller.Logger.Fatalf("Could not run controller: %v", err)
 	}
 }
@@ -5,6 +5,24 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
+// Kinds of IP addresses managed by the controller, used as kind label values
+const (
+	kindFloatingIP = "floating"
+	kindPrimaryIP  = "primary"
+	kindAliasIP    = "alias"
+)
+
+// Reasons for floating IP (re)assignments, used as reason label values
+const (
+	reasonUnassigned = "unassigned"
+	reasonFailover   = "failover"
+	reasonRebalance  = "rebalance"
+	reasonPolicy     = "policy"
+	reasonService    = "service"
+	reasonManual     = "manual"
+	reasonEvacuation = "evacuation"
+)
+
 // Prometheus metrics emitted by the controller. They are registered on the
 // default registry and served on the /metrics endpoint of the health server.
 var (
@@ -13,21 +31,82 @@ var (
 		Help: "Total number of reconciliation runs by result.",
 	}, []string{"result"})
 
+	reconcileTriggersTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "fip_controller_reconcile_triggers_total",
+		Help: "Total number of reconciliations by the change triggering them.",
+	}, []string{"trigger"})
+
 	reconcileDuration = promauto.NewHistogram(prometheus.HistogramOpts{
 		Name:    "fip_controller_reconcile_duration_seconds",
 		Help:    "Duration of reconciliation runs in seconds.",
 		Buckets: prometheus.DefBuckets,
 	})
 
-	reassignmentsTotal = promauto.NewCounter(prometheus.CounterOpts{
+	reassignmentsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
 		Name: "fip_controller_floating_ip_reassignments_total",
-		Help: "Total number of floating IP (re)assignments performed.",
+		Help: "Total number of IP (re)assignments performed by IP kind and reason.",
+	}, []string{"kind", "reason"})
+
+	dryRunReassignmentsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "fip_controller_dry_run_reassignments_total",
+		Help: "Total number of IP (re)assignments skipped in dry run mode by IP kind and reason.",
+	}, []string{"kind", "reason"})
+
+	assignmentDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "fip_controller_assignment_duration_seconds",
+		Help:    "Duration from requesting an IP assignment until it is verified to be complete in seconds by IP kind.",
+		Buckets: []float64{0.5, 1, 2, 5, 10, 20, 30, 60, 120, 300},
+	}, []string{"kind"})
+
+	blockedReassignmentsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "fip_controller_blocked_reassignments_total",
+		Help: "Total number of required IP (re)assignments that could not be performed by IP kind and reason.",
+	}, []string{"kind", "reason"})
+
+	hcloudCacheRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "fip_controller_hcloud_cache_requests_total",
+		Help: "Total number of hcloud cache lookups by resource and result (hit/miss).",
+	}, []string{"resource", "result"})
+
+	hcloudRateLimitRemaining = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "fip_controller_hcloud_rate_limit_remaining",
+		Help: "Remaining requests of the hcloud API rate limit, as reported by the last response.",
 	})
 
-	managedFloatingIPs = promauto.NewGauge(prometheus.GaugeOpts{
+	hcloudThrottledRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "fip_controller_hcloud_throttled_requests_total",
+		Help: "Total number of hcloud requests delayed because of the rate limit by reason (low_budget/rate_limited).",
+	}, []string{"reason"})
+
+	retriesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "fip_controller_retries_total",
+		Help: "Total number of retried hcloud and kubernetes calls by operation and error class.",
+	}, []string{"operation", "class"})
+
+	failedCallsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "fip_controller_failed_calls_total",
+		Help: "Total number of hcloud and kubernetes calls failed after all retries by operation and error class.",
+	}, []string{"operation", "class"})
+
+	addressErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "fip_controller_address_errors_total",
+		Help: "Total number of failed reconciliations of a single IP by IP kind.",
+	}, []string{"kind"})
+
+	invalidPoliciesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "fip_controller_invalid_policies_total",
+		Help: "Total number of IPs reconciled with the default policy because their policy labels are invalid by IP kind.",
+	}, []string{"kind"})
+
+	unmatchedNodes = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "fip_controller_unmatched_nodes",
+		Help: "Number of nodes without a matching hcloud server in the last reconciliation by IP kind.",
+	}, []string{"kind"})
+
+	managedFloatingIPs = promauto.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "fip_controller_managed_floating_ips",
-		Help: "Number of floating IPs currently managed by the controller.",
-	})
+		Help: "Number of IPs currently managed by the controller by IP kind.",
+	}, []string{"kind"})
 
 	leaderGauge = promauto.NewGauge(prometheus.GaugeOpts{
 		Name: "fip_controller_leader",
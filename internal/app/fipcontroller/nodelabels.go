@@ -0,0 +1,107 @@
+package fipcontroller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Label and annotation the leader keeps on the kubernetes nodes holding managed IPs
+const (
+	// NodeLabelHolder is set to "true" on nodes holding at least one managed IP, e.g. for node affinities of
+	// workloads following the IPs
+	NodeLabelHolder = "fip.hcloud/holder"
+	// NodeAnnotationIPs lists the managed IPs held by the node, comma separated and sorted
+	NodeAnnotationIPs = "fip.hcloud/ips"
+)
+
+// syncNodeLabels sets the holder label and the IP annotation on all nodes holding an IP observed in the last
+// reconciliation and removes them from all other nodes. If the reconciliation was not complete, i.e. some
+// addresses could not be discovered, IPs are only added to nodes, so IPs which were not observed are kept.
+func (controller *Controller) syncNodeLabels(ctx context.Context, complete bool) error {
+	held := make(map[string][]string)
+	for _, observed := range controller.observedAddresses {
+		if observed.node != "" {
+			held[observed.node] = append(held[observed.node], observed.address.IP.String())
+		}
+	}
+
+	nodes, err := controller.nodes(ctx)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, node := range nodes.Items {
+		ips := held[node.Name]
+		if !complete {
+			ips = append(ips, nodeIPs(node)...)
+		}
+		if err := controller.updateNodeLabels(ctx, node, uniqueSorted(ips)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// updateNodeLabels patches the holder label and the IP annotation of the node in a single request, so both always
+// match. The node is only patched if they changed.
+func (controller *Controller) updateNodeLabels(ctx context.Context, node corev1.Node, ips []string) error {
+	annotation := strings.Join(ips, ",")
+	if (node.Labels[NodeLabelHolder] == "true") == (len(ips) > 0) && node.Annotations[NodeAnnotationIPs] == annotation {
+		return nil
+	}
+
+	// Null values remove the label and the annotation with a merge patch
+	labels := map[string]interface{}{NodeLabelHolder: nil}
+	annotations := map[string]interface{}{NodeAnnotationIPs: nil}
+	if len(ips) > 0 {
+		labels[NodeLabelHolder] = "true"
+		annotations[NodeAnnotationIPs] = annotation
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels":      labels,
+			"annotations": annotations,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("could not encode labels of node '%s': %w", node.Name, err)
+	}
+
+	controller.Logger.Debugf("Updating IPs held by node '%s' to '%s'", node.Name, annotation)
+	err = controller.retry(operationPatchNode, func() error {
+		_, err := controller.KubernetesClient.CoreV1().Nodes().Patch(ctx, node.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("could not update labels of node '%s': %w", node.Name, err)
+	}
+	return nil
+}
+
+// nodeIPs returns the IPs listed in the IP annotation of the node
+func nodeIPs(node corev1.Node) []string {
+	if node.Annotations[NodeAnnotationIPs] == "" {
+		return nil
+	}
+	return strings.Split(node.Annotations[NodeAnnotationIPs], ",")
+}
+
+// uniqueSorted returns the sorted values without duplicates
+func uniqueSorted(values []string) (result []string) {
+	sort.Strings(values)
+	for i, value := range values {
+		if i == 0 || value != values[i-1] {
+			result = append(result, value)
+		}
+	}
+	return result
+}
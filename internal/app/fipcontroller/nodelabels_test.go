@@ -0,0 +1,81 @@
+package fipcontroller
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/cbeneke/hcloud-fip-controller/internal/pkg/configuration"
+)
+
+func TestSyncNodeLabels(t *testing.T) {
+	tests := []struct {
+		name     string
+		complete bool
+		results  map[string]string
+	}{
+		{
+			name:     "complete reconciliation",
+			complete: true,
+			results:  map[string]string{"node-1": "10.0.0.1,10.0.0.3", "node-2": "", "node-3": ""},
+		},
+		{
+			name:     "incomplete reconciliation keeps IPs",
+			complete: false,
+			results:  map[string]string{"node-1": "10.0.0.1,10.0.0.3", "node-2": "10.0.0.2", "node-3": ""},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stale := createTestNode("node-2", nil, v1.ConditionTrue)
+			stale.Labels = map[string]string{NodeLabelHolder: "true", "role": "edge"}
+			stale.Annotations = map[string]string{NodeAnnotationIPs: "10.0.0.2"}
+
+			kubernetesClient := fake.NewSimpleClientset(
+				createTestNode("node-1", nil, v1.ConditionTrue),
+				stale,
+				createTestNode("node-3", nil, v1.ConditionTrue),
+			)
+			controller := Controller{
+				KubernetesClient: kubernetesClient,
+				Backoff: wait.Backoff{
+					Steps: 1,
+				},
+				Configuration: &configuration.Configuration{NodeLabels: true},
+				Logger:        logrus.New(),
+				observedAddresses: []*observedAddress{
+					{address: &Address{IP: net.ParseIP("10.0.0.3")}, node: "node-1"},
+					{address: &Address{IP: net.ParseIP("10.0.0.1")}, node: "node-1"},
+					{address: &Address{IP: net.ParseIP("10.0.0.4")}},
+				},
+			}
+
+			if err := controller.syncNodeLabels(context.Background(), test.complete); err != nil {
+				t.Fatalf("error should be [nil] but was [%v]", err)
+			}
+
+			for name, ips := range test.results {
+				node, err := kubernetesClient.CoreV1().Nodes().Get(context.Background(), name, metav1.GetOptions{})
+				if err != nil {
+					t.Fatalf("error should be [nil] but was [%v]", err)
+				}
+				if node.Annotations[NodeAnnotationIPs] != ips {
+					t.Fatalf("node '%s' should hold '%s' but held '%s'", name, ips, node.Annotations[NodeAnnotationIPs])
+				}
+				if holder := node.Labels[NodeLabelHolder] == "true"; holder != (ips != "") {
+					t.Fatalf("holder label of node '%s' should be %t but was %t", name, ips != "", holder)
+				}
+			}
+			if node, _ := kubernetesClient.CoreV1().Nodes().Get(context.Background(), "node-2", metav1.GetOptions{}); node.Labels["role"] != "edge" {
+				t.Fatalf("other labels should be kept but were %v", node.Labels)
+			}
+		})
+	}
+}
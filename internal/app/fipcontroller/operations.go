@@ -0,0 +1,288 @@
+package fipcontroller
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"text/tabwriter"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// Health of the node holding an address
+const (
+	NodeHealthReady    = "Ready"
+	NodeHealthNotReady = "NotReady"
+)
+
+// StatusEntry is the current assignment of an address
+type StatusEntry struct {
+	Kind    string `json:"kind"`
+	Address string `json:"address"`
+	// Server and Node hold the address, empty if it is unassigned or the server is no kubernetes node
+	Server     string `json:"server"`
+	Node       string `json:"node"`
+	NodeHealth string `json:"nodeHealth"`
+	// PinnedNode is the node the address is pinned to, empty if it is not pinned
+	PinnedNode string `json:"pinnedNode,omitempty"`
+}
+
+// Status returns the current server and node of every managed address, and the health of that node
+func (controller *Controller) Status(ctx context.Context) ([]StatusEntry, error) {
+	nodes, err := controller.nodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	providers, err := controller.operationProviders(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []StatusEntry
+	// A floating IP selected by multiple pools is only reported for the first one, which manages it
+	seen := make(map[string]bool)
+	for _, provider := range providers {
+		servers, err := controller.providerServers(ctx, provider)
+		if err != nil {
+			return nil, err
+		}
+		addresses, err := controller.addresses(ctx, provider)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, address := range addresses {
+			if seen[planKey(provider, address)] {
+				continue
+			}
+			seen[planKey(provider, address)] = true
+			entry := StatusEntry{
+				Kind:       provider.Kind(),
+				Address:    address.IP.String(),
+				PinnedNode: address.Labels[LabelPinnedNode],
+			}
+			if address.Server != nil {
+				server := address.Server
+				for _, candidate := range servers {
+					if candidate.ID == server.ID {
+						server = candidate
+					}
+				}
+				// The holder is not listed if it is excluded by the server label selector
+				if lookup, ok := provider.(serverLookup); ok && server.Name == "" {
+					if holder, err := lookup.ServerByID(ctx, server.ID); err == nil && holder != nil {
+						server = holder
+					}
+				}
+				entry.Server = planServerName(servers, server)
+				if node := controller.nodeForServer(nodes, server); node != nil {
+					entry.Node = node.Name
+					entry.NodeHealth = NodeHealthNotReady
+					if isNodeHealthy(*node) {
+						entry.NodeHealth = NodeHealthReady
+					}
+				}
+			}
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// WriteStatus writes the status entries as table
+func WriteStatus(w io.Writer, entries []StatusEntry) error {
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "KIND\tADDRESS\tSERVER\tNODE\tHEALTH\tPINNED")
+	for _, entry := range entries {
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%s\n", entry.Kind, entry.Address, planColumn(entry.Server), planColumn(entry.Node), planColumn(entry.NodeHealth), planColumn(entry.PinnedNode))
+	}
+	return table.Flush()
+}
+
+// Move assigns the address to the server of the given node right away. The node has to be ready and the
+// server has to be able to hold the address. Addresses pinned to another node and assigned primary IPs can not
+// be moved.
+func (controller *Controller) Move(ctx context.Context, ip string, nodeName string) error {
+	provider, address, err := controller.findAddress(ctx, ip)
+	if err != nil {
+		return err
+	}
+	if pinned := address.Labels[LabelPinnedNode]; pinned != "" && pinned != nodeName {
+		return fmt.Errorf("address '%s' is pinned to node '%s', unpin it first", ip, pinned)
+	}
+
+	node, err := controller.node(ctx, nodeName)
+	if err != nil {
+		return err
+	}
+	if !isNodeHealthy(*node) {
+		return fmt.Errorf("node '%s' is not ready", nodeName)
+	}
+
+	servers, err := controller.providerServers(ctx, provider)
+	if err != nil {
+		return err
+	}
+	server := controller.searchServerForIP(servers, searchForAddresses(node.Status.Addresses))
+	if server == nil {
+		if lookup, ok := provider.(serverLookup); ok {
+			if named, err := lookup.ServerByName(ctx, nodeName); err == nil && named != nil && !hasServerByID(servers, named) {
+				return fmt.Errorf("server '%s' of node '%s' is not selected by the server label selector", named.Name, nodeName)
+			}
+		}
+		return fmt.Errorf("could not find a server for node '%s'", nodeName)
+	}
+	if address.Server != nil && address.Server.ID == server.ID {
+		controller.Logger.Infof("Address '%s' already is on server '%s'", ip, server.Name)
+		return nil
+	}
+
+	candidates, placement := provider.Placement([]*hcloud.Server{server}, address)
+	if len(candidates) < 1 {
+		return fmt.Errorf("server '%s' of node '%s' can not hold address '%s'", server.Name, nodeName, ip)
+	}
+
+	// Moving an assigned primary IP would shut down its healthy server without a drain
+	reason := reasonManual
+	if address.Server == nil {
+		reason = reasonUnassigned
+	}
+	if provider.Kind() == kindPrimaryIP {
+		if blocked := primaryIPMoveBlocked(address, reason); blocked != nil {
+			return blocked
+		}
+	}
+
+	controller.Logger.WithField("kind", provider.Kind()).Infof("Moving address '%s' to server '%s' of node '%s'", ip, server.Name, nodeName)
+	if err := controller.assignAddress(withAssignmentReason(ctx, reason), provider, address, server); err != nil {
+		return err
+	}
+	controller.recordReassignment(ctx, provider, address, server, reasonManual, placement)
+	return nil
+}
+
+// Pin the address to the given node by setting the pinned node label. The leader moves the address to the
+// node with its next reconciliation.
+func (controller *Controller) Pin(ctx context.Context, ip string, nodeName string) error {
+	provider, address, err := controller.findAddress(ctx, ip)
+	if err != nil {
+		return err
+	}
+	if _, err := controller.node(ctx, nodeName); err != nil {
+		return err
+	}
+
+	labels := make(map[string]string, len(address.Labels)+1)
+	for key, value := range address.Labels {
+		labels[key] = value
+	}
+	labels[LabelPinnedNode] = nodeName
+
+	controller.Logger.Infof("Pinning address '%s' to node '%s'", ip, nodeName)
+	return controller.updateAddressLabels(ctx, provider, address, labels)
+}
+
+// Unpin the address by removing the pinned node label
+func (controller *Controller) Unpin(ctx context.Context, ip string) error {
+	provider, address, err := controller.findAddress(ctx, ip)
+	if err != nil {
+		return err
+	}
+	if _, ok := address.Labels[LabelPinnedNode]; !ok {
+		controller.Logger.Infof("Address '%s' is not pinned", ip)
+		return nil
+	}
+
+	labels := make(map[string]string, len(address.Labels))
+	for key, value := range address.Labels {
+		if key != LabelPinnedNode {
+			labels[key] = value
+		}
+	}
+
+	controller.Logger.Infof("Unpinning address '%s'", ip)
+	return controller.updateAddressLabels(ctx, provider, address, labels)
+}
+
+// Search the address of all providers matching the given IP
+func (controller *Controller) findAddress(ctx context.Context, ip string) (IPProvider, *Address, error) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return nil, nil, fmt.Errorf("'%s' is not a valid IP address", ip)
+	}
+	providers, err := controller.operationProviders(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, provider := range providers {
+		addresses, err := controller.addresses(ctx, provider)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, address := range addresses {
+			if addressMatches(address, parsed) {
+				return provider, address, nil
+			}
+		}
+	}
+	return nil, nil, fmt.Errorf("IP address '%s' is not managed by the controller", ip)
+}
+
+// Return the providers of the controller followed by the providers of the FloatingIPPools
+func (controller *Controller) operationProviders(ctx context.Context) ([]IPProvider, error) {
+	if !controller.Configuration.FloatingIPPools {
+		return controller.Providers, nil
+	}
+	poolProviders, err := controller.poolProviders(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]IPProvider{}, controller.Providers...), poolProviders...), nil
+}
+
+// Fetch all servers of the provider
+func (controller *Controller) providerServers(ctx context.Context, provider IPProvider) (servers []*hcloud.Server, err error) {
+	err = controller.retry(operationListServers, func() error {
+		servers, err = provider.Servers(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch servers: %w", err)
+	}
+	return servers, nil
+}
+
+// Fetch all kubernetes nodes
+func (controller *Controller) nodes(ctx context.Context) (*corev1.NodeList, error) {
+	nodes, err := controller.listNodes(ctx, "")
+	if err != nil {
+		return nil, fmt.Errorf("could not list nodes: %w", err)
+	}
+	return nodes, nil
+}
+
+// Fetch the kubernetes node with the given name
+func (controller *Controller) node(ctx context.Context, name string) (*corev1.Node, error) {
+	node, err := controller.getNode(ctx, name)
+	if apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("node '%s' does not exist", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not get node '%s': %w", name, err)
+	}
+	return node, nil
+}
+
+// Return the kubernetes node backed by the given server, or nil if the server is no node
+func (controller *Controller) nodeForServer(nodes *corev1.NodeList, server *hcloud.Server) *corev1.Node {
+	for i := range nodes.Items {
+		if controller.searchServerForIP([]*hcloud.Server{server}, searchForAddresses(nodes.Items[i].Status.Addresses)) != nil {
+			return &nodes.Items[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,231 @@
+package fipcontroller
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/cbeneke/hcloud-fip-controller/internal/pkg/configuration"
+)
+
+// Create a controller with a fake provider holding the given address and three servers backing the nodes
+// node-1 (ready), node-2 (ready) and node-3 (not ready)
+func createTestOperationsController(address *Address) (*Controller, *fakeProvider) {
+	servers := []*hcloud.Server{
+		{ID: 1, Name: "server-1", PublicNet: hcloud.ServerPublicNet{IPv4: hcloud.ServerPublicNetIPv4{IP: net.ParseIP("1.1.1.1")}}},
+		{ID: 2, Name: "server-2", PublicNet: hcloud.ServerPublicNet{IPv4: hcloud.ServerPublicNetIPv4{IP: net.ParseIP("2.2.2.2")}}},
+		{ID: 3, Name: "server-3", PublicNet: hcloud.ServerPublicNet{IPv4: hcloud.ServerPublicNetIPv4{IP: net.ParseIP("3.3.3.3")}}},
+	}
+	provider := newFakeProvider([]*Address{address}, servers)
+
+	kubernetesFakeClient := fake.NewSimpleClientset(
+		createTestNode("node-1", []v1.NodeAddress{{Type: v1.NodeExternalIP, Address: "1.1.1.1"}}, v1.ConditionTrue),
+		createTestNode("node-2", []v1.NodeAddress{{Type: v1.NodeExternalIP, Address: "2.2.2.2"}}, v1.ConditionTrue),
+		createTestNode("node-3", []v1.NodeAddress{{Type: v1.NodeExternalIP, Address: "3.3.3.3"}}, v1.ConditionFalse),
+	)
+	return &Controller{
+		Providers:        []IPProvider{provider},
+		KubernetesClient: kubernetesFakeClient,
+		Backoff: wait.Backoff{
+			Steps: 1,
+		},
+		Configuration: &configuration.Configuration{},
+		Logger:        logrus.New(),
+	}, provider
+}
+
+func TestStatus(t *testing.T) {
+	tests := []struct {
+		name    string
+		address *Address
+		result  StatusEntry
+	}{
+		{
+			name:    "assigned to ready node",
+			address: &Address{ID: 1, IP: net.ParseIP("10.0.0.1"), Server: &hcloud.Server{ID: 1}},
+			result:  StatusEntry{Kind: kindFloatingIP, Address: "10.0.0.1", Server: "server-1", Node: "node-1", NodeHealth: NodeHealthReady},
+		},
+		{
+			name: "pinned and assigned to not ready node",
+			address: &Address{ID: 1, IP: net.ParseIP("10.0.0.1"), Server: &hcloud.Server{ID: 3}, Labels: map[string]string{
+				LabelPinnedNode: "node-3",
+			}},
+			result: StatusEntry{Kind: kindFloatingIP, Address: "10.0.0.1", Server: "server-3", Node: "node-3", NodeHealth: NodeHealthNotReady, PinnedNode: "node-3"},
+		},
+		{
+			name:    "unassigned",
+			address: &Address{ID: 1, IP: net.ParseIP("10.0.0.1")},
+			result:  StatusEntry{Kind: kindFloatingIP, Address: "10.0.0.1"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			controller, _ := createTestOperationsController(test.address)
+
+			entries, err := controller.Status(context.Background())
+			if err != nil {
+				t.Fatalf("error should be [nil] but was [%v]", err)
+			}
+			if !reflect.DeepEqual([]StatusEntry{test.result}, entries) {
+				t.Fatalf("status should be %v but was %v", []StatusEntry{test.result}, entries)
+			}
+		})
+	}
+}
+
+func TestMove(t *testing.T) {
+	tests := []struct {
+		name     string
+		ip       string
+		node     string
+		kind     string
+		labels   map[string]string
+		err      bool
+		resultID int64
+	}{
+		{
+			name:     "move to ready node",
+			ip:       "10.0.0.1",
+			node:     "node-2",
+			resultID: 2,
+		},
+		{
+			name: "already on node",
+			ip:   "10.0.0.1",
+			node: "node-1",
+		},
+		{
+			name: "node not ready",
+			ip:   "10.0.0.1",
+			node: "node-3",
+			err:  true,
+		},
+		{
+			name: "unknown node",
+			ip:   "10.0.0.1",
+			node: "node-4",
+			err:  true,
+		},
+		{
+			name:   "pinned to other node",
+			ip:     "10.0.0.1",
+			node:   "node-2",
+			labels: map[string]string{LabelPinnedNode: "node-1"},
+			err:    true,
+		},
+		{
+			name: "unmanaged ip",
+			ip:   "10.0.0.2",
+			node: "node-2",
+			err:  true,
+		},
+		{
+			name: "assigned primary ip",
+			ip:   "10.0.0.1",
+			node: "node-2",
+			kind: kindPrimaryIP,
+			err:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			address := &Address{ID: 1, IP: net.ParseIP("10.0.0.1"), Server: &hcloud.Server{ID: 1}, Labels: test.labels}
+			controller, provider := createTestOperationsController(address)
+			if test.kind != "" {
+				provider.kind = test.kind
+			}
+
+			err := controller.Move(context.Background(), test.ip, test.node)
+			if (err != nil) != test.err {
+				t.Fatalf("error should be %t but was [%v]", test.err, err)
+			}
+			if provider.assigned[1] != test.resultID {
+				t.Fatalf("address should be assigned to server [%d] but was [%d]", test.resultID, provider.assigned[1])
+			}
+		})
+	}
+}
+
+func TestPinAndUnpin(t *testing.T) {
+	address := &Address{ID: 1, IP: net.ParseIP("10.0.0.1"), Labels: map[string]string{"foo": "bar"}}
+	controller, provider := createTestOperationsController(address)
+
+	if err := controller.Pin(context.Background(), "10.0.0.1", "node-4"); err == nil {
+		t.Fatalf("pinning to an unknown node should fail")
+	}
+
+	if err := controller.Pin(context.Background(), "10.0.0.1", "node-2"); err != nil {
+		t.Fatalf("error should be [nil] but was [%v]", err)
+	}
+	expected := map[string]string{"foo": "bar", LabelPinnedNode: "node-2"}
+	if !reflect.DeepEqual(expected, provider.labels[1]) {
+		t.Fatalf("labels should be %v but were %v", expected, provider.labels[1])
+	}
+
+	if err := controller.Unpin(context.Background(), "10.0.0.1"); err != nil {
+		t.Fatalf("error should be [nil] but was [%v]", err)
+	}
+	expected = map[string]string{"foo": "bar"}
+	if !reflect.DeepEqual(expected, provider.labels[1]) {
+		t.Fatalf("labels should be %v but were %v", expected, provider.labels[1])
+	}
+}
+
+func TestPoolAddresses(t *testing.T) {
+	address := &Address{ID: 2, IP: net.ParseIP("10.0.0.2"), Server: &hcloud.Server{ID: 1}}
+	controller, _ := createTestOperationsController(&Address{ID: 1, IP: net.ParseIP("10.0.0.1")})
+	controller.Configuration.FloatingIPPools = true
+	controller.DynamicClient = dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{poolResource: "FloatingIPPoolList"},
+		createTestPool("fip", "edge", map[string]interface{}{"floatingIPs": []interface{}{"10.0.0.2"}}),
+		createTestPool("web", "overlapping", map[string]interface{}{"floatingIPs": []interface{}{"10.0.0.2", "10.0.0.3"}}),
+	)
+	// The providers of the pools are keyed by their floating IPs, as they are created for every operation
+	providers := make(map[string]*fakeProvider)
+	controller.poolProvider = func(config *configuration.Configuration) IPProvider {
+		key := strings.Join(config.HcloudFloatingIPs, ",")
+		if _, ok := providers[key]; !ok {
+			providers[key] = newFakeProvider([]*Address{address}, controller.Providers[0].(*fakeProvider).servers)
+		}
+		return providers[key]
+	}
+
+	entries, err := controller.Status(context.Background())
+	if err != nil {
+		t.Fatalf("error should be [nil] but was [%v]", err)
+	}
+	expected := []StatusEntry{
+		{Kind: kindFloatingIP, Address: "10.0.0.1"},
+		{Kind: kindFloatingIP, Address: "10.0.0.2", Server: "server-1", Node: "node-1", NodeHealth: NodeHealthReady},
+	}
+	if !reflect.DeepEqual(expected, entries) {
+		t.Fatalf("status should be %v but was %v", expected, entries)
+	}
+
+	if err := controller.Pin(context.Background(), "10.0.0.2", "node-2"); err != nil {
+		t.Fatalf("error should be [nil] but was [%v]", err)
+	}
+	if providers["10.0.0.2"].labels[2][LabelPinnedNode] != "node-2" {
+		t.Fatalf("floating IP of the first pool should be pinned to node-2 but labels were %v", providers["10.0.0.2"].labels[2])
+	}
+
+	if err := controller.Move(context.Background(), "10.0.0.2", "node-2"); err != nil {
+		t.Fatalf("error should be [nil] but was [%v]", err)
+	}
+	if providers["10.0.0.2"].assigned[2] != 2 || len(providers["10.0.0.2,10.0.0.3"].assigned) > 0 {
+		t.Fatalf("floating IP of the first pool should be moved to server 2 but was assigned %v", providers["10.0.0.2"].assigned)
+	}
+}
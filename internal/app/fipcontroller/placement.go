@@ -0,0 +1,79 @@
+package fipcontroller
+
+import (
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+)
+
+// Placement tiers describing how the candidate servers for an address were chosen
+const (
+	// placementHomeLocation is used when servers in the home location of the floating IP are available
+	placementHomeLocation = "home-location"
+	// placementNetworkZone is used when only servers in other locations of the same network zone are available
+	placementNetworkZone = "network-zone"
+	// placementAny is used when the home location of the floating IP is unknown
+	placementAny = "any"
+	// placementNetwork is used for alias IPs, which can be assigned to all servers attached to their network
+	placementNetwork = "network"
+	// placementNone is used when no server can route the address
+	placementNone = "none"
+)
+
+// placementCandidates filters the given servers down to the ones a floating IP should be assigned to.
+// Servers in the home location of the floating IP are preferred, otherwise servers in other locations of
+// the same network zone are used. Servers in other network zones (or with an unknown network zone) can
+// not route the floating IP and are never returned.
+func placementCandidates(servers []*hcloud.Server, floatingIP *Address) ([]*hcloud.Server, string) {
+	home := floatingIP.Location
+	if home == nil || home.Name == "" {
+		return servers, placementAny
+	}
+
+	var homeLocation, networkZone []*hcloud.Server
+	for _, server := range servers {
+		if server.Location == nil {
+			continue
+		}
+		if server.Location.Name == home.Name {
+			homeLocation = append(homeLocation, server)
+		} else if home.NetworkZone != "" && server.Location.NetworkZone == home.NetworkZone {
+			networkZone = append(networkZone, server)
+		}
+	}
+
+	if len(homeLocation) > 0 {
+		return homeLocation, placementHomeLocation
+	}
+	if len(networkZone) > 0 {
+		return networkZone, placementNetworkZone
+	}
+	return nil, placementNone
+}
+
+// placementRank orders the placement tiers, a lower rank is a better placement. Tiers of addresses without a
+// home location share the best rank.
+func placementRank(placement string) int {
+	switch placement {
+	case placementNetworkZone:
+		return 1
+	case placementNone:
+		return 2
+	default:
+		return 0
+	}
+}
+
+// Return the name of the servers location or an empty string if the location is unknown
+func serverLocation(server *hcloud.Server) string {
+	if server.Location == nil {
+		return ""
+	}
+	return server.Location.Name
+}
+
+// Return the name of the addresses location or an empty string if the location is unknown
+func addressLocation(address *Address) string {
+	if address.Location == nil {
+		return ""
+	}
+	return address.Location.Name
+}
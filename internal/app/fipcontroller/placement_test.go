@@ -0,0 +1,67 @@
+package fipcontroller
+
+import (
+	"testing"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+)
+
+func TestPlacementCandidates(t *testing.T) {
+	fsn1 := &hcloud.Location{Name: "fsn1", NetworkZone: hcloud.NetworkZoneEUCentral}
+	nbg1 := &hcloud.Location{Name: "nbg1", NetworkZone: hcloud.NetworkZoneEUCentral}
+	ash := &hcloud.Location{Name: "ash", NetworkZone: hcloud.NetworkZoneUSEast}
+
+	tests := []struct {
+		name         string
+		homeLocation *hcloud.Location
+		servers      []*hcloud.Server
+		resultIDs    []int64
+		placement    string
+	}{
+		{
+			name:      "unknown home location uses all servers",
+			servers:   []*hcloud.Server{{ID: 1, Location: ash}, {ID: 2}},
+			resultIDs: []int64{1, 2},
+			placement: placementAny,
+		},
+		{
+			name:         "prefer home location",
+			homeLocation: fsn1,
+			servers:      []*hcloud.Server{{ID: 1, Location: nbg1}, {ID: 2, Location: fsn1}},
+			resultIDs:    []int64{2},
+			placement:    placementHomeLocation,
+		},
+		{
+			name:         "fall back to network zone",
+			homeLocation: fsn1,
+			servers:      []*hcloud.Server{{ID: 1, Location: nbg1}, {ID: 2, Location: ash}},
+			resultIDs:    []int64{1},
+			placement:    placementNetworkZone,
+		},
+		{
+			name:         "never use other network zones",
+			homeLocation: fsn1,
+			servers:      []*hcloud.Server{{ID: 1, Location: ash}, {ID: 2}},
+			resultIDs:    nil,
+			placement:    placementNone,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			candidates, placement := placementCandidates(test.servers, &Address{Location: test.homeLocation})
+
+			if placement != test.placement {
+				t.Fatalf("placement should be [%s] but was [%s]", test.placement, placement)
+			}
+			if len(candidates) != len(test.resultIDs) {
+				t.Fatalf("candidates should be length %d but was length %d", len(test.resultIDs), len(candidates))
+			}
+			for i, candidate := range candidates {
+				if candidate.ID != test.resultIDs[i] {
+					t.Fatalf("candidate should be [%d] but was [%d]", test.resultIDs[i], candidate.ID)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package fipcontroller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+)
+
+// Output formats of a plan
+const (
+	PlanOutputTable = "table"
+	PlanOutputJSON  = "json"
+)
+
+// PlanReasonPendingFailover is the reason of addresses on failed servers which are not moved yet, as the
+// unhealthy threshold or duration of their server is not reached
+const PlanReasonPendingFailover = "pending-failover"
+
+// PlanEntry is the current and the desired assignment of an address
+type PlanEntry struct {
+	Kind    string `json:"kind"`
+	Address string `json:"address"`
+	// Current and Desired are the names of the servers holding the address, empty if it is unassigned
+	Current string `json:"current"`
+	Desired string `json:"desired"`
+	// Reason of the (re)assignment, empty if the address stays where it is
+	Reason string `json:"reason,omitempty"`
+}
+
+// Plan runs the discovery and all placement decisions of one reconciliation in dry run mode and returns the
+// current and desired assignment of every managed address, including the floating IPs of FloatingIPPools.
+// A single run observes failed servers only once, so failovers waiting for the unhealthy threshold or duration
+// are reported as pending instead. Nothing is changed.
+func (controller *Controller) Plan(ctx context.Context) ([]PlanEntry, error) {
+	config := *controller.Configuration
+	config.DryRun = true
+	previous := controller.Configuration
+	controller.Configuration = &config
+	// The pool controllers of a running controller are not in dry run mode, so the pools get new ones
+	pools := controller.pools
+	controller.pools = nil
+	defer func() {
+		controller.Configuration = previous
+		controller.pools = pools
+	}()
+
+	var entries []PlanEntry
+	if len(controller.Providers) > 0 || !config.FloatingIPPools {
+		runningServers, addresses, err := controller.discover(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if entries, err = controller.planAddresses(ctx, runningServers, addresses); err != nil {
+			return nil, err
+		}
+	}
+	if config.FloatingIPPools {
+		poolEntries, err := controller.planPools(ctx)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, poolEntries...)
+	}
+	return entries, nil
+}
+
+// planPools returns the plan entries of the floating IPs of all FloatingIPPools. Like in the reconciliation,
+// pools with an invalid spec are skipped and a floating IP is only planned by the first pool selecting it.
+func (controller *Controller) planPools(ctx context.Context) ([]PlanEntry, error) {
+	pools, err := controller.listPools(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not list floating IP pools: %w", err)
+	}
+
+	var entries []PlanEntry
+	claimed := make(map[int64]string)
+	for _, pool := range pools {
+		key := pool.Namespace + "/" + pool.Name
+		poolController, err := controller.poolController(key, pool)
+		if err != nil {
+			controller.Logger.Warnf("Ignoring floating IP pool '%s' with invalid spec: %v", key, err)
+			continue
+		}
+
+		runningServers, addresses, err := poolController.controller.discover(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("could not plan floating IP pool '%s': %w", key, err)
+		}
+		owned, errs := claimAddresses(key, addresses[0], claimed)
+		for _, err := range errs {
+			controller.Logger.Warnf("Skipping floating IP of pool '%s': %v", key, err)
+		}
+		addresses[0] = owned
+
+		poolEntries, err := poolController.controller.planAddresses(ctx, runningServers, addresses)
+		if err != nil {
+			return nil, fmt.Errorf("could not plan floating IP pool '%s': %w", key, err)
+		}
+		entries = append(entries, poolEntries...)
+	}
+	return entries, nil
+}
+
+// planAddresses reconciles the discovered addresses in dry run mode and returns their plan entries
+func (controller *Controller) planAddresses(ctx context.Context, runningServers [][]*hcloud.Server, addresses [][]*Address) ([]PlanEntry, error) {
+	// Addresses are (re)assigned in memory by the dry run, so the current holders are remembered first
+	current := make([][]*hcloud.Server, len(addresses))
+	for i := range addresses {
+		for _, address := range addresses[i] {
+			current[i] = append(current[i], address.Server)
+		}
+	}
+
+	if err := controller.reconcile(ctx, runningServers, addresses); err != nil {
+		return nil, err
+	}
+
+	var entries []PlanEntry
+	for i, provider := range controller.Providers {
+		for j, address := range addresses[i] {
+			reason := controller.reassignedReasons[planKey(provider, address)]
+			if reason == "" && controller.pendingFailovers[planKey(provider, address)] {
+				reason = PlanReasonPendingFailover
+			}
+			entries = append(entries, PlanEntry{
+				Kind:    provider.Kind(),
+				Address: address.IP.String(),
+				Current: planServerName(runningServers[i], current[i][j]),
+				Desired: planServerName(runningServers[i], address.Server),
+				Reason:  reason,
+			})
+		}
+	}
+	return entries, nil
+}
+
+// WritePlan writes the plan entries in the given output format
+func WritePlan(w io.Writer, entries []PlanEntry, output string) error {
+	switch output {
+	case PlanOutputJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if entries == nil {
+			entries = []PlanEntry{}
+		}
+		return encoder.Encode(entries)
+	case PlanOutputTable:
+		table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(table, "KIND\tADDRESS\tCURRENT\tDESIRED\tREASON")
+		for _, entry := range entries {
+			fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\n", entry.Kind, entry.Address, planColumn(entry.Current), planColumn(entry.Desired), planColumn(entry.Reason))
+		}
+		return table.Flush()
+	default:
+		return fmt.Errorf("unknown plan output '%s', must be one of %s, %s", output, PlanOutputTable, PlanOutputJSON)
+	}
+}
+
+// Key of an address in the planned reasons
+func planKey(provider IPProvider, address *Address) string {
+	return provider.Kind() + "/" + strconv.FormatInt(address.ID, 10)
+}
+
+// Return the name of the server. Servers which are not running may only be known by their ID.
+func planServerName(runningServers []*hcloud.Server, server *hcloud.Server) string {
+	if server == nil {
+		return ""
+	}
+	if server.Name != "" {
+		return server.Name
+	}
+	for _, running := range runningServers {
+		if running.ID == server.ID {
+			return running.Name
+		}
+	}
+	return strconv.FormatInt(server.ID, 10)
+}
+
+func planColumn(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
@@ -0,0 +1,263 @@
+package fipcontroller
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/cbeneke/hcloud-fip-controller/internal/pkg/configuration"
+)
+
+func TestPlan(t *testing.T) {
+	servers := []*hcloud.Server{
+		{ID: 1, Name: "server-1", PublicNet: hcloud.ServerPublicNet{IPv4: hcloud.ServerPublicNetIPv4{IP: net.ParseIP("1.1.1.1")}}},
+		{ID: 2, Name: "server-2", PublicNet: hcloud.ServerPublicNet{IPv4: hcloud.ServerPublicNetIPv4{IP: net.ParseIP("2.2.2.2")}}},
+	}
+	provider := newFakeProvider([]*Address{
+		{ID: 1, IP: net.ParseIP("10.0.0.1"), Server: &hcloud.Server{ID: 1}},
+		{ID: 2, IP: net.ParseIP("10.0.0.2")},
+		{ID: 3, IP: net.ParseIP("10.0.0.3"), Server: &hcloud.Server{ID: 2}},
+	}, servers)
+
+	kubernetesFakeClient := fake.NewSimpleClientset(
+		createTestNode("node-1", []v1.NodeAddress{{Type: v1.NodeExternalIP, Address: "1.1.1.1"}}, v1.ConditionTrue),
+		createTestNode("node-2", []v1.NodeAddress{{Type: v1.NodeExternalIP, Address: "2.2.2.2"}}, v1.ConditionFalse),
+	)
+	config := &configuration.Configuration{
+		Namespace:       "fip",
+		NodeAddressType: configuration.NodeAddressTypeExternal,
+	}
+	controller := Controller{
+		Providers:        []IPProvider{provider},
+		KubernetesClient: kubernetesFakeClient,
+		Backoff: wait.Backoff{
+			Steps: 1,
+		},
+		Configuration: config,
+		Logger:        logrus.New(),
+	}
+
+	entries, err := controller.Plan(context.Background())
+	if err != nil {
+		t.Fatalf("error should be [nil] but was [%v]", err)
+	}
+
+	expected := []PlanEntry{
+		{Kind: kindFloatingIP, Address: "10.0.0.1", Current: "server-1", Desired: "server-1"},
+		{Kind: kindFloatingIP, Address: "10.0.0.2", Desired: "server-1", Reason: reasonUnassigned},
+		{Kind: kindFloatingIP, Address: "10.0.0.3", Current: "2", Desired: "server-1", Reason: reasonFailover},
+	}
+	if !reflect.DeepEqual(expected, entries) {
+		t.Fatalf("plan should be %v but was %v", expected, entries)
+	}
+	if len(provider.assigned) > 0 {
+		t.Fatalf("plan should not assign addresses but assigned %v", provider.assigned)
+	}
+	if controller.Configuration != config || config.DryRun {
+		t.Fatalf("plan should restore the configuration")
+	}
+}
+
+func TestPlanPendingFailover(t *testing.T) {
+	servers := []*hcloud.Server{
+		{ID: 1, Name: "server-1", PublicNet: hcloud.ServerPublicNet{IPv4: hcloud.ServerPublicNetIPv4{IP: net.ParseIP("1.1.1.1")}}},
+		{ID: 2, Name: "server-2", PublicNet: hcloud.ServerPublicNet{IPv4: hcloud.ServerPublicNetIPv4{IP: net.ParseIP("2.2.2.2")}}},
+	}
+
+	tests := []struct {
+		name   string
+		config *configuration.Configuration
+		result PlanEntry
+	}{
+		{
+			name:   "unhealthy threshold",
+			config: &configuration.Configuration{UnhealthyThreshold: 2},
+			result: PlanEntry{Kind: kindFloatingIP, Address: "10.0.0.1", Current: "2", Desired: "2", Reason: PlanReasonPendingFailover},
+		},
+		{
+			name:   "unhealthy duration",
+			config: &configuration.Configuration{UnhealthyDuration: time.Minute},
+			result: PlanEntry{Kind: kindFloatingIP, Address: "10.0.0.1", Current: "2", Desired: "2", Reason: PlanReasonPendingFailover},
+		},
+		{
+			name:   "no damping",
+			config: &configuration.Configuration{},
+			result: PlanEntry{Kind: kindFloatingIP, Address: "10.0.0.1", Current: "2", Desired: "server-1", Reason: reasonFailover},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			provider := newFakeProvider([]*Address{
+				{ID: 1, IP: net.ParseIP("10.0.0.1"), Server: &hcloud.Server{ID: 2}},
+			}, servers)
+			test.config.Namespace = "fip"
+			test.config.NodeAddressType = configuration.NodeAddressTypeExternal
+			controller := Controller{
+				Providers: []IPProvider{provider},
+				KubernetesClient: fake.NewSimpleClientset(
+					createTestNode("node-1", []v1.NodeAddress{{Type: v1.NodeExternalIP, Address: "1.1.1.1"}}, v1.ConditionTrue),
+					createTestNode("node-2", []v1.NodeAddress{{Type: v1.NodeExternalIP, Address: "2.2.2.2"}}, v1.ConditionFalse),
+				),
+				Backoff: wait.Backoff{
+					Steps: 1,
+				},
+				Configuration: test.config,
+				Logger:        logrus.New(),
+			}
+
+			entries, err := controller.Plan(context.Background())
+			if err != nil {
+				t.Fatalf("error should be [nil] but was [%v]", err)
+			}
+			if !reflect.DeepEqual([]PlanEntry{test.result}, entries) {
+				t.Fatalf("plan should be %v but was %v", []PlanEntry{test.result}, entries)
+			}
+		})
+	}
+}
+
+func TestPlanPools(t *testing.T) {
+	servers := []*hcloud.Server{
+		{ID: 1, Name: "server-1", PublicNet: hcloud.ServerPublicNet{IPv4: hcloud.ServerPublicNetIPv4{IP: net.ParseIP("1.1.1.1")}}},
+	}
+	addresses := []*Address{
+		{ID: 1, IP: net.ParseIP("10.0.0.1"), Server: &hcloud.Server{ID: 1}},
+		{ID: 2, IP: net.ParseIP("10.0.0.2")},
+	}
+
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{poolResource: "FloatingIPPoolList"},
+		createTestPool("fip", "edge", map[string]interface{}{"floatingIPs": []interface{}{"10.0.0.1", "10.0.0.2"}}),
+		createTestPool("fip", "invalid", map[string]interface{}{}),
+		createTestPool("web", "overlapping", map[string]interface{}{"floatingIPs": []interface{}{"10.0.0.2"}}),
+	)
+
+	var providers []*fakeProvider
+	controller := Controller{
+		KubernetesClient: fake.NewSimpleClientset(
+			createTestNode("node-1", []v1.NodeAddress{{Type: v1.NodeExternalIP, Address: "1.1.1.1"}}, v1.ConditionTrue),
+		),
+		DynamicClient: dynamicClient,
+		Backoff: wait.Backoff{
+			Steps: 1,
+		},
+		Configuration: &configuration.Configuration{
+			Namespace:       "fip",
+			NodeAddressType: configuration.NodeAddressTypeExternal,
+			FloatingIPPools: true,
+		},
+		Logger: logrus.New(),
+		poolProvider: func(config *configuration.Configuration) IPProvider {
+			var poolAddresses []*Address
+			for _, address := range addresses {
+				for _, floatingIP := range config.HcloudFloatingIPs {
+					if address.IP.Equal(net.ParseIP(floatingIP)) {
+						poolAddresses = append(poolAddresses, &Address{ID: address.ID, IP: address.IP, Server: address.Server})
+					}
+				}
+			}
+			provider := newFakeProvider(poolAddresses, servers)
+			providers = append(providers, provider)
+			return provider
+		},
+	}
+
+	entries, err := controller.Plan(context.Background())
+	if err != nil {
+		t.Fatalf("error should be [nil] but was [%v]", err)
+	}
+
+	expected := []PlanEntry{
+		{Kind: kindFloatingIP, Address: "10.0.0.1", Current: "server-1", Desired: "server-1"},
+		{Kind: kindFloatingIP, Address: "10.0.0.2", Desired: "server-1", Reason: reasonUnassigned},
+	}
+	if !reflect.DeepEqual(expected, entries) {
+		t.Fatalf("plan should be %v but was %v", expected, entries)
+	}
+	for _, provider := range providers {
+		if len(provider.assigned) > 0 {
+			t.Fatalf("plan should not assign addresses but assigned %v", provider.assigned)
+		}
+	}
+	for _, action := range dynamicClient.Actions() {
+		if action.GetVerb() != "list" {
+			t.Fatalf("plan should not write floating IP pools but did %s %s", action.GetVerb(), action.GetSubresource())
+		}
+	}
+	if controller.pools != nil {
+		t.Fatalf("plan should not keep the pool controllers")
+	}
+}
+
+func TestWritePlan(t *testing.T) {
+	entries := []PlanEntry{
+		{Kind: kindFloatingIP, Address: "10.0.0.1", Current: "server-1", Desired: "server-1"},
+		{Kind: kindFloatingIP, Address: "10.0.0.2", Desired: "server-1", Reason: reasonUnassigned},
+	}
+
+	tests := []struct {
+		name   string
+		output string
+		result string
+		err    bool
+	}{
+		{
+			name:   "table",
+			output: PlanOutputTable,
+			result: "KIND      ADDRESS   CURRENT   DESIRED   REASON\n" +
+				"floating  10.0.0.1  server-1  server-1  -\n" +
+				"floating  10.0.0.2  -         server-1  unassigned\n",
+		},
+		{
+			name:   "json",
+			output: PlanOutputJSON,
+			result: `[
+  {
+    "kind": "floating",
+    "address": "10.0.0.1",
+    "current": "server-1",
+    "desired": "server-1"
+  },
+  {
+    "kind": "floating",
+    "address": "10.0.0.2",
+    "current": "",
+    "desired": "server-1",
+    "reason": "unassigned"
+  }
+]
+`,
+		},
+		{
+			name:   "unknown output",
+			output: "yaml",
+			err:    true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buffer bytes.Buffer
+			err := WritePlan(&buffer, entries, test.output)
+			if (err != nil) != test.err {
+				t.Fatalf("error should be %t but was [%v]", test.err, err)
+			}
+			if buffer.String() != test.result {
+				t.Fatalf("output should be\n%s\nbut was\n%s", test.result, buffer.String())
+			}
+		})
+	}
+}
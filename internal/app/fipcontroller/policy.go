@@ -0,0 +1,253 @@
+package fipcontroller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Well-known hcloud labels on addresses that control their placement
+const (
+	// LabelPriority orders the addresses. Addresses with a higher priority are placed first
+	LabelPriority = "fip.hcloud/priority"
+	// LabelStrategy overrides the assignment strategy for the address
+	LabelStrategy = "fip.hcloud/strategy"
+	// LabelPinnedNode pins the address to the kubernetes node with the given name
+	LabelPinnedNode = "fip.hcloud/pinned-node"
+	// LabelFollowServiceNamespace is the namespace of the service whose endpoints the address follows
+	LabelFollowServiceNamespace = "fip.hcloud/follow-service-namespace"
+	// LabelFollowServiceName is the name of the service whose endpoints the address follows
+	LabelFollowServiceName = "fip.hcloud/follow-service-name"
+	// LabelNodeSelectorPrefix prefixes node labels the address is restricted to, i.e. the hcloud label
+	// "node-selector.fip.hcloud/role=edge" only allows nodes with the kubernetes label "role=edge". hcloud label
+	// keys only have a single prefix, so prefixed node labels like "topology.kubernetes.io/zone" can not be used.
+	LabelNodeSelectorPrefix = "node-selector.fip.hcloud/"
+)
+
+// AnnotationFloatingIPs on a service lists comma separated floating IPs which follow the ready endpoints of
+// the service
+const AnnotationFloatingIPs = "fip.hcloud/floating-ips"
+
+// addressPolicy is the placement policy of a single address, read from its hcloud labels
+type addressPolicy struct {
+	priority     int
+	strategy     AssignmentStrategy
+	pinnedNode   string
+	nodeSelector labels.Selector
+	// followService is the "<namespace>/<name>" of the service whose ready endpoints the address follows
+	followService string
+
+	// servers are the running servers the address may be assigned to according to this policy
+	servers []*hcloud.Server
+}
+
+// The policy of addresses without placement labels
+func (controller *Controller) defaultAddressPolicy() *addressPolicy {
+	return &addressPolicy{
+		strategy:     controller.assignmentStrategy(),
+		nodeSelector: labels.Everything(),
+	}
+}
+
+// Parse the placement policy from the labels of the given address
+func (controller *Controller) parseAddressPolicy(address *Address) (*addressPolicy, error) {
+	policy := controller.defaultAddressPolicy()
+
+	nodeLabels := labels.Set{}
+	var followNamespace, followName string
+	for key, value := range address.Labels {
+		switch {
+		case key == LabelPriority:
+			priority, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("label '%s' is not an integer: %s", key, value)
+			}
+			policy.priority = priority
+		case key == LabelStrategy:
+			config := *controller.Configuration
+			config.AssignmentStrategy = value
+			strategy, err := newAssignmentStrategy(&config)
+			if err != nil {
+				return nil, fmt.Errorf("label '%s' is invalid: %w", key, err)
+			}
+			policy.strategy = strategy
+		case key == LabelPinnedNode:
+			policy.pinnedNode = value
+		case key == LabelFollowServiceNamespace:
+			followNamespace = value
+		case key == LabelFollowServiceName:
+			followName = value
+		case strings.HasPrefix(key, LabelNodeSelectorPrefix):
+			nodeLabels[strings.TrimPrefix(key, LabelNodeSelectorPrefix)] = value
+		}
+	}
+	if followName != "" {
+		if followNamespace == "" {
+			followNamespace = controller.Configuration.Namespace
+		}
+		policy.followService = followNamespace + "/" + followName
+	}
+	if len(nodeLabels) > 0 {
+		selector, err := labels.ValidatedSelectorFromSet(nodeLabels)
+		if err != nil {
+			return nil, fmt.Errorf("node selector labels are invalid: %w", err)
+		}
+		policy.nodeSelector = selector
+	}
+	return policy, nil
+}
+
+// addressPolicies parses the policies of all addresses and resolves the running servers each of them may be
+// assigned to. Addresses with invalid policies get the default policy, so they are still reconciled, and are
+// reported with a warning. Addresses whose servers could not be resolved are skipped, their errors are returned
+// joined along with the other addresses. The returned addresses are sorted by descending priority.
+func (controller *Controller) addressPolicies(ctx context.Context, provider IPProvider, runningServers []*hcloud.Server, addresses []*Address) ([]*Address, map[int64]*addressPolicy, error) {
+	policies := make(map[int64]*addressPolicy, len(addresses))
+	// Servers matching a node selector or pinned node are looked up once per reconciliation
+	resolved := make(map[string][]*hcloud.Server)
+
+	var annotated map[string][]net.IP
+	if controller.Configuration.FollowServiceAnnotations {
+		var err error
+		if annotated, err = controller.annotatedServices(ctx); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var valid []*Address
+	var errs []error
+	for _, address := range addresses {
+		policy, err := controller.parseAddressPolicy(address)
+		if err != nil {
+			controller.Logger.WithField("kind", provider.Kind()).Warnf("Using the default policy for address '%s' with invalid policy: %v", address.IP.String(), err)
+			invalidPoliciesTotal.WithLabelValues(provider.Kind()).Inc()
+			policy = controller.defaultAddressPolicy()
+		}
+		// The labels of the address take precedence over service annotations, which take precedence over the
+		// configured service
+		if policy.followService == "" {
+			policy.followService = followedService(annotated, address)
+		}
+		if policy.followService == "" {
+			policy.followService = controller.Configuration.FollowService
+		}
+		controller.followedServices.add(policy.followService)
+
+		key := "selector:" + policy.nodeSelector.String()
+		if policy.pinnedNode != "" {
+			key = "pinned:" + policy.pinnedNode
+		}
+		key += ",service:" + policy.followService
+		servers, ok := resolved[key]
+		if !ok {
+			servers, err = controller.policyServers(ctx, runningServers, policy)
+			if err != nil {
+				errs = append(errs, controller.addressError(provider, address, err))
+				continue
+			}
+			resolved[key] = servers
+		}
+		policy.servers = servers
+
+		policies[address.ID] = policy
+		valid = append(valid, address)
+	}
+
+	sort.SliceStable(valid, func(i, j int) bool {
+		return policies[valid[i].ID].priority > policies[valid[j].ID].priority
+	})
+	return valid, policies, errors.Join(errs...)
+}
+
+// Resolve the running servers backing the kubernetes nodes allowed by the given policy
+func (controller *Controller) policyServers(ctx context.Context, runningServers []*hcloud.Server, policy *addressPolicy) (_ []*hcloud.Server, err error) {
+	if policy.followService != "" {
+		namespace, name, _ := strings.Cut(policy.followService, "/")
+		nodeNames, err := controller.serviceEndpointNodeNames(ctx, namespace, name)
+		if err != nil {
+			return nil, err
+		}
+		runningServers, err = controller.serversForNodeNames(ctx, nodeNames, runningServers)
+		if err != nil {
+			return nil, err
+		}
+		controller.Logger.Debugf("Found %d running servers with ready endpoints of service '%s'", len(runningServers), policy.followService)
+	}
+
+	var nodes []corev1.Node
+	if policy.pinnedNode != "" {
+		node, err := controller.getNode(ctx, policy.pinnedNode)
+		if apierrors.IsNotFound(err) {
+			controller.Logger.Warnf("Pinned node '%s' does not exist", policy.pinnedNode)
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not get pinned node '%s': %w", policy.pinnedNode, err)
+		}
+		nodes = []corev1.Node{*node}
+	} else if policy.nodeSelector.Empty() {
+		return runningServers, nil
+	} else {
+		nodeList, err := controller.listNodes(ctx, policy.nodeSelector.String())
+		if err != nil {
+			return nil, fmt.Errorf("could not list nodes for selector '%s': %w", policy.nodeSelector.String(), err)
+		}
+		nodes = nodeList.Items
+	}
+
+	return controller.serversForNodes(nodes, runningServers), nil
+}
+
+// List the floating IPs of all services with the floating IPs annotation, keyed by "<namespace>/<name>"
+func (controller *Controller) annotatedServices(ctx context.Context) (map[string][]net.IP, error) {
+	services, err := controller.listServices(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not list services: %w", err)
+	}
+
+	annotated := make(map[string][]net.IP)
+	for _, service := range services.Items {
+		value, ok := service.Annotations[AnnotationFloatingIPs]
+		if !ok {
+			continue
+		}
+		key := service.Namespace + "/" + service.Name
+		for _, address := range splitListFlags([]string{value}) {
+			ip := net.ParseIP(address)
+			if ip == nil {
+				controller.Logger.Warnf("Ignoring invalid floating IP '%s' in annotation of service '%s'", address, key)
+				continue
+			}
+			annotated[key] = append(annotated[key], ip)
+		}
+	}
+	return annotated, nil
+}
+
+// Return the "<namespace>/<name>" of the annotated service the address follows, or an empty string.
+// If multiple services claim the same address, the first one in alphabetical order wins.
+func followedService(annotated map[string][]net.IP, address *Address) string {
+	keys := make([]string, 0, len(annotated))
+	for key := range annotated {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		for _, ip := range annotated[key] {
+			if addressMatches(address, ip) {
+				return key
+			}
+		}
+	}
+	return ""
+}
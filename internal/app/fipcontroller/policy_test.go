@@ -0,0 +1,215 @@
+package fipcontroller
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/cbeneke/hcloud-fip-controller/internal/pkg/configuration"
+)
+
+func TestParseAddressPolicy(t *testing.T) {
+	tests := []struct {
+		name         string
+		labels       map[string]string
+		priority     int
+		pinnedNode   string
+		nodeSelector string
+		err          bool
+	}{
+		{
+			name:         "test no labels",
+			nodeSelector: "",
+		},
+		{
+			name: "test all labels",
+			labels: map[string]string{
+				LabelPriority:                    "10",
+				LabelStrategy:                    configuration.AssignmentStrategyRandom,
+				LabelPinnedNode:                  "node-1",
+				LabelNodeSelectorPrefix + "role": "edge",
+				"unrelated":                      "label",
+			},
+			priority:     10,
+			pinnedNode:   "node-1",
+			nodeSelector: "role=edge",
+		},
+		{
+			name:   "test invalid priority",
+			labels: map[string]string{LabelPriority: "high"},
+			err:    true,
+		},
+		{
+			name:   "test invalid strategy",
+			labels: map[string]string{LabelStrategy: "foo"},
+			err:    true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			controller := Controller{
+				Configuration: &configuration.Configuration{},
+				Logger:        logrus.New(),
+			}
+
+			policy, err := controller.parseAddressPolicy(&Address{Labels: test.labels})
+
+			if (err != nil) != test.err {
+				t.Fatalf("Err should exist? (%t) but was [%v]", test.err, err)
+			}
+			if err != nil {
+				return
+			}
+			if policy.priority != test.priority {
+				t.Fatalf("priority should be %d but was %d", test.priority, policy.priority)
+			}
+			if policy.pinnedNode != test.pinnedNode {
+				t.Fatalf("pinned node should be [%s] but was [%s]", test.pinnedNode, policy.pinnedNode)
+			}
+			if policy.nodeSelector.String() != test.nodeSelector {
+				t.Fatalf("node selector should be [%s] but was [%s]", test.nodeSelector, policy.nodeSelector.String())
+			}
+		})
+	}
+}
+
+func TestAddressPolicies(t *testing.T) {
+	edgeNode := createTestNode("node-1", []v1.NodeAddress{{Type: v1.NodeExternalIP, Address: "1.1.1.1"}}, v1.ConditionTrue)
+	edgeNode.Labels = map[string]string{"role": "edge"}
+	workerNode := createTestNode("node-2", []v1.NodeAddress{{Type: v1.NodeExternalIP, Address: "2.2.2.2"}}, v1.ConditionTrue)
+
+	servers := []*hcloud.Server{
+		{ID: 1, PublicNet: hcloud.ServerPublicNet{IPv4: hcloud.ServerPublicNetIPv4{IP: net.ParseIP("1.1.1.1")}}},
+		{ID: 2, PublicNet: hcloud.ServerPublicNet{IPv4: hcloud.ServerPublicNetIPv4{IP: net.ParseIP("2.2.2.2")}}},
+	}
+
+	addresses := []*Address{
+		{ID: 1, IP: net.ParseIP("10.0.0.1")},
+		{ID: 2, IP: net.ParseIP("10.0.0.2"), Labels: map[string]string{LabelNodeSelectorPrefix + "role": "edge", LabelPriority: "5"}},
+		{ID: 3, IP: net.ParseIP("10.0.0.3"), Labels: map[string]string{LabelPinnedNode: "node-2", LabelPriority: "1"}},
+		{ID: 4, IP: net.ParseIP("10.0.0.4"), Labels: map[string]string{LabelPinnedNode: "node-3"}},
+		{ID: 5, IP: net.ParseIP("10.0.0.5"), Labels: map[string]string{LabelPriority: "invalid"}},
+	}
+
+	controller := Controller{
+		KubernetesClient: fake.NewSimpleClientset([]runtime.Object{edgeNode, workerNode}...),
+		Backoff: wait.Backoff{
+			Steps: 1,
+		},
+		Configuration: &configuration.Configuration{},
+		Logger:        logrus.New(),
+	}
+
+	sorted, policies, err := controller.addressPolicies(context.Background(), newFakeProvider(nil, nil), servers, addresses)
+	if err != nil {
+		t.Fatalf("error should be [nil] but was [%v]", err)
+	}
+
+	expectedOrder := []int64{2, 3, 1, 4, 5}
+	if len(sorted) != len(expectedOrder) {
+		t.Fatalf("floating ips should be length %d but was length %d", len(expectedOrder), len(sorted))
+	}
+	for i, floatingIP := range sorted {
+		if floatingIP.ID != expectedOrder[i] {
+			t.Fatalf("floating ip at position %d should be [%d] but was [%d]", i, expectedOrder[i], floatingIP.ID)
+		}
+	}
+
+	expectedServers := map[int64][]int64{
+		1: {1, 2},
+		2: {1},
+		3: {2},
+		4: nil,
+		5: {1, 2},
+	}
+	for id, serverIDs := range expectedServers {
+		policyServers := policies[id].servers
+		if len(policyServers) != len(serverIDs) {
+			t.Fatalf("floating ip [%d] should have %d servers but had %d", id, len(serverIDs), len(policyServers))
+		}
+		for i, server := range policyServers {
+			if server.ID != serverIDs[i] {
+				t.Fatalf("server of floating ip [%d] should be [%d] but was [%d]", id, serverIDs[i], server.ID)
+			}
+		}
+	}
+}
+
+func TestAddressPoliciesFollowService(t *testing.T) {
+	servers := []*hcloud.Server{
+		{ID: 1, PublicNet: hcloud.ServerPublicNet{IPv4: hcloud.ServerPublicNetIPv4{IP: net.ParseIP("1.1.1.1")}}},
+		{ID: 2, PublicNet: hcloud.ServerPublicNet{IPv4: hcloud.ServerPublicNetIPv4{IP: net.ParseIP("2.2.2.2")}}},
+	}
+
+	objects := []runtime.Object{
+		createTestNode("node-1", []v1.NodeAddress{{Type: v1.NodeExternalIP, Address: "1.1.1.1"}}, v1.ConditionTrue),
+		createTestNode("node-2", []v1.NodeAddress{{Type: v1.NodeExternalIP, Address: "2.2.2.2"}}, v1.ConditionTrue),
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "ingress",
+				Namespace:   "ingress",
+				Annotations: map[string]string{AnnotationFloatingIPs: "10.0.0.2, 2001:db8::1"},
+			},
+		},
+		createTestEndpointSlice("ingress", "ingress", "node-2"),
+		createTestEndpointSlice("database", "postgres", "node-1"),
+	}
+
+	_, ipv6Network, _ := net.ParseCIDR("2001:db8::/64")
+	addresses := []*Address{
+		{ID: 1, IP: net.ParseIP("10.0.0.1"), Labels: map[string]string{
+			LabelFollowServiceNamespace: "database",
+			LabelFollowServiceName:      "postgres",
+		}},
+		{ID: 2, IP: net.ParseIP("10.0.0.2")},
+		{ID: 3, IP: ipv6Network.IP, Network: ipv6Network},
+		{ID: 4, IP: net.ParseIP("10.0.0.4")},
+	}
+
+	controller := Controller{
+		KubernetesClient: fake.NewSimpleClientset(objects...),
+		Backoff: wait.Backoff{
+			Steps: 1,
+		},
+		Configuration: &configuration.Configuration{FollowServiceAnnotations: true},
+		Logger:        logrus.New(),
+	}
+
+	_, policies, err := controller.addressPolicies(context.Background(), newFakeProvider(nil, nil), servers, addresses)
+	if err != nil {
+		t.Fatalf("error should be [nil] but was [%v]", err)
+	}
+
+	expected := map[int64]struct {
+		service string
+		servers []int64
+	}{
+		1: {service: "database/postgres", servers: []int64{1}},
+		2: {service: "ingress/ingress", servers: []int64{2}},
+		3: {service: "ingress/ingress", servers: []int64{2}},
+		4: {service: "", servers: []int64{1, 2}},
+	}
+	for id, result := range expected {
+		policy := policies[id]
+		if policy.followService != result.service {
+			t.Fatalf("floating ip [%d] should follow [%s] but followed [%s]", id, result.service, policy.followService)
+		}
+		if len(policy.servers) != len(result.servers) {
+			t.Fatalf("floating ip [%d] should have %d servers but had %d", id, len(result.servers), len(policy.servers))
+		}
+		for i, server := range policy.servers {
+			if server.ID != result.servers[i] {
+				t.Fatalf("server of floating ip [%d] should be [%d] but was [%d]", id, result.servers[i], server.ID)
+			}
+		}
+	}
+}
@@ -0,0 +1,397 @@
+package fipcontroller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/cbeneke/hcloud-fip-controller/internal/pkg/configuration"
+)
+
+// poolResource is the FloatingIPPool custom resource, see deploy/crds/floatingippools.yaml
+var poolResource = schema.GroupVersionResource{Group: "fip.hcloud", Version: "v1alpha1", Resource: "floatingippools"}
+
+// Condition type and reasons reported in the status of FloatingIPPools
+const (
+	poolConditionReady        = "Ready"
+	poolReasonReconciled      = "Reconciled"
+	poolReasonInvalidSpec     = "InvalidSpec"
+	poolReasonReconcileFailed = "ReconcileFailed"
+)
+
+// FloatingIPPool declares a set of floating IPs and how they are placed on the nodes of the cluster.
+// Options not set in the pool are taken from the controller configuration.
+type FloatingIPPool struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   FloatingIPPoolSpec   `json:"spec"`
+	Status FloatingIPPoolStatus `json:"status,omitempty"`
+}
+
+// FloatingIPPoolSpec is the desired state of a FloatingIPPool
+type FloatingIPPoolSpec struct {
+	// FloatingIPSelector is the hcloud label selector of the floating IPs in the pool
+	FloatingIPSelector string `json:"floatingIPSelector,omitempty"`
+	// FloatingIPs are the floating IPs in the pool, as an alternative to the selector
+	FloatingIPs []string `json:"floatingIPs,omitempty"`
+	// NodeSelector is the label selector of the nodes the floating IPs may be assigned to
+	NodeSelector string `json:"nodeSelector,omitempty"`
+	// PodSelector is the label selector of pods in the namespace of the pool. The floating IPs are only assigned
+	// to nodes running one of these pods
+	PodSelector string `json:"podSelector,omitempty"`
+	// Service is the name of a service in the namespace of the pool. The floating IPs follow its ready endpoints
+	Service string `json:"service,omitempty"`
+	// Strategy is the assignment strategy, one of least-loaded, random, weighted, ordered
+	Strategy          string   `json:"strategy,omitempty"`
+	ServerWeights     []string `json:"serverWeights,omitempty"`
+	ServerPreferences []string `json:"serverPreferences,omitempty"`
+	// Limits protect the floating IPs of the pool from unnecessary moves
+	Limits FloatingIPPoolLimits `json:"limits,omitempty"`
+}
+
+// FloatingIPPoolLimits are the safety limits of a FloatingIPPool, see the configuration options of the same name
+type FloatingIPPoolLimits struct {
+	UnhealthyThreshold  int    `json:"unhealthyThreshold,omitempty"`
+	UnhealthyDuration   string `json:"unhealthyDuration,omitempty"`
+	TakeoverGracePeriod string `json:"takeoverGracePeriod,omitempty"`
+}
+
+// FloatingIPPoolStatus is the observed state of a FloatingIPPool
+type FloatingIPPoolStatus struct {
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// FloatingIPs is the number of floating IPs managed by the pool
+	FloatingIPs int                `json:"floatingIPs,omitempty"`
+	Conditions  []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// poolController reconciles the floating IPs of a single FloatingIPPool. It is kept between reconciliations,
+// so the unhealthy server tracking of the pool works like the one of the controller.
+type poolController struct {
+	// generation is the generation of the pool the controller was created for
+	generation int64
+	controller *Controller
+}
+
+// reconcilePools reconciles the floating IPs of all FloatingIPPools and reports the result in their status.
+// Pools are reconciled in the order of their namespace and name, a floating IP selected by multiple pools is
+// only managed by the first one. A failing pool does not stop the reconciliation of the others.
+func (controller *Controller) reconcilePools(ctx context.Context) error {
+	pools, err := controller.listPools(ctx)
+	if err != nil {
+		return fmt.Errorf("could not list floating IP pools: %w", err)
+	}
+
+	var errs []error
+	current := make(map[string]*poolController, len(pools))
+	claimed := make(map[int64]string)
+	managed := 0
+	for _, pool := range pools {
+		key := pool.Namespace + "/" + pool.Name
+		poolController, err := controller.poolController(key, pool)
+		if err != nil {
+			controller.Logger.Warnf("Ignoring floating IP pool '%s' with invalid spec: %v", key, err)
+			errs = append(errs, controller.updatePoolStatus(ctx, pool, metav1.ConditionFalse, poolReasonInvalidSpec, err.Error(), 0))
+			continue
+		}
+		current[key] = poolController
+
+		floatingIPs, err := poolController.reconcile(ctx, key, claimed)
+		managed += floatingIPs
+		for _, observed := range poolController.controller.observedAddresses {
+			observed.pool = key
+			controller.observedAddresses = append(controller.observedAddresses, observed)
+		}
+		if err != nil {
+			controller.Logger.Errorf("Could not reconcile floating IP pool '%s': %v", key, err)
+			errs = append(errs, fmt.Errorf("could not reconcile floating IP pool '%s': %w", key, err))
+			errs = append(errs, controller.updatePoolStatus(ctx, pool, metav1.ConditionFalse, poolReasonReconcileFailed, err.Error(), floatingIPs))
+			continue
+		}
+		message := fmt.Sprintf("Managing %d floating IPs", floatingIPs)
+		errs = append(errs, controller.updatePoolStatus(ctx, pool, metav1.ConditionTrue, poolReasonReconciled, message, floatingIPs))
+	}
+	controller.pools = current
+
+	managedFloatingIPs.WithLabelValues(kindFloatingIP).Set(float64(managed))
+	return errors.Join(errs...)
+}
+
+// reconcile the floating IPs of the pool which are not claimed by another pool yet. Returns the number of
+// floating IPs managed by the pool.
+func (pool *poolController) reconcile(ctx context.Context, key string, claimed map[int64]string) (int, error) {
+	pool.controller.observedAddresses = nil
+	runningServers, addresses, err := pool.controller.discover(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	owned, errs := claimAddresses(key, addresses[0], claimed)
+	addresses[0] = owned
+
+	errs = append(errs, pool.controller.reconcile(ctx, runningServers, addresses))
+	return len(owned), errors.Join(errs...)
+}
+
+// claimAddresses claims the floating IPs for the pool and returns those which were not claimed by another pool
+// yet. Floating IPs claimed by another pool are returned as errors.
+func claimAddresses(key string, addresses []*Address, claimed map[int64]string) ([]*Address, []error) {
+	var errs []error
+	var owned []*Address
+	for _, address := range addresses {
+		if owner, ok := claimed[address.ID]; ok {
+			errs = append(errs, fmt.Errorf("floating IP '%s' is already managed by pool '%s'", address.IP.String(), owner))
+			continue
+		}
+		claimed[address.ID] = key
+		owned = append(owned, address)
+	}
+	return owned, errs
+}
+
+// poolProviders returns the floating IP providers of all FloatingIPPools with a valid spec, in the order in
+// which the pools claim their floating IPs
+func (controller *Controller) poolProviders(ctx context.Context) ([]IPProvider, error) {
+	pools, err := controller.listPools(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not list floating IP pools: %w", err)
+	}
+
+	var providers []IPProvider
+	for _, pool := range pools {
+		config, err := controller.poolConfiguration(pool)
+		if err != nil {
+			controller.Logger.Warnf("Ignoring floating IP pool '%s/%s' with invalid spec: %v", pool.Namespace, pool.Name, err)
+			continue
+		}
+		providers = append(providers, controller.poolProvider(config))
+	}
+	return providers, nil
+}
+
+// poolController returns the controller of the pool. It is created again when the spec of the pool changed.
+func (controller *Controller) poolController(key string, pool *FloatingIPPool) (*poolController, error) {
+	existing, ok := controller.pools[key]
+	if ok && existing.generation == pool.Generation {
+		return existing, nil
+	}
+
+	config, err := controller.poolConfiguration(pool)
+	if err != nil {
+		return nil, err
+	}
+	strategy, err := newAssignmentStrategy(config)
+	if err != nil {
+		return nil, err
+	}
+
+	poolController := &poolController{
+		generation: pool.Generation,
+		controller: &Controller{
+			Providers:        []IPProvider{controller.poolProvider(config)},
+			KubernetesClient: controller.KubernetesClient,
+			Configuration:    config,
+			Logger:           controller.Logger,
+			Backoff:          controller.Backoff,
+			Strategy:         strategy,
+			Recorder:         controller.Recorder,
+			leadingSince:     controller.leadingSince,
+			nodeLister:       controller.nodeLister,
+
+			serviceLister:       controller.serviceLister,
+			endpointSliceLister: controller.endpointSliceLister,
+			followedServices:    controller.followedServices,
+		},
+	}
+	// The pod informer only watches the namespace of the controller
+	if config.Namespace == controller.Configuration.Namespace {
+		poolController.controller.podLister = controller.podLister
+	}
+	// Keep the observations of the previous spec, so changing a pool does not delay failovers
+	if ok {
+		poolController.controller.unhealthyServers = existing.controller.unhealthyServers
+		poolController.controller.lastRebalance = existing.controller.lastRebalance
+	}
+	return poolController, nil
+}
+
+// poolConfiguration returns the controller configuration with the options set in the pool. Returns all errors
+// found in the spec of the pool joined.
+func (controller *Controller) poolConfiguration(pool *FloatingIPPool) (*configuration.Configuration, error) {
+	spec := pool.Spec
+	var errs []string
+
+	// Pools only manage their own floating IPs
+	config := *controller.Configuration
+	config.FloatingIPPools = false
+	config.ServiceIPAM = false
+	config.PrimaryIPLabelSelector = ""
+	config.AliasIPs = nil
+	config.HcloudFloatingIPs = spec.FloatingIPs
+	config.FloatingIPLabelSelector = spec.FloatingIPSelector
+
+	// Without a selector the pool would manage all floating IPs of the project
+	if spec.FloatingIPSelector == "" && len(spec.FloatingIPs) < 1 {
+		errs = append(errs, "floatingIPSelector or floatingIPs need to be set")
+	}
+	if spec.FloatingIPSelector != "" && len(spec.FloatingIPs) > 0 {
+		errs = append(errs, "floatingIPSelector and floatingIPs must not be set both")
+	}
+	for _, floatingIP := range spec.FloatingIPs {
+		if net.ParseIP(floatingIP) == nil {
+			errs = append(errs, fmt.Sprintf("floating IP '%s' is not a valid IP address", floatingIP))
+		}
+	}
+
+	if spec.NodeSelector != "" {
+		if _, err := labels.Parse(spec.NodeSelector); err != nil {
+			errs = append(errs, fmt.Sprintf("node selector is invalid: %v", err))
+		}
+		config.NodeLabelSelector = spec.NodeSelector
+	}
+	if spec.PodSelector != "" {
+		if _, err := labels.Parse(spec.PodSelector); err != nil {
+			errs = append(errs, fmt.Sprintf("pod selector is invalid: %v", err))
+		}
+		config.PodLabelSelector = spec.PodSelector
+		config.Namespace = pool.Namespace
+	}
+	if spec.Service != "" {
+		config.FollowService = pool.Namespace + "/" + spec.Service
+	}
+
+	if spec.Strategy != "" {
+		config.AssignmentStrategy = spec.Strategy
+		config.ServerWeights = spec.ServerWeights
+		config.ServerPreferences = spec.ServerPreferences
+		if _, err := newAssignmentStrategy(&config); err != nil {
+			errs = append(errs, fmt.Sprintf("strategy is invalid: %v", err))
+		}
+	}
+
+	limits := spec.Limits
+	if limits.UnhealthyThreshold < 0 {
+		errs = append(errs, "unhealthy threshold must not be negative")
+	} else if limits.UnhealthyThreshold > 0 {
+		config.UnhealthyThreshold = limits.UnhealthyThreshold
+	}
+	if err := parsePoolDuration(limits.UnhealthyDuration, &config.UnhealthyDuration); err != nil {
+		errs = append(errs, fmt.Sprintf("unhealthy duration is invalid: %v", err))
+	}
+	if err := parsePoolDuration(limits.TakeoverGracePeriod, &config.TakeoverGracePeriod); err != nil {
+		errs = append(errs, fmt.Sprintf("takeover grace period is invalid: %v", err))
+	}
+
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("%s", strings.Join(errs, ", "))
+	}
+	return &config, nil
+}
+
+// Parse the duration into target, if it is set
+func parsePoolDuration(value string, target *time.Duration) error {
+	if value == "" {
+		return nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	if duration < 0 {
+		return fmt.Errorf("'%s' must not be negative", value)
+	}
+	*target = duration
+	return nil
+}
+
+// List all FloatingIPPools sorted by namespace and name, from the informer cache if it is running
+func (controller *Controller) listPools(ctx context.Context) ([]*FloatingIPPool, error) {
+	var objects []runtime.Object
+	if controller.poolLister != nil {
+		var err error
+		if objects, err = controller.poolLister.List(labels.Everything()); err != nil {
+			return nil, err
+		}
+	} else {
+		var list *unstructured.UnstructuredList
+		var err error
+		err = controller.retry(operationListPools, func() error {
+			list, err = controller.DynamicClient.Resource(poolResource).Namespace(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		for i := range list.Items {
+			objects = append(objects, &list.Items[i])
+		}
+	}
+
+	var pools []*FloatingIPPool
+	for _, object := range objects {
+		content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(object)
+		if err != nil {
+			return nil, err
+		}
+		pool := &FloatingIPPool{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(content, pool); err != nil {
+			controller.Logger.Warnf("Ignoring floating IP pool which could not be decoded: %v", err)
+			continue
+		}
+		pools = append(pools, pool)
+	}
+
+	sort.Slice(pools, func(i, j int) bool {
+		if pools[i].Namespace != pools[j].Namespace {
+			return pools[i].Namespace < pools[j].Namespace
+		}
+		return pools[i].Name < pools[j].Name
+	})
+	return pools, nil
+}
+
+// updatePoolStatus sets the ready condition and the number of managed floating IPs in the status of the pool.
+// The status is only written if it changed, and never in dry run mode.
+func (controller *Controller) updatePoolStatus(ctx context.Context, pool *FloatingIPPool, status metav1.ConditionStatus, reason, message string, floatingIPs int) error {
+	if controller.Configuration.DryRun {
+		controller.Logger.Debugf("Dry run: not updating status of floating IP pool '%s/%s'", pool.Namespace, pool.Name)
+		return nil
+	}
+
+	changed := meta.SetStatusCondition(&pool.Status.Conditions, metav1.Condition{
+		Type:               poolConditionReady,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: pool.Generation,
+	})
+	if !changed && pool.Status.ObservedGeneration == pool.Generation && pool.Status.FloatingIPs == floatingIPs {
+		return nil
+	}
+	pool.Status.ObservedGeneration = pool.Generation
+	pool.Status.FloatingIPs = floatingIPs
+
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(pool)
+	if err != nil {
+		return fmt.Errorf("could not encode status of floating IP pool '%s/%s': %w", pool.Namespace, pool.Name, err)
+	}
+	err = controller.retry(operationUpdatePoolStatus, func() error {
+		_, err := controller.DynamicClient.Resource(poolResource).Namespace(pool.Namespace).UpdateStatus(ctx, &unstructured.Unstructured{Object: content}, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("could not update status of floating IP pool '%s/%s': %w", pool.Namespace, pool.Name, err)
+	}
+	return nil
+}
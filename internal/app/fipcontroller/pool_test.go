@@ -0,0 +1,221 @@
+package fipcontroller
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/cbeneke/hcloud-fip-controller/internal/pkg/configuration"
+)
+
+func createTestPool(namespace, name string, spec map[string]interface{}) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": poolResource.GroupVersion().String(),
+		"kind":       "FloatingIPPool",
+		"metadata": map[string]interface{}{
+			"namespace":  namespace,
+			"name":       name,
+			"generation": int64(1),
+		},
+		"spec": spec,
+	}}
+}
+
+func TestPoolConfiguration(t *testing.T) {
+	tests := []struct {
+		name   string
+		spec   FloatingIPPoolSpec
+		err    bool
+		verify func(config *configuration.Configuration) bool
+	}{
+		{
+			name: "selector and inherited options",
+			spec: FloatingIPPoolSpec{FloatingIPSelector: "pool=edge"},
+			verify: func(config *configuration.Configuration) bool {
+				return config.FloatingIPLabelSelector == "pool=edge" && config.NodeLabelSelector == "role=worker" &&
+					config.Namespace == "fip" && config.UnhealthyThreshold == 2 && config.PrimaryIPLabelSelector == ""
+			},
+		},
+		{
+			name: "pool options",
+			spec: FloatingIPPoolSpec{
+				FloatingIPs:  []string{"10.0.0.1"},
+				NodeSelector: "role=edge",
+				PodSelector:  "app=ingress",
+				Service:      "ingress",
+				Strategy:     configuration.AssignmentStrategyOrdered,
+				Limits:       FloatingIPPoolLimits{UnhealthyThreshold: 3, TakeoverGracePeriod: "1m"},
+			},
+			verify: func(config *configuration.Configuration) bool {
+				return config.NodeLabelSelector == "role=edge" && config.PodLabelSelector == "app=ingress" &&
+					config.Namespace == "ingress" && config.FollowService == "ingress/ingress" &&
+					config.AssignmentStrategy == configuration.AssignmentStrategyOrdered &&
+					config.UnhealthyThreshold == 3 && config.TakeoverGracePeriod == time.Minute
+			},
+		},
+		{
+			name: "no floating IPs",
+			spec: FloatingIPPoolSpec{},
+			err:  true,
+		},
+		{
+			name: "selector and floating IPs",
+			spec: FloatingIPPoolSpec{FloatingIPSelector: "pool=edge", FloatingIPs: []string{"10.0.0.1"}},
+			err:  true,
+		},
+		{
+			name: "invalid floating IP",
+			spec: FloatingIPPoolSpec{FloatingIPs: []string{"10.0.0"}},
+			err:  true,
+		},
+		{
+			name: "invalid node selector",
+			spec: FloatingIPPoolSpec{FloatingIPSelector: "pool=edge", NodeSelector: "role in edge"},
+			err:  true,
+		},
+		{
+			name: "invalid strategy",
+			spec: FloatingIPPoolSpec{FloatingIPSelector: "pool=edge", Strategy: "fastest"},
+			err:  true,
+		},
+		{
+			name: "invalid duration",
+			spec: FloatingIPPoolSpec{FloatingIPSelector: "pool=edge", Limits: FloatingIPPoolLimits{UnhealthyDuration: "soon"}},
+			err:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			controller := Controller{
+				Configuration: &configuration.Configuration{
+					Namespace:              "fip",
+					NodeLabelSelector:      "role=worker",
+					UnhealthyThreshold:     2,
+					PrimaryIPLabelSelector: "kind=primary",
+					FloatingIPPools:        true,
+				},
+				Logger: logrus.New(),
+			}
+			pool := &FloatingIPPool{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "ingress", Name: "edge"},
+				Spec:       test.spec,
+			}
+
+			config, err := controller.poolConfiguration(pool)
+			if (err != nil) != test.err {
+				t.Fatalf("error should be %t but was [%v]", test.err, err)
+			}
+			if test.verify != nil && !test.verify(config) {
+				t.Fatalf("configuration does not match the pool: %+v", config)
+			}
+		})
+	}
+}
+
+func TestReconcilePools(t *testing.T) {
+	servers := []*hcloud.Server{
+		{ID: 1, Name: "server-1", PublicNet: hcloud.ServerPublicNet{IPv4: hcloud.ServerPublicNetIPv4{IP: net.ParseIP("1.1.1.1")}}},
+	}
+	addresses := []*Address{
+		{ID: 1, IP: net.ParseIP("10.0.0.1")},
+		{ID: 2, IP: net.ParseIP("10.0.0.2")},
+	}
+
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{poolResource: "FloatingIPPoolList"},
+		createTestPool("fip", "edge", map[string]interface{}{"floatingIPs": []interface{}{"10.0.0.1", "10.0.0.2"}}),
+		createTestPool("fip", "invalid", map[string]interface{}{}),
+		createTestPool("web", "overlapping", map[string]interface{}{"floatingIPs": []interface{}{"10.0.0.2"}}),
+	)
+
+	providers := make(map[string]*fakeProvider)
+	controller := Controller{
+		KubernetesClient: fake.NewSimpleClientset(
+			createTestNode("node-1", []v1.NodeAddress{{Type: v1.NodeExternalIP, Address: "1.1.1.1"}}, v1.ConditionTrue),
+		),
+		DynamicClient: dynamicClient,
+		Backoff: wait.Backoff{
+			Steps: 1,
+		},
+		Configuration: &configuration.Configuration{
+			Namespace:       "fip",
+			NodeAddressType: configuration.NodeAddressTypeExternal,
+			FloatingIPPools: true,
+		},
+		Logger: logrus.New(),
+		poolProvider: func(config *configuration.Configuration) IPProvider {
+			var poolAddresses []*Address
+			for _, address := range addresses {
+				for _, floatingIP := range config.HcloudFloatingIPs {
+					if address.IP.Equal(net.ParseIP(floatingIP)) {
+						poolAddresses = append(poolAddresses, address)
+					}
+				}
+			}
+			provider := newFakeProvider(poolAddresses, servers)
+			providers[strings.Join(config.HcloudFloatingIPs, ",")] = provider
+			return provider
+		},
+	}
+
+	if err := controller.UpdateFloatingIPs(context.Background()); err == nil {
+		t.Fatal("error should be returned for the overlapping pool but was [nil]")
+	}
+
+	edge := providers["10.0.0.1,10.0.0.2"]
+	if edge == nil || edge.assigned[1] != 1 || edge.assigned[2] != 1 {
+		t.Fatalf("floating IPs of the first pool should be assigned to server 1")
+	}
+	overlapping := providers["10.0.0.2"]
+	if overlapping == nil || len(overlapping.assigned) > 0 {
+		t.Fatalf("floating IP of the overlapping pool should not be assigned again")
+	}
+
+	expected := map[string]struct {
+		status      string
+		reason      string
+		floatingIPs int64
+	}{
+		"fip/edge":        {status: "True", reason: poolReasonReconciled, floatingIPs: 2},
+		"fip/invalid":     {status: "False", reason: poolReasonInvalidSpec},
+		"web/overlapping": {status: "False", reason: poolReasonReconcileFailed},
+	}
+	for key, result := range expected {
+		pool := controller.pools[key]
+		if (pool == nil) != (result.reason == poolReasonInvalidSpec) {
+			t.Fatalf("pool '%s' should only have a controller if its spec is valid", key)
+		}
+
+		namespace, name, _ := strings.Cut(key, "/")
+		object, err := dynamicClient.Resource(poolResource).Namespace(namespace).Get(context.Background(), name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("error should be [nil] but was [%v]", err)
+		}
+		conditions, _, _ := unstructured.NestedSlice(object.Object, "status", "conditions")
+		if len(conditions) != 1 {
+			t.Fatalf("pool '%s' should have 1 condition but had %d", key, len(conditions))
+		}
+		condition := conditions[0].(map[string]interface{})
+		if condition["status"] != result.status || condition["reason"] != result.reason {
+			t.Fatalf("pool '%s' should be %s with reason %s but was %s with reason %s", key, result.status, result.reason, condition["status"], condition["reason"])
+		}
+		floatingIPs, _, _ := unstructured.NestedInt64(object.Object, "status", "floatingIPs")
+		if floatingIPs != result.floatingIPs {
+			t.Fatalf("pool '%s' should manage %d floating IPs but managed %d", key, result.floatingIPs, floatingIPs)
+		}
+	}
+}
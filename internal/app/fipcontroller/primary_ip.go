@@ -0,0 +1,325 @@
+package fipcontroller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+
+	"github.com/cbeneke/hcloud-fip-controller/internal/pkg/configuration"
+)
+
+// primaryIPProvider manages hcloud primary IPs matching a label selector.
+//
+// Hetzner only allows to (un)assign primary IPs while the involved servers are powered off. Since the
+// candidate servers are healthy kubernetes nodes, they are always running. Primary IPs are therefore only
+// moved if powering off servers is explicitly allowed, and only when they are unassigned or their server
+// failed. In that case the failed server is shut down (if it still runs), the primary IP is unassigned and the
+// failed server is powered on again. Then the target server is shut down, gets the primary IP assigned and is
+// powered on again. Otherwise the (re)assignment is blocked.
+type primaryIPProvider struct {
+	hcloudServers
+	labelSelector string
+	powerOff      bool
+	// shutdownTimeout is the time servers get to shut down gracefully
+	shutdownTimeout time.Duration
+	// shutdownPollInterval is the interval the status of a shutting down server is checked in
+	shutdownPollInterval time.Duration
+}
+
+// Defaults of the graceful shutdown of servers for primary IP moves
+const (
+	primaryIPShutdownTimeout      = 5 * time.Minute
+	primaryIPShutdownPollInterval = 2 * time.Second
+)
+
+func newPrimaryIPProvider(client *hcloud.Client, config *configuration.Configuration) *primaryIPProvider {
+	return &primaryIPProvider{
+		hcloudServers: newHcloudServers(client, config),
+		labelSelector: config.PrimaryIPLabelSelector,
+		powerOff:      config.PrimaryIPPowerOff,
+
+		shutdownTimeout:      primaryIPShutdownTimeout,
+		shutdownPollInterval: primaryIPShutdownPollInterval,
+	}
+}
+
+func (provider *primaryIPProvider) Kind() string {
+	return kindPrimaryIP
+}
+
+func (provider *primaryIPProvider) Addresses(ctx context.Context) ([]*Address, error) {
+	primaryIPs, err := provider.cache.primaryIPs(ctx, provider.client, provider.labelSelector)
+	if err != nil {
+		return nil, err
+	}
+
+	addresses := make([]*Address, 0, len(primaryIPs))
+	for _, primaryIP := range primaryIPs {
+		addresses = append(addresses, addressFromPrimaryIP(primaryIP))
+	}
+	return addresses, nil
+}
+
+// Convert the hcloud primary IP to an address
+func addressFromPrimaryIP(primaryIP *hcloud.PrimaryIP) *Address {
+	address := &Address{
+		ID:       primaryIP.ID,
+		IP:       primaryIP.IP,
+		Network:  primaryIP.Network,
+		Labels:   primaryIP.Labels,
+		Location: primaryIP.Location,
+	}
+	if primaryIP.AssigneeID != 0 {
+		address.Server = &hcloud.Server{ID: primaryIP.AssigneeID}
+	}
+	return address
+}
+
+// Placement returns the servers in the location of the primary IP which do not have a primary IP of the
+// same type assigned
+func (provider *primaryIPProvider) Placement(servers []*hcloud.Server, address *Address) (candidates []*hcloud.Server, _ string) {
+	ipv4 := address.IP.To4() != nil
+	for _, server := range servers {
+		if address.Location != nil && serverLocation(server) != address.Location.Name {
+			continue
+		}
+		if ipv4 && server.PublicNet.IPv4.ID != 0 || !ipv4 && server.PublicNet.IPv6.ID != 0 {
+			continue
+		}
+		candidates = append(candidates, server)
+	}
+	if len(candidates) < 1 {
+		return nil, placementNone
+	}
+	if address.Location == nil {
+		return candidates, placementAny
+	}
+	return candidates, placementHomeLocation
+}
+
+// primaryIPMoveBlocked returns a BlockedError for moves of primary IPs which require shutting down a server
+// which did not fail, e.g. evacuations and policy changes. Those servers would lose power without a drain,
+// so only unassigned primary IPs and primary IPs of failed servers are moved.
+func primaryIPMoveBlocked(address *Address, reason string) *BlockedError {
+	if reason == reasonUnassigned || reason == reasonFailover {
+		return nil
+	}
+	return &BlockedError{
+		Reason:  blockedServerRunning,
+		Message: fmt.Sprintf("primary IP '%s' is only moved off failed servers, not for %s, as its server would be shut down without a drain", address.IP.String(), reason),
+	}
+}
+
+// Assign moves the primary IP to the target server. The target server is shut down gracefully and powered on
+// again afterwards, also if the assignment fails.
+func (provider *primaryIPProvider) Assign(ctx context.Context, address *Address, server *hcloud.Server) (action *hcloud.Action, err error) {
+	defer provider.cache.invalidate()
+	if !provider.powerOff {
+		return nil, &BlockedError{
+			Reason:  blockedPowerOffRequired,
+			Message: fmt.Sprintf("primary IPs can only be moved between powered off servers, enable powering off servers to let the controller move '%s' to server '%s'", address.IP.String(), server.Name),
+		}
+	}
+
+	if address.Server != nil {
+		action, err := provider.Unassign(ctx, address)
+		if err != nil {
+			return nil, err
+		}
+		if err := provider.WaitForAction(ctx, action); err != nil {
+			return nil, err
+		}
+		address.Server = nil
+	}
+
+	// The status of the listed server is outdated if an earlier move of the primary IP failed halfway
+	target, err := provider.getServer(ctx, server.ID)
+	if err != nil {
+		return nil, err
+	}
+	if target == nil {
+		return nil, fmt.Errorf("server '%s' not found", server.Name)
+	}
+	// The target server is healthy, it is never powered off without a graceful shutdown
+	shutDown, err := provider.shutdownServer(ctx, target, false)
+	if shutDown || err == nil {
+		defer func() {
+			powerOnAction, powerOnErr := provider.powerOnServer(ctx, target)
+			action, err = powerOnAction, errors.Join(err, powerOnErr)
+		}()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = retryCall(ctx, operationAssign, func() error {
+		action, _, err = provider.client.PrimaryIP.Assign(ctx, hcloud.PrimaryIPAssignOpts{
+			ID:           address.ID,
+			AssigneeID:   target.ID,
+			AssigneeType: "server",
+		})
+		return err
+	})
+	if err == nil {
+		err = provider.WaitForAction(ctx, action)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not assign primary IP: %w", err)
+	}
+	return nil, nil
+}
+
+// Unassign shuts down the server holding the primary IP, unassigns it and powers the server on again if it was
+// running before. On failovers the server is expected to have failed, so it is powered off if it does not shut
+// down in time. Otherwise the server is healthy and the unassignment fails instead.
+func (provider *primaryIPProvider) Unassign(ctx context.Context, address *Address) (action *hcloud.Action, err error) {
+	defer provider.cache.invalidate()
+	if !provider.powerOff {
+		return nil, &BlockedError{
+			Reason:  blockedPowerOffRequired,
+			Message: fmt.Sprintf("primary IPs can only be unassigned from powered off servers, enable powering off servers to let the controller unassign '%s'", address.IP.String()),
+		}
+	}
+
+	holder, err := provider.getServer(ctx, address.Server.ID)
+	if err != nil {
+		return nil, err
+	}
+	// A deleted server releases its primary IPs, which is reflected by the next reconciliation
+	if holder != nil {
+		force := assignmentReason(ctx) == reasonFailover
+		var shutDown bool
+		shutDown, err = provider.shutdownServer(ctx, holder, force)
+		if shutDown {
+			// Powering the server on again gives a failed node the chance to recover without the primary IP
+			defer func() {
+				powerOnAction, powerOnErr := provider.powerOnServer(ctx, holder)
+				action, err = powerOnAction, errors.Join(err, powerOnErr)
+			}()
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = retryCall(ctx, operationUnassign, func() error {
+		action, _, err = provider.client.PrimaryIP.Unassign(ctx, address.ID)
+		return err
+	})
+	if err == nil {
+		err = provider.WaitForAction(ctx, action)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not unassign primary IP: %w", err)
+	}
+	return nil, nil
+}
+
+// multiStepAssignments marks the provider as multiStepProvider, as moving primary IPs shuts down servers
+func (provider *primaryIPProvider) multiStepAssignments() {}
+
+func (provider *primaryIPProvider) Refresh(ctx context.Context, address *Address) (*Address, error) {
+	primaryIP, _, err := provider.client.PrimaryIP.GetByID(ctx, address.ID)
+	if err != nil {
+		return nil, err
+	}
+	if primaryIP == nil {
+		return nil, fmt.Errorf("primary IP %d not found", address.ID)
+	}
+	return addressFromPrimaryIP(primaryIP), nil
+}
+
+func (provider *primaryIPProvider) UpdateLabels(ctx context.Context, address *Address, labels map[string]string) error {
+	defer provider.cache.invalidate()
+	_, _, err := provider.client.PrimaryIP.Update(ctx, &hcloud.PrimaryIP{ID: address.ID}, hcloud.PrimaryIPUpdateOpts{Labels: &labels})
+	return err
+}
+
+// shutdownServer shuts the server down gracefully and waits until it is off. If it does not shut down within
+// the shutdown timeout, it is powered off if force is set and an error is returned otherwise. Returns whether
+// the server was told to shut down, which is also the case if it did not turn off in time.
+func (provider *primaryIPProvider) shutdownServer(ctx context.Context, server *hcloud.Server, force bool) (bool, error) {
+	if server.Status == hcloud.ServerStatusOff {
+		return false, nil
+	}
+	var action *hcloud.Action
+	err := retryCall(ctx, operationShutdownServer, func() (err error) {
+		action, _, err = provider.client.Server.Shutdown(ctx, server)
+		return err
+	})
+	if err != nil {
+		return false, fmt.Errorf("could not shut down server '%s': %w", server.Name, err)
+	}
+	if err := provider.WaitForAction(ctx, action); err != nil {
+		return true, fmt.Errorf("could not shut down server '%s': %w", server.Name, err)
+	}
+
+	if err := provider.waitForServerOff(ctx, server); err != nil {
+		if !force || ctx.Err() != nil {
+			return true, fmt.Errorf("could not shut down server '%s': %w", server.Name, err)
+		}
+		err = retryCall(ctx, operationPowerOffServer, func() (err error) {
+			action, _, err = provider.client.Server.Poweroff(ctx, server)
+			return err
+		})
+		if err == nil {
+			err = provider.WaitForAction(ctx, action)
+		}
+		if err != nil {
+			return true, fmt.Errorf("could not power off server '%s' after it did not shut down: %w", server.Name, err)
+		}
+	}
+	return true, nil
+}
+
+// powerOnServer powers the server on. This is also done if the context was canceled in the meantime, as the
+// server would stay powered off otherwise.
+func (provider *primaryIPProvider) powerOnServer(ctx context.Context, server *hcloud.Server) (action *hcloud.Action, err error) {
+	ctx = context.WithoutCancel(ctx)
+	err = retryCall(ctx, operationPowerOnServer, func() error {
+		action, _, err = provider.client.Server.Poweron(ctx, server)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not power on server '%s': %w", server.Name, err)
+	}
+	return action, nil
+}
+
+// getServer reads the current state of the server, nil is returned if it does not exist
+func (provider *primaryIPProvider) getServer(ctx context.Context, id int64) (server *hcloud.Server, err error) {
+	err = retryCall(ctx, operationGetServer, func() error {
+		server, _, err = provider.client.Server.GetByID(ctx, id)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not get server %d: %w", id, err)
+	}
+	return server, nil
+}
+
+// waitForServerOff polls the status of the server until it is off or the shutdown timeout passed
+func (provider *primaryIPProvider) waitForServerOff(ctx context.Context, server *hcloud.Server) error {
+	ctx, cancel := context.WithTimeout(ctx, provider.shutdownTimeout)
+	defer cancel()
+	ticker := time.NewTicker(provider.shutdownPollInterval)
+	defer ticker.Stop()
+
+	for {
+		current, _, err := provider.client.Server.GetByID(ctx, server.ID)
+		if err != nil && ctx.Err() == nil {
+			return fmt.Errorf("could not get server status: %w", err)
+		}
+		if err == nil && (current == nil || current.Status == hcloud.ServerStatusOff) {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("server did not shut down within %s", provider.shutdownTimeout)
+		case <-ticker.C:
+		}
+	}
+}
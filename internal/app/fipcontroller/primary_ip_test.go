@@ -0,0 +1,294 @@
+package fipcontroller
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	"github.com/hetznercloud/hcloud-go/v2/hcloud/schema"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"github.com/cbeneke/hcloud-fip-controller/internal/pkg/configuration"
+)
+
+func createTestPrimaryIP(assigneeID int64, location string) *Address {
+	address := &Address{
+		ID:       1,
+		IP:       net.ParseIP("1.2.3.4"),
+		Location: &hcloud.Location{Name: location},
+	}
+	if assigneeID != 0 {
+		address.Server = &hcloud.Server{ID: assigneeID}
+	}
+	return address
+}
+
+func TestPrimaryIPPlacement(t *testing.T) {
+	withPrimaryIPv4 := &hcloud.Server{ID: 3, Name: "with-ipv4", Location: &hcloud.Location{Name: "fsn1"}}
+	withPrimaryIPv4.PublicNet.IPv4.ID = 10
+	servers := []*hcloud.Server{
+		{ID: 1, Name: "fsn", Location: &hcloud.Location{Name: "fsn1"}},
+		{ID: 2, Name: "nbg", Location: &hcloud.Location{Name: "nbg1"}},
+		withPrimaryIPv4,
+	}
+
+	tests := []struct {
+		name      string
+		primaryIP *Address
+		result    []string
+	}{
+		{
+			name:      "servers in the same location without primary ipv4",
+			primaryIP: createTestPrimaryIP(0, "fsn1"),
+			result:    []string{"fsn"},
+		},
+		{
+			name:      "unknown location",
+			primaryIP: &Address{IP: net.ParseIP("1.2.3.4")},
+			result:    []string{"fsn", "nbg"},
+		},
+		{
+			name:      "ipv6 primary ip ignores ipv4 assignments",
+			primaryIP: &Address{IP: net.ParseIP("2001:db8::1"), Location: &hcloud.Location{Name: "fsn1"}},
+			result:    []string{"fsn", "with-ipv4"},
+		},
+		{
+			name:      "no server in location",
+			primaryIP: createTestPrimaryIP(0, "hel1"),
+			result:    nil,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			provider := &primaryIPProvider{}
+			candidates, _ := provider.Placement(servers, test.primaryIP)
+			var names []string
+			for _, server := range candidates {
+				names = append(names, server.Name)
+			}
+			if !reflect.DeepEqual(test.result, names) {
+				t.Fatalf("candidates should be %v but were %v", test.result, names)
+			}
+		})
+	}
+}
+
+func TestReconcilePrimaryIPs(t *testing.T) {
+	tests := []struct {
+		name       string
+		powerOff   bool
+		primaryIP  *Address
+		evacuating bool
+		hung       bool
+		failAssign bool
+		unassign   bool
+		err        bool
+		calls      []string
+	}{
+		{
+			name:      "assigned to running server",
+			powerOff:  true,
+			primaryIP: createTestPrimaryIP(1, "fsn1"),
+			calls:     nil,
+		},
+		{
+			name:      "power off not allowed",
+			powerOff:  false,
+			primaryIP: createTestPrimaryIP(0, "fsn1"),
+			calls:     nil,
+		},
+		{
+			name:      "no candidate in location",
+			powerOff:  true,
+			primaryIP: createTestPrimaryIP(0, "hel1"),
+			calls:     nil,
+		},
+		{
+			name:       "evacuation is blocked",
+			powerOff:   true,
+			primaryIP:  createTestPrimaryIP(1, "fsn1"),
+			evacuating: true,
+			calls:      nil,
+		},
+		{
+			name:      "unassigned",
+			powerOff:  true,
+			primaryIP: createTestPrimaryIP(0, "fsn1"),
+			calls: []string{
+				"GET /servers/1",
+				"POST /servers/1/actions/shutdown",
+				"GET /servers/1",
+				"POST /primary_ips/1/actions/assign",
+				"POST /servers/1/actions/poweron",
+				"GET /primary_ips/1",
+			},
+		},
+		{
+			name:       "failed assignment powers on target",
+			powerOff:   true,
+			primaryIP:  createTestPrimaryIP(0, "fsn1"),
+			failAssign: true,
+			err:        true,
+			calls: []string{
+				"GET /servers/1",
+				"POST /servers/1/actions/shutdown",
+				"GET /servers/1",
+				"POST /primary_ips/1/actions/assign",
+				"POST /servers/1/actions/poweron",
+			},
+		},
+		{
+			name:      "failover from failed server",
+			powerOff:  true,
+			primaryIP: createTestPrimaryIP(9, "fsn1"),
+			calls: []string{
+				"GET /servers/9",
+				"POST /servers/9/actions/shutdown",
+				"GET /servers/9",
+				"POST /primary_ips/1/actions/unassign",
+				"POST /servers/9/actions/poweron",
+				"GET /servers/1",
+				"POST /servers/1/actions/shutdown",
+				"GET /servers/1",
+				"POST /primary_ips/1/actions/assign",
+				"POST /servers/1/actions/poweron",
+				"GET /primary_ips/1",
+			},
+		},
+		{
+			name:      "failover from hung server",
+			powerOff:  true,
+			primaryIP: createTestPrimaryIP(9, "fsn1"),
+			hung:      true,
+			calls: []string{
+				"GET /servers/9",
+				"POST /servers/9/actions/shutdown",
+				"GET /servers/9",
+				"POST /servers/9/actions/poweroff",
+				"POST /primary_ips/1/actions/unassign",
+				"POST /servers/9/actions/poweron",
+				"GET /servers/1",
+				"POST /servers/1/actions/shutdown",
+				"GET /servers/1",
+				"POST /primary_ips/1/actions/assign",
+				"POST /servers/1/actions/poweron",
+				"GET /primary_ips/1",
+			},
+		},
+		{
+			name:      "hung server is not powered off without failover",
+			powerOff:  true,
+			primaryIP: createTestPrimaryIP(9, "fsn1"),
+			hung:      true,
+			unassign:  true,
+			err:       true,
+			calls: []string{
+				"GET /servers/9",
+				"POST /servers/9/actions/shutdown",
+				"GET /servers/9",
+				"POST /servers/9/actions/poweron",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			testEnv := newTestEnv()
+			defer testEnv.Teardown()
+
+			var calls []string
+			shutdown := make(map[string]bool)
+			testEnv.Mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+				call := r.Method + " " + r.URL.Path
+				// Status polls of shutting down servers are recorded once
+				if len(calls) == 0 || calls[len(calls)-1] != call {
+					calls = append(calls, call)
+				}
+				if r.Method == http.MethodGet && r.URL.Path == "/primary_ips/1" {
+					assigneeID := int64(1)
+					json.NewEncoder(w).Encode(schema.PrimaryIPGetResponse{
+						PrimaryIP: schema.PrimaryIP{ID: 1, IP: "1.2.3.4", Type: "ipv4", AssigneeID: &assigneeID},
+					})
+					return
+				}
+				if r.Method == http.MethodGet {
+					id, _ := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/servers/"), 10, 64)
+					name := "node"
+					if id == 9 {
+						name = "failed"
+					}
+					status := "running"
+					if shutdown[r.URL.Path] && !(test.hung && id == 9) {
+						status = "off"
+					}
+					json.NewEncoder(w).Encode(schema.ServerGetResponse{
+						Server: schema.Server{ID: id, Name: name, Status: status},
+					})
+					return
+				}
+				if test.failAssign && r.URL.Path == "/primary_ips/1/actions/assign" {
+					w.WriteHeader(http.StatusUnprocessableEntity)
+					json.NewEncoder(w).Encode(schema.ErrorResponse{
+						Error: schema.Error{Code: string(hcloud.ErrorCodeInvalidInput), Message: "invalid input"},
+					})
+					return
+				}
+				if path, ok := strings.CutSuffix(r.URL.Path, "/actions/shutdown"); ok {
+					shutdown[path] = true
+				}
+				w.WriteHeader(http.StatusCreated)
+				json.NewEncoder(w).Encode(schema.ServerActionPoweroffResponse{
+					Action: schema.Action{ID: 1, Status: "success"},
+				})
+			})
+
+			provider := newPrimaryIPProvider(testEnv.Client, &configuration.Configuration{
+				PrimaryIPPowerOff: test.powerOff,
+			})
+			provider.shutdownTimeout = 50 * time.Millisecond
+			provider.shutdownPollInterval = time.Millisecond
+			controller := Controller{
+				Providers:     []IPProvider{provider},
+				Configuration: &configuration.Configuration{},
+				Backoff: wait.Backoff{
+					Steps: 1,
+				},
+				Logger: logrus.New(),
+			}
+			runningServers := []*hcloud.Server{
+				{ID: 1, Name: "node", Status: hcloud.ServerStatusRunning, Location: &hcloud.Location{Name: "fsn1"}},
+			}
+			if test.evacuating {
+				// The evacuation would have a target, it is blocked as server 1 is healthy
+				runningServers = append(runningServers, &hcloud.Server{ID: 2, Name: "other", Status: hcloud.ServerStatusRunning, Location: &hcloud.Location{Name: "fsn1"}})
+				controller.evacuatingServers = map[int64]string{1: "node is cordoned"}
+			}
+
+			addresses := []*Address{test.primaryIP}
+			now := time.Now()
+			controller.observeUnhealthyServers(runningServers, addressHolders(addresses), now)
+
+			var err error
+			if test.unassign {
+				err = controller.unassignAddress(context.Background(), provider, test.primaryIP)
+			} else {
+				err = controller.reconcileAddresses(context.Background(), provider, runningServers, addresses, now)
+			}
+			if (err != nil) != test.err {
+				t.Fatalf("error should be %v but was [%v]", test.err, err)
+			}
+			if !reflect.DeepEqual(test.calls, calls) {
+				t.Fatalf("calls should be %v but were %v", test.calls, calls)
+			}
+		})
+	}
+}
@@ -0,0 +1,259 @@
+package fipcontroller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+)
+
+// Address is an IP address managed by the controller, e.g. a floating IP, a primary IP or an alias IP
+type Address struct {
+	// ID of the address, unique per kind
+	ID int64
+	IP net.IP
+	// Network is the network of addresses covering a whole network, i.e. IPv6 floating IPs
+	Network *net.IPNet
+	Labels  map[string]string
+	// Location is the (home) location of the address, or nil if it is not bound to a location
+	Location *hcloud.Location
+	// Server is the server the address is assigned to, or nil if it is unassigned
+	Server *hcloud.Server
+}
+
+// IPProvider is the cloud backend for one kind of addresses. The controller (re)assigns addresses only
+// through IPProviders, so other kinds of addresses or backends can be added without changing the
+// reconciliation.
+//
+// Calls to IPProviders are retried by the controller, so they should not retry themselves. Providers
+// implementing multiStepProvider are the exception.
+type IPProvider interface {
+	// Kind of the managed addresses, used as kind label in logs and metrics
+	Kind() string
+	// Addresses lists all addresses managed by the controller
+	Addresses(ctx context.Context) ([]*Address, error)
+	// Servers lists all servers of the backend
+	Servers(ctx context.Context) ([]*hcloud.Server, error)
+	// Placement filters the given servers down to the ones the address can be assigned to. The returned
+	// placement tier describes how the servers were chosen
+	Placement(servers []*hcloud.Server, address *Address) ([]*hcloud.Server, string)
+	// Assign the address to the server, moving it away from its current server if needed
+	Assign(ctx context.Context, address *Address, server *hcloud.Server) (*hcloud.Action, error)
+	// Unassign the address from its current server
+	Unassign(ctx context.Context, address *Address) (*hcloud.Action, error)
+	// Refresh reads the current state of the address from the backend, bypassing any cache. The server of the
+	// address is the one it is expected on.
+	Refresh(ctx context.Context, address *Address) (*Address, error)
+	// UpdateLabels replaces the labels of the address
+	UpdateLabels(ctx context.Context, address *Address, labels map[string]string) error
+	// WaitForAction waits until the given action, as returned by Assign or Unassign, finished
+	WaitForAction(ctx context.Context, action *hcloud.Action) error
+}
+
+// serverLookup is implemented by IPProviders which can look up single servers, including servers which are
+// not listed by Servers. The operations use it to name servers outside of the server label selector.
+type serverLookup interface {
+	ServerByID(ctx context.Context, id int64) (*hcloud.Server, error)
+	ServerByName(ctx context.Context, name string) (*hcloud.Server, error)
+}
+
+// multiStepProvider is implemented by IPProviders whose (un)assignments consist of several API calls which
+// change servers, e.g. shutting them down. Replaying such a sequence after a step failed acts on outdated state,
+// so the controller does not retry their (un)assignments. The providers retry the single calls with retryCall.
+type multiStepProvider interface {
+	multiStepAssignments()
+}
+
+// assignmentReasonKey carries the reason of an (un)assignment in its context
+type assignmentReasonKey struct{}
+
+// withAssignmentReason passes the reason of an (un)assignment to the provider, which uses it to decide about
+// disruptive steps, e.g. powering off a server which does not shut down
+func withAssignmentReason(ctx context.Context, reason string) context.Context {
+	return context.WithValue(ctx, assignmentReasonKey{}, reason)
+}
+
+// assignmentReason returns the reason passed by withAssignmentReason, or an empty string
+func assignmentReason(ctx context.Context) string {
+	reason, _ := ctx.Value(assignmentReasonKey{}).(string)
+	return reason
+}
+
+// BlockedError is returned by IPProviders when an address can not be (re)assigned in the current state.
+// Blocked (re)assignments are not retried.
+type BlockedError struct {
+	// Reason is used as reason label of the blocked reassignments metric
+	Reason  string
+	Message string
+}
+
+func (err *BlockedError) Error() string {
+	return err.Message
+}
+
+// Reasons why a required (re)assignment could not be performed, used as reason label values
+const (
+	blockedNoCandidate      = "no_candidate"
+	blockedPowerOffRequired = "power_off_required"
+	blockedServerRunning    = "server_running"
+)
+
+// Return the blocked error if the given error is one
+func blockedError(err error) *BlockedError {
+	var blocked *BlockedError
+	if errors.As(err, &blocked) {
+		return blocked
+	}
+	return nil
+}
+
+// Fetches all addresses managed by the provider
+func (controller *Controller) addresses(ctx context.Context, provider IPProvider) (addresses []*Address, err error) {
+	err = controller.retry(operationListAddresses, func() error {
+		addresses, err = provider.Addresses(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not get %s IPs: %w", provider.Kind(), err)
+	}
+	controller.Logger.Debugf("Fetched %d %s IPs", len(addresses), provider.Kind())
+	return addresses, nil
+}
+
+// Assign the address to the given server and wait for the assignment to finish.
+// Errors of blocked assignments are returned as they are.
+func (controller *Controller) assignAddress(ctx context.Context, provider IPProvider, address *Address, server *hcloud.Server) (err error) {
+	if controller.Configuration.DryRun {
+		controller.Logger.Infof("Dry run: not assigning %s IP '%s' to server '%s'", provider.Kind(), address.IP.String(), server.Name)
+		address.Server = server
+		return nil
+	}
+
+	ctx = withRetries(withAssignmentRequests(ctx), controller.retry)
+	start := time.Now()
+	var action *hcloud.Action
+	assign := func() error {
+		action, err = provider.Assign(ctx, address, server)
+		return err
+	}
+	if _, ok := provider.(multiStepProvider); ok {
+		err = assign()
+	} else {
+		err = controller.retry(operationAssign, assign)
+	}
+	if blockedError(err) != nil {
+		return err
+	}
+	if err == nil {
+		err = provider.WaitForAction(ctx, action)
+	}
+	if err == nil {
+		err = controller.verifyAssignment(ctx, provider, address, server)
+	}
+	if err != nil {
+		return fmt.Errorf("could not assign %s IP '%s' to server '%s': %w", provider.Kind(), address.IP.String(), server.Name, err)
+	}
+	assignmentDuration.WithLabelValues(provider.Kind()).Observe(time.Since(start).Seconds())
+	address.Server = server
+	return nil
+}
+
+// Re-read the address after its assignment finished and check that it is on the given server
+func (controller *Controller) verifyAssignment(ctx context.Context, provider IPProvider, address *Address, server *hcloud.Server) (err error) {
+	expected := *address
+	expected.Server = server
+	var current *Address
+	err = controller.retry(operationRefreshAddress, func() error {
+		current, err = provider.Refresh(ctx, &expected)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("could not verify assignment: %w", err)
+	}
+	if current.Server == nil {
+		return fmt.Errorf("address is unassigned after the assignment finished")
+	}
+	if current.Server.ID != server.ID {
+		return fmt.Errorf("address is on server %d after the assignment finished", current.Server.ID)
+	}
+	return nil
+}
+
+// Unassign the address from its server and wait for the unassignment to finish
+func (controller *Controller) unassignAddress(ctx context.Context, provider IPProvider, address *Address) (err error) {
+	if controller.Configuration.DryRun {
+		controller.Logger.Infof("Dry run: not unassigning %s IP '%s'", provider.Kind(), address.IP.String())
+		address.Server = nil
+		return nil
+	}
+
+	ctx = withRetries(withAssignmentRequests(ctx), controller.retry)
+	var action *hcloud.Action
+	unassign := func() error {
+		action, err = provider.Unassign(ctx, address)
+		return err
+	}
+	if _, ok := provider.(multiStepProvider); ok {
+		err = unassign()
+	} else {
+		err = controller.retry(operationUnassign, unassign)
+	}
+	if err == nil {
+		err = provider.WaitForAction(ctx, action)
+	}
+	if err != nil {
+		return fmt.Errorf("could not unassign %s IP '%s': %w", provider.Kind(), address.IP.String(), err)
+	}
+	address.Server = nil
+	return nil
+}
+
+// Replace the labels of the address
+func (controller *Controller) updateAddressLabels(ctx context.Context, provider IPProvider, address *Address, labels map[string]string) (err error) {
+	if controller.Configuration.DryRun {
+		controller.Logger.Infof("Dry run: not updating labels of %s IP '%s' to %v", provider.Kind(), address.IP.String(), labels)
+		address.Labels = labels
+		return nil
+	}
+
+	err = controller.retry(operationUpdateLabels, func() error {
+		return provider.UpdateLabels(ctx, address, labels)
+	})
+	if err != nil {
+		return fmt.Errorf("could not update labels of %s IP '%s': %w", provider.Kind(), address.IP.String(), err)
+	}
+	address.Labels = labels
+	return nil
+}
+
+// Check if the given IP is the address or, in case of IPv6 floating IPs, part of the address network
+func addressMatches(address *Address, ip net.IP) bool {
+	if address.Network != nil {
+		return address.Network.Contains(ip)
+	}
+	return address.IP.Equal(ip)
+}
+
+// Return the servers the given addresses are assigned to
+func addressHolders(addresses []*Address) (holders []*hcloud.Server) {
+	for _, address := range addresses {
+		if address.Server != nil {
+			holders = append(holders, address.Server)
+		}
+	}
+	return holders
+}
+
+// Count the addresses assigned to each of the given servers
+func addressAssignments(servers []*hcloud.Server, addresses []*Address) map[int64]int {
+	assignments := make(map[int64]int, len(servers))
+	for _, address := range addresses {
+		if address.Server != nil && hasServerByID(servers, address.Server) {
+			assignments[address.Server.ID]++
+		}
+	}
+	return assignments
+}
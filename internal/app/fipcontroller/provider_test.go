@@ -0,0 +1,270 @@
+package fipcontroller
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"github.com/cbeneke/hcloud-fip-controller/internal/pkg/configuration"
+)
+
+// fakeProvider is an in-memory IPProvider recording all changes
+type fakeProvider struct {
+	kind      string
+	addresses []*Address
+	servers   []*hcloud.Server
+	// blocked is returned by Assign if set
+	blocked *BlockedError
+	// failing maps address IDs to the error returned by Assign for them
+	failing map[int64]error
+	// lost makes Refresh report all addresses as unassigned
+	lost bool
+
+	// assigned maps address IDs to the ID of the server they were assigned to
+	assigned   map[int64]int64
+	unassigned []int64
+	labels     map[int64]map[string]string
+}
+
+func newFakeProvider(addresses []*Address, servers []*hcloud.Server) *fakeProvider {
+	return &fakeProvider{
+		kind:      kindFloatingIP,
+		addresses: addresses,
+		servers:   servers,
+		assigned:  make(map[int64]int64),
+		labels:    make(map[int64]map[string]string),
+	}
+}
+
+func (provider *fakeProvider) Kind() string {
+	return provider.kind
+}
+
+func (provider *fakeProvider) Addresses(_ context.Context) ([]*Address, error) {
+	return provider.addresses, nil
+}
+
+func (provider *fakeProvider) Servers(_ context.Context) ([]*hcloud.Server, error) {
+	return provider.servers, nil
+}
+
+func (provider *fakeProvider) Placement(servers []*hcloud.Server, address *Address) ([]*hcloud.Server, string) {
+	return placementCandidates(servers, address)
+}
+
+func (provider *fakeProvider) Assign(_ context.Context, address *Address, server *hcloud.Server) (*hcloud.Action, error) {
+	if provider.blocked != nil {
+		return nil, provider.blocked
+	}
+	if err := provider.failing[address.ID]; err != nil {
+		return nil, err
+	}
+	provider.assigned[address.ID] = server.ID
+	return &hcloud.Action{}, nil
+}
+
+func (provider *fakeProvider) Unassign(_ context.Context, address *Address) (*hcloud.Action, error) {
+	provider.unassigned = append(provider.unassigned, address.ID)
+	return &hcloud.Action{}, nil
+}
+
+func (provider *fakeProvider) Refresh(_ context.Context, address *Address) (*Address, error) {
+	refreshed := *address
+	if provider.lost {
+		refreshed.Server = nil
+	} else if serverID, ok := provider.assigned[address.ID]; ok {
+		refreshed.Server = &hcloud.Server{ID: serverID}
+	}
+	return &refreshed, nil
+}
+
+func (provider *fakeProvider) UpdateLabels(_ context.Context, address *Address, labels map[string]string) error {
+	provider.labels[address.ID] = labels
+	return nil
+}
+
+func (provider *fakeProvider) WaitForAction(_ context.Context, _ *hcloud.Action) error {
+	return nil
+}
+
+func TestReconcileAddresses(t *testing.T) {
+	tests := []struct {
+		name      string
+		server    *hcloud.Server
+		labels    map[string]string
+		blocked   *BlockedError
+		dryRun    bool
+		resultIDs map[int64]int64
+	}{
+		{
+			name:      "assign unassigned address",
+			resultIDs: map[int64]int64{1: 1},
+		},
+		{
+			name:      "keep address on running server",
+			server:    &hcloud.Server{ID: 2},
+			resultIDs: map[int64]int64{},
+		},
+		{
+			name:      "fail over address of failed server",
+			server:    &hcloud.Server{ID: 3},
+			resultIDs: map[int64]int64{1: 1},
+		},
+		{
+			name:      "invalid policy uses the default policy",
+			labels:    map[string]string{LabelPriority: "high"},
+			resultIDs: map[int64]int64{1: 1},
+		},
+		{
+			name:      "blocked assignment is skipped",
+			blocked:   &BlockedError{Reason: blockedPowerOffRequired, Message: "blocked"},
+			resultIDs: map[int64]int64{},
+		},
+		{
+			name:      "dry run does not assign",
+			server:    &hcloud.Server{ID: 3},
+			dryRun:    true,
+			resultIDs: map[int64]int64{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			servers := createTestServers("server-1", "server-2")
+			address := &Address{ID: 1, IP: net.ParseIP("1.2.3.4"), Server: test.server, Labels: test.labels}
+			provider := newFakeProvider([]*Address{address}, servers)
+			provider.blocked = test.blocked
+
+			controller := Controller{
+				Providers: []IPProvider{provider},
+				Backoff: wait.Backoff{
+					Steps: 1,
+				},
+				Configuration: &configuration.Configuration{DryRun: test.dryRun},
+				Logger:        logrus.New(),
+			}
+
+			now := time.Now()
+			controller.observeUnhealthyServers(servers, addressHolders(provider.addresses), now)
+			err := controller.reconcileAddresses(context.Background(), provider, servers, provider.addresses, now)
+			if err != nil {
+				t.Fatalf("error should be [nil] but was [%v]", err)
+			}
+
+			if len(provider.assigned) != len(test.resultIDs) {
+				t.Fatalf("assignments should be %v but were %v", test.resultIDs, provider.assigned)
+			}
+			for id, serverID := range test.resultIDs {
+				if provider.assigned[id] != serverID {
+					t.Fatalf("assignments should be %v but were %v", test.resultIDs, provider.assigned)
+				}
+			}
+		})
+	}
+}
+
+func TestReconcileAddressesFailure(t *testing.T) {
+	servers := createTestServers("server-1")
+	addresses := []*Address{
+		{ID: 1, IP: net.ParseIP("1.2.3.4")},
+		{ID: 2, IP: net.ParseIP("1.2.3.5")},
+	}
+	provider := newFakeProvider(addresses, servers)
+	provider.failing = map[int64]error{1: errors.New("server error")}
+
+	controller := Controller{
+		Providers: []IPProvider{provider},
+		Backoff: wait.Backoff{
+			Steps: 1,
+		},
+		Configuration: &configuration.Configuration{},
+		Logger:        logrus.New(),
+	}
+
+	err := controller.reconcileAddresses(context.Background(), provider, servers, provider.addresses, time.Now())
+	if err == nil {
+		t.Fatal("error should be returned for the failing address but was [nil]")
+	}
+	if _, ok := provider.assigned[1]; ok {
+		t.Fatalf("failing address should not be assigned but assignments were %v", provider.assigned)
+	}
+	if provider.assigned[2] != servers[0].ID {
+		t.Fatalf("address after the failing one should be assigned but assignments were %v", provider.assigned)
+	}
+}
+
+func TestAssignAddress(t *testing.T) {
+	tests := []struct {
+		name string
+		lost bool
+		err  bool
+	}{
+		{
+			name: "verified assignment",
+			err:  false,
+		},
+		{
+			name: "address not on server after assignment",
+			lost: true,
+			err:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			servers := createTestServers("server-1")
+			address := &Address{ID: 1, IP: net.ParseIP("1.2.3.4")}
+			provider := newFakeProvider([]*Address{address}, servers)
+			provider.lost = test.lost
+
+			controller := Controller{
+				Providers: []IPProvider{provider},
+				Backoff: wait.Backoff{
+					Steps: 1,
+				},
+				Configuration: &configuration.Configuration{},
+				Logger:        logrus.New(),
+			}
+
+			err := controller.assignAddress(context.Background(), provider, address, servers[0])
+			if (err != nil) != test.err {
+				t.Fatalf("error should be %t but was [%v]", test.err, err)
+			}
+			if !test.err && address.Server != servers[0] {
+				t.Fatalf("address should be on server [%v] but was on [%v]", servers[0], address.Server)
+			}
+			if test.err && address.Server != nil {
+				t.Fatalf("address should stay unassigned but was on [%v]", address.Server)
+			}
+		})
+	}
+}
+
+func TestAddressMatches(t *testing.T) {
+	_, ipv6Network, _ := net.ParseCIDR("2001:db8::/64")
+	tests := []struct {
+		name    string
+		address *Address
+		ip      string
+		result  bool
+	}{
+		{name: "ipv4 match", address: &Address{IP: net.ParseIP("1.2.3.4")}, ip: "1.2.3.4", result: true},
+		{name: "ipv4 mismatch", address: &Address{IP: net.ParseIP("1.2.3.4")}, ip: "1.2.3.5", result: false},
+		{name: "ipv6 network match", address: &Address{IP: ipv6Network.IP, Network: ipv6Network}, ip: "2001:db8::1", result: true},
+		{name: "ipv6 network mismatch", address: &Address{IP: ipv6Network.IP, Network: ipv6Network}, ip: "2001:db9::1", result: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if result := addressMatches(test.address, net.ParseIP(test.ip)); result != test.result {
+				t.Fatalf("match should be %t but was %t", test.result, result)
+			}
+		})
+	}
+}
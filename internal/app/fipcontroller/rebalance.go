@@ -0,0 +1,110 @@
+package fipcontroller
+
+import (
+	"context"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+)
+
+// rebalanceAddresses moves a single address of the provider from the server holding the most managed addresses
+// to a less loaded server, if the difference exceeds the configured rebalance threshold.
+// To avoid connection resets caused by moving many IPs at once, at most one address is moved per
+// configured rebalance interval.
+func (controller *Controller) rebalanceAddresses(ctx context.Context, provider IPProvider, runningServers []*hcloud.Server, addresses []*Address, policies map[int64]*addressPolicy, assignments map[int64]int) error {
+	if !controller.lastRebalance.IsZero() && time.Since(controller.lastRebalance) < controller.Configuration.RebalanceInterval {
+		controller.Logger.Debugf("Skipping rebalancing, last move was at %s", controller.lastRebalance)
+		return nil
+	}
+
+	// Only addresses managed by this controller are taken into account
+	managed := managedAssignments(runningServers, addresses)
+
+	var source, target *hcloud.Server
+	for _, server := range runningServers {
+		if source == nil || len(managed[server.ID]) > len(managed[source.ID]) {
+			source = server
+		}
+		if target == nil || len(managed[server.ID]) < len(managed[target.ID]) {
+			target = server
+		}
+	}
+	imbalance := len(managed[source.ID]) - len(managed[target.ID])
+	if imbalance <= controller.Configuration.RebalanceThreshold {
+		controller.Logger.Debugf("%s IP imbalance of %d is within threshold", provider.Kind(), imbalance)
+		return nil
+	}
+
+	// Let the assignment strategy choose between all servers the move would improve the balance for
+	var improving []*hcloud.Server
+	for _, server := range runningServers {
+		if len(managed[server.ID])+1 < len(managed[source.ID]) {
+			improving = append(improving, server)
+		}
+	}
+
+	counts := make(map[int64]int, len(managed))
+	for id, ips := range managed {
+		counts[id] = len(ips)
+	}
+
+	// Pick the first address of the source server which its policy allows on one of the improving servers.
+	// Addresses are never moved to a worse placement, e.g. out of their home location, for a better balance.
+	var address *Address
+	var server *hcloud.Server
+	var placement string
+	for _, candidate := range managed[source.ID] {
+		policy := policies[candidate.ID]
+		var candidates []*hcloud.Server
+		candidates, placement = provider.Placement(intersectServers(improving, policy.servers), candidate)
+		if len(candidates) < 1 {
+			continue
+		}
+		if _, current := provider.Placement([]*hcloud.Server{source}, candidate); placementRank(placement) > placementRank(current) {
+			controller.Logger.Debugf("Not rebalancing %s IP '%s', placement would change from %s to %s", provider.Kind(), candidate.IP.String(), current, placement)
+			continue
+		}
+		if server = policy.strategy.SelectServer(candidates, candidate, counts); server != nil {
+			address = candidate
+			break
+		}
+	}
+	if server == nil {
+		controller.Logger.Debugf("Found no %s IP on server '%s' that can be rebalanced", provider.Kind(), source.Name)
+		return nil
+	}
+
+	controller.Logger.WithField("kind", provider.Kind()).Infof("Rebalancing address '%s' from server '%s' to server '%s' in location '%s' (placement: %s)", address.IP.String(), source.Name, server.Name, serverLocation(server), placement)
+	if err := controller.assignAddress(withAssignmentReason(ctx, reasonRebalance), provider, address, server); err != nil {
+		controller.eventAssignFailed(ctx, provider, address, server, policies[address.ID].followService, err)
+		return controller.addressError(provider, address, err)
+	}
+	assignments[source.ID]--
+	assignments[server.ID]++
+	controller.lastRebalance = time.Now()
+
+	controller.recordReassignment(ctx, provider, address, server, reasonRebalance, placement)
+	controller.eventMoved(ctx, provider, address, source, server, reasonRebalance, policies[address.ID].followService)
+	return nil
+}
+
+// Group the given addresses by the running server they are assigned to
+func managedAssignments(runningServers []*hcloud.Server, addresses []*Address) map[int64][]*Address {
+	managed := make(map[int64][]*Address, len(runningServers))
+	for _, address := range addresses {
+		if address.Server != nil && hasServerByID(runningServers, address.Server) {
+			managed[address.Server.ID] = append(managed[address.Server.ID], address)
+		}
+	}
+	return managed
+}
+
+// Return the servers of the first list that are also in the second list
+func intersectServers(servers []*hcloud.Server, allowed []*hcloud.Server) (result []*hcloud.Server) {
+	for _, server := range servers {
+		if hasServerByID(allowed, server) {
+			result = append(result, server)
+		}
+	}
+	return result
+}
@@ -0,0 +1,146 @@
+package fipcontroller
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	"github.com/hetznercloud/hcloud-go/v2/hcloud/schema"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"github.com/cbeneke/hcloud-fip-controller/internal/pkg/configuration"
+)
+
+func TestRebalanceAddresses(t *testing.T) {
+	fsn1 := &hcloud.Location{Name: "fsn1", NetworkZone: hcloud.NetworkZoneEUCentral}
+	nbg1 := &hcloud.Location{Name: "nbg1", NetworkZone: hcloud.NetworkZoneEUCentral}
+
+	tests := []struct {
+		name          string
+		assigned      []int
+		threshold     int
+		lastRebalance time.Time
+		// locations of the servers, the floating ips are homed in fsn1 if set
+		locations    []*hcloud.Location
+		resultServer int64
+	}{
+		{
+			name:         "move floating ip to empty server",
+			assigned:     []int{3, 0},
+			threshold:    1,
+			resultServer: 2,
+		},
+		{
+			name:      "imbalance within threshold",
+			assigned:  []int{2, 1},
+			threshold: 1,
+		},
+		{
+			name:         "move floating ip within its home location",
+			assigned:     []int{3, 0},
+			threshold:    1,
+			locations:    []*hcloud.Location{fsn1, fsn1},
+			resultServer: 2,
+		},
+		{
+			name:      "keep floating ip in its home location",
+			assigned:  []int{3, 0},
+			threshold: 1,
+			locations: []*hcloud.Location{fsn1, nbg1},
+		},
+		{
+			name:         "move floating ip back to its home location",
+			assigned:     []int{3, 0},
+			threshold:    1,
+			locations:    []*hcloud.Location{nbg1, fsn1},
+			resultServer: 2,
+		},
+		{
+			name:          "rate limited",
+			assigned:      []int{3, 0},
+			threshold:     1,
+			lastRebalance: time.Now(),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			testEnv := newTestEnv()
+			defer testEnv.Teardown()
+
+			var assignedServer int64
+			testEnv.Mux.HandleFunc("/floating_ips/", func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodGet {
+					json.NewEncoder(w).Encode(schema.FloatingIPGetResponse{
+						FloatingIP: schema.FloatingIP{ID: 1, Type: "ipv4", IP: "1.2.3.4", Server: &assignedServer},
+					})
+					return
+				}
+				var reqBody schema.FloatingIPActionAssignRequest
+				if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+					t.Fatal(err)
+				}
+				assignedServer = reqBody.Server
+				w.WriteHeader(201)
+				json.NewEncoder(w).Encode(schema.FloatingIPActionAssignResponse{
+					Action: schema.Action{ID: 1},
+				})
+			})
+
+			servers := createTestServers("server-1", "server-2")
+			var home *hcloud.Location
+			if len(test.locations) > 0 {
+				home = fsn1
+			}
+			for i, location := range test.locations {
+				servers[i].Location = location
+			}
+			var addresses []*Address
+			for i, count := range test.assigned {
+				for j := 0; j < count; j++ {
+					addresses = append(addresses, &Address{
+						ID:       int64(len(addresses) + 1),
+						IP:       net.ParseIP("1.2.3.4"),
+						Location: home,
+						Server:   servers[i],
+					})
+				}
+			}
+
+			provider := newFloatingIPProvider(testEnv.Client, &configuration.Configuration{})
+			controller := Controller{
+				Providers: []IPProvider{provider},
+				Backoff: wait.Backoff{
+					Steps: 1,
+				},
+				Configuration: &configuration.Configuration{
+					Rebalance:          true,
+					RebalanceThreshold: test.threshold,
+					RebalanceInterval:  time.Minute,
+				},
+				Logger:        logrus.New(),
+				lastRebalance: test.lastRebalance,
+			}
+
+			addresses, policies, err := controller.addressPolicies(context.Background(), provider, servers, addresses)
+			if err != nil {
+				t.Fatalf("error should be [nil] but was [%v]", err)
+			}
+
+			assignments := addressAssignments(servers, addresses)
+			err = controller.rebalanceAddresses(context.Background(), provider, servers, addresses, policies, assignments)
+			if err != nil {
+				t.Fatalf("error should be [nil] but was [%v]", err)
+			}
+
+			if assignedServer != test.resultServer {
+				t.Fatalf("floating ip should be assigned to server [%d] but was [%d]", test.resultServer, assignedServer)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package fipcontroller
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/retry"
+)
+
+// Operations retried by the controller, used as operation label values and to choose the backoff profile
+const (
+	operationListAddresses      = "list_addresses"
+	operationListServers        = "list_servers"
+	operationRefreshAddress     = "refresh_address"
+	operationAssign             = "assign"
+	operationUnassign           = "unassign"
+	operationUpdateLabels       = "update_labels"
+	operationGetServer          = "get_server"
+	operationShutdownServer     = "shutdown_server"
+	operationPowerOffServer     = "poweroff_server"
+	operationPowerOnServer      = "poweron_server"
+	operationGetPod             = "get_pod"
+	operationListPods           = "list_pods"
+	operationGetNode            = "get_node"
+	operationListNodes          = "list_nodes"
+	operationPatchNode          = "patch_node"
+	operationGetService         = "get_service"
+	operationListServices       = "list_services"
+	operationUpdateService      = "update_service_status"
+	operationListEndpointSlices = "list_endpoint_slices"
+	operationListPools          = "list_pools"
+	operationUpdatePoolStatus   = "update_pool_status"
+	operationListAssignments    = "list_assignments"
+	operationUpdateAssignment   = "update_assignment"
+	operationDeleteAssignment   = "delete_assignment"
+)
+
+// Classes of errors, used as class label values. Only transient errors are retried.
+const (
+	errorClassTransient    = "transient"
+	errorClassCanceled     = "canceled"
+	errorClassNotFound     = "not_found"
+	errorClassUnauthorized = "unauthorized"
+	errorClassInvalid      = "invalid"
+	errorClassConflict     = "conflict"
+	errorClassBlocked      = "blocked"
+	errorClassAction       = "action_failed"
+)
+
+// backoffProfile scales the configured backoff for an operation. All backoffs are jittered, so concurrent
+// calls and controllers do not retry in lockstep.
+type backoffProfile struct {
+	durationFactor float64
+	jitter         float64
+}
+
+var (
+	// Changes of addresses are retried quickly to keep failovers short
+	changeBackoffProfile = backoffProfile{durationFactor: 0.5, jitter: 0.2}
+	// Reads happen on every reconciliation, so they back off further to spare the APIs
+	readBackoffProfile = backoffProfile{durationFactor: 1, jitter: 0.5}
+)
+
+var backoffProfiles = map[string]backoffProfile{
+	operationAssign:         changeBackoffProfile,
+	operationUnassign:       changeBackoffProfile,
+	operationUpdateLabels:   changeBackoffProfile,
+	operationUpdateService:  changeBackoffProfile,
+	operationShutdownServer: changeBackoffProfile,
+	operationPowerOffServer: changeBackoffProfile,
+	operationPowerOnServer:  changeBackoffProfile,
+}
+
+// backoff returns the configured backoff adapted to the profile of the operation
+func (controller *Controller) backoff(operation string) wait.Backoff {
+	profile, ok := backoffProfiles[operation]
+	if !ok {
+		profile = readBackoffProfile
+	}
+	backoff := controller.Backoff
+	backoff.Duration = time.Duration(float64(backoff.Duration) * profile.durationFactor)
+	backoff.Jitter = profile.jitter
+	return backoff
+}
+
+// retry calls fn until it succeeds, fails with an error which is not transient or the backoff of the
+// operation is exhausted. Retries and failures are counted by operation and error class.
+func (controller *Controller) retry(operation string, fn func() error) error {
+	err := retry.OnError(controller.backoff(operation), func(err error) bool {
+		class := classifyError(err)
+		if class != errorClassTransient {
+			return false
+		}
+		retriesTotal.WithLabelValues(operation, class).Inc()
+		controller.Logger.Debugf("Retrying %s after %s error: %v", operation, class, err)
+		return true
+	}, fn)
+	if err != nil {
+		failedCallsTotal.WithLabelValues(operation, classifyError(err)).Inc()
+	}
+	return err
+}
+
+// retryKey carries the retry function of the controller in the contexts of (un)assignments
+type retryKey struct{}
+
+// withRetries passes the retry function to providers, which retry the single API calls of (un)assignments
+// consisting of several calls with it
+func withRetries(ctx context.Context, retry func(operation string, fn func() error) error) context.Context {
+	return context.WithValue(ctx, retryKey{}, retry)
+}
+
+// retryCall retries fn with the retry function of the context. Without one, fn is called once.
+func retryCall(ctx context.Context, operation string, fn func() error) error {
+	if retry, ok := ctx.Value(retryKey{}).(func(operation string, fn func() error) error); ok {
+		return retry(operation, fn)
+	}
+	return fn()
+}
+
+// classifyError returns the class of the error from the hcloud error codes, kubernetes API errors and context
+// errors. Unknown errors, e.g. network errors, are considered transient.
+func classifyError(err error) string {
+	var actionErr hcloud.ActionError
+	switch {
+	case blockedError(err) != nil:
+		return errorClassBlocked
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return errorClassCanceled
+	case errors.As(err, &actionErr):
+		return errorClassAction
+
+	case hcloud.IsError(err, hcloud.ErrorCodeNotFound):
+		return errorClassNotFound
+	case hcloud.IsError(err, hcloud.ErrorCodeUnauthorized, hcloud.ErrorCodeForbidden, hcloud.ErrorCodeTokenReadonly):
+		return errorClassUnauthorized
+	case hcloud.IsError(err, hcloud.ErrorCodeRateLimitExceeded, hcloud.ErrorCodeConflict, hcloud.ErrorCodeLocked,
+		hcloud.ErrorCodeServiceError, hcloud.ErrorCodeServerError, hcloud.ErrorCodeBadGateway, hcloud.ErrorCodeTimeout,
+		hcloud.ErrorCodeMaintenance, hcloud.ErrorCodeResourceUnavailable, hcloud.ErrorCodeRobotUnavailable,
+		hcloud.ErrorCodeUnknownError):
+		return errorClassTransient
+	}
+
+	var hcloudErr hcloud.Error
+	if errors.As(err, &hcloudErr) {
+		// All other hcloud errors are caused by the request, e.g. invalid input or a server in the wrong state
+		return errorClassInvalid
+	}
+
+	switch {
+	case apierrors.IsNotFound(err):
+		return errorClassNotFound
+	case apierrors.IsUnauthorized(err), apierrors.IsForbidden(err):
+		return errorClassUnauthorized
+	case apierrors.IsInvalid(err), apierrors.IsBadRequest(err), apierrors.IsMethodNotSupported(err), apierrors.IsAlreadyExists(err):
+		return errorClassInvalid
+	case apierrors.IsConflict(err):
+		// The object changed, retrying the same update would conflict again
+		return errorClassConflict
+	}
+	return errorClassTransient
+}
@@ -0,0 +1,157 @@
+package fipcontroller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	hcloudschema "github.com/hetznercloud/hcloud-go/v2/hcloud/schema"
+	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"github.com/cbeneke/hcloud-fip-controller/internal/pkg/configuration"
+)
+
+func TestClassifyError(t *testing.T) {
+	nodes := schema.GroupResource{Resource: "nodes"}
+	tests := []struct {
+		name  string
+		err   error
+		class string
+	}{
+		{name: "network error", err: errors.New("connection reset by peer"), class: errorClassTransient},
+		{name: "canceled context", err: fmt.Errorf("request failed: %w", context.Canceled), class: errorClassCanceled},
+		{name: "blocked", err: &BlockedError{Reason: blockedNoCandidate}, class: errorClassBlocked},
+		{name: "failed action", err: hcloud.ActionError{Code: "failed"}, class: errorClassAction},
+		{name: "hcloud not found", err: hcloud.Error{Code: hcloud.ErrorCodeNotFound}, class: errorClassNotFound},
+		{name: "hcloud unauthorized", err: hcloud.Error{Code: hcloud.ErrorCodeUnauthorized}, class: errorClassUnauthorized},
+		{name: "hcloud rate limit", err: hcloud.Error{Code: hcloud.ErrorCodeRateLimitExceeded}, class: errorClassTransient},
+		{name: "hcloud locked", err: hcloud.Error{Code: hcloud.ErrorCodeLocked}, class: errorClassTransient},
+		{name: "hcloud invalid input", err: hcloud.Error{Code: hcloud.ErrorCodeInvalidInput}, class: errorClassInvalid},
+		{name: "hcloud server not stopped", err: hcloud.Error{Code: hcloud.ErrorCodeServerNotStopped}, class: errorClassInvalid},
+		{name: "kubernetes not found", err: apierrors.NewNotFound(nodes, "node-1"), class: errorClassNotFound},
+		{name: "kubernetes forbidden", err: apierrors.NewForbidden(nodes, "node-1", errors.New("denied")), class: errorClassUnauthorized},
+		{name: "kubernetes conflict", err: apierrors.NewConflict(nodes, "node-1", errors.New("changed")), class: errorClassConflict},
+		{name: "kubernetes timeout", err: apierrors.NewServerTimeout(nodes, "list", 1), class: errorClassTransient},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if class := classifyError(test.err); class != test.class {
+				t.Fatalf("class should be %s but was %s", test.class, class)
+			}
+		})
+	}
+}
+
+func TestRetry(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		attempts int
+	}{
+		{name: "transient error is retried", err: errors.New("connection reset by peer"), attempts: 3},
+		{name: "not found is not retried", err: hcloud.Error{Code: hcloud.ErrorCodeNotFound}, attempts: 1},
+		{name: "success is not retried", err: nil, attempts: 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			controller := Controller{
+				Backoff: wait.Backoff{
+					Duration: time.Millisecond,
+					Factor:   1,
+					Steps:    3,
+				},
+				Logger: logrus.New(),
+			}
+
+			attempts := 0
+			err := controller.retry(operationListServers, func() error {
+				attempts++
+				return test.err
+			})
+			if !errors.Is(err, test.err) {
+				t.Fatalf("error should be [%v] but was [%v]", test.err, err)
+			}
+			if attempts != test.attempts {
+				t.Fatalf("attempts should be %d but were %d", test.attempts, attempts)
+			}
+		})
+	}
+}
+
+func TestClassifyProviderError(t *testing.T) {
+	tests := []struct {
+		name   string
+		code   hcloud.ErrorCode
+		status int
+		call   func(controller *Controller, client *hcloud.Client) error
+		class  string
+	}{
+		{
+			name:   "listing floating ips unauthorized",
+			code:   hcloud.ErrorCodeUnauthorized,
+			status: http.StatusUnauthorized,
+			call: func(controller *Controller, client *hcloud.Client) error {
+				provider := newFloatingIPProvider(client, &configuration.Configuration{FloatingIPLabelSelector: "fip=true"})
+				_, err := controller.addresses(context.Background(), provider)
+				return err
+			},
+			class: errorClassUnauthorized,
+		},
+		{
+			name:   "assigning alias ip with invalid input",
+			code:   hcloud.ErrorCodeInvalidInput,
+			status: http.StatusUnprocessableEntity,
+			call: func(controller *Controller, client *hcloud.Client) error {
+				provider := newAliasIPProvider(client, &configuration.Configuration{AliasIPNetwork: "1"})
+				address := &Address{ID: 1, IP: net.ParseIP("10.0.0.5")}
+				return controller.assignAddress(context.Background(), provider, address, &hcloud.Server{ID: 1, Name: "node"})
+			},
+			class: errorClassInvalid,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			testEnv := newTestEnv()
+			defer testEnv.Teardown()
+
+			requests := 0
+			testEnv.Mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(test.status)
+				json.NewEncoder(w).Encode(hcloudschema.ErrorResponse{
+					Error: hcloudschema.Error{Code: string(test.code), Message: "request failed"},
+				})
+			})
+			controller := &Controller{
+				Configuration: &configuration.Configuration{},
+				Backoff: wait.Backoff{
+					Duration: time.Millisecond,
+					Factor:   1,
+					Steps:    3,
+				},
+				Logger: logrus.New(),
+			}
+
+			err := test.call(controller, testEnv.Client)
+			if class := classifyError(err); class != test.class {
+				t.Fatalf("class should be %s but was %s (error: %v)", test.class, class, err)
+			}
+			if requests != 1 {
+				t.Fatalf("requests should be 1 but were %d", requests)
+			}
+		})
+	}
+}
@@ -0,0 +1,271 @@
+package fipcontroller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	"go.opentelemetry.io/otel/attribute"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// hcloud labels recording the service a floating IP is allocated to in service IPAM mode
+const (
+	// LabelServiceNamespace is the namespace of the service the floating IP is allocated to
+	LabelServiceNamespace = "fip.hcloud/service-namespace"
+	// LabelServiceName is the name of the service the floating IP is allocated to
+	LabelServiceName = "fip.hcloud/service-name"
+)
+
+// reconcileServiceIPs allocates floating IPs from the pool to services of type LoadBalancer, publishes them
+// in the service status and assigns them to a server running a ready endpoint of the service.
+// Floating IPs allocated to services that no longer exist are released back into the pool.
+// Each floating IP is reconciled independently, a failing floating IP does not stop the reconciliation of the others.
+func (controller *Controller) reconcileServiceIPs(ctx context.Context, provider IPProvider, runningServers []*hcloud.Server, floatingIPs []*Address, now time.Time) error {
+	services, err := controller.loadBalancerServices(ctx)
+	if err != nil {
+		return err
+	}
+	controller.Logger.Debugf("Found %d services of type LoadBalancer", len(services))
+
+	allocated := make(map[string]*Address)
+	var free []*Address
+	var errs []error
+	for _, floatingIP := range floatingIPs {
+		key := serviceAllocation(floatingIP)
+		if key == "" {
+			free = append(free, floatingIP)
+			continue
+		}
+		if _, ok := services[key]; !ok || allocated[key] != nil {
+			if err := controller.releaseServiceIP(ctx, provider, floatingIP, key); err != nil {
+				errs = append(errs, controller.addressError(provider, floatingIP, err))
+				continue
+			}
+			free = append(free, floatingIP)
+			continue
+		}
+		allocated[key] = floatingIP
+	}
+
+	keys := make([]string, 0, len(services))
+	for key := range services {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	assignments := addressAssignments(runningServers, floatingIPs)
+	for _, key := range keys {
+		service := services[key]
+
+		floatingIP, ok := allocated[key]
+		if !ok {
+			floatingIP, free = popIPv4FloatingIP(free)
+			if floatingIP == nil {
+				controller.Logger.Warnf("No free floating IP left for service '%s'", key)
+				continue
+			}
+			if err := controller.allocateServiceIP(ctx, provider, floatingIP, service); err != nil {
+				errs = append(errs, controller.addressError(provider, floatingIP, err))
+				continue
+			}
+		}
+
+		if err := controller.updateServiceStatus(ctx, service, floatingIP); err != nil {
+			errs = append(errs, controller.addressError(provider, floatingIP, err))
+			continue
+		}
+		if err := controller.assignServiceIP(ctx, provider, service, floatingIP, runningServers, assignments, now); err != nil {
+			errs = append(errs, controller.addressError(provider, floatingIP, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// List all services of type LoadBalancer handled by the controller, keyed by "<namespace>/<name>".
+// Only services with the configured load balancer class are handled. Without a configured class,
+// only services without a class are handled.
+func (controller *Controller) loadBalancerServices(ctx context.Context) (map[string]*corev1.Service, error) {
+	services, err := controller.listServices(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not list services: %w", err)
+	}
+
+	result := make(map[string]*corev1.Service)
+	for i := range services.Items {
+		service := &services.Items[i]
+		if !controller.handlesLoadBalancer(service) {
+			continue
+		}
+		key := service.Namespace + "/" + service.Name
+		controller.followedServices.add(key)
+		result[key] = service
+	}
+	return result, nil
+}
+
+// handlesLoadBalancer reports whether the service is of type LoadBalancer with the configured load balancer class
+func (controller *Controller) handlesLoadBalancer(service *corev1.Service) bool {
+	if service.Spec.Type != corev1.ServiceTypeLoadBalancer {
+		return false
+	}
+	class := ""
+	if service.Spec.LoadBalancerClass != nil {
+		class = *service.Spec.LoadBalancerClass
+	}
+	return class == controller.Configuration.LoadBalancerClass
+}
+
+// Allocate the floating IP to the given service by labelling it with the service namespace and name
+func (controller *Controller) allocateServiceIP(ctx context.Context, provider IPProvider, floatingIP *Address, service *corev1.Service) error {
+	labels := make(map[string]string, len(floatingIP.Labels)+2)
+	for key, value := range floatingIP.Labels {
+		labels[key] = value
+	}
+	labels[LabelServiceNamespace] = service.Namespace
+	labels[LabelServiceName] = service.Name
+
+	controller.Logger.Infof("Allocating address '%s' to service '%s/%s'", floatingIP.IP.String(), service.Namespace, service.Name)
+	return controller.updateAddressLabels(ctx, provider, floatingIP, labels)
+}
+
+// Release the floating IP back into the pool by removing the service labels and unassigning it
+func (controller *Controller) releaseServiceIP(ctx context.Context, provider IPProvider, floatingIP *Address, key string) error {
+	labels := make(map[string]string, len(floatingIP.Labels))
+	for label, value := range floatingIP.Labels {
+		if label != LabelServiceNamespace && label != LabelServiceName {
+			labels[label] = value
+		}
+	}
+
+	controller.Logger.Infof("Releasing address '%s' of service '%s'", floatingIP.IP.String(), key)
+	if err := controller.updateAddressLabels(ctx, provider, floatingIP, labels); err != nil {
+		return err
+	}
+
+	if floatingIP.Server != nil {
+		return controller.unassignAddress(ctx, provider, floatingIP)
+	}
+	return nil
+}
+
+// Publish the floating IP as the only ingress of the service
+func (controller *Controller) updateServiceStatus(ctx context.Context, service *corev1.Service, floatingIP *Address) (err error) {
+	ingress := service.Status.LoadBalancer.Ingress
+	if len(ingress) == 1 && ingress[0].IP == floatingIP.IP.String() {
+		return nil
+	}
+
+	if controller.Configuration.DryRun {
+		controller.Logger.Infof("Dry run: not publishing address '%s' as ingress of service '%s/%s'", floatingIP.IP.String(), service.Namespace, service.Name)
+		return nil
+	}
+
+	service = service.DeepCopy()
+	service.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{IP: floatingIP.IP.String()}}
+	err = controller.retry(operationUpdateService, func() error {
+		_, err = controller.KubernetesClient.CoreV1().Services(service.Namespace).UpdateStatus(ctx, service, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("could not update status of service '%s/%s': %w", service.Namespace, service.Name, err)
+	}
+	return nil
+}
+
+// Assign the floating IP of a service to a running server hosting a ready endpoint of the service.
+// If the floating IP already is on such a server, or the service has no ready endpoints, nothing is changed.
+// Assigned floating IPs are not moved during the takeover grace period, and only moved off a server which is
+// no longer running once it is considered failed, like all other addresses.
+func (controller *Controller) assignServiceIP(ctx context.Context, provider IPProvider, service *corev1.Service, floatingIP *Address, runningServers []*hcloud.Server, assignments map[int64]int, now time.Time) error {
+	nodeNames, err := controller.serviceEndpointNodeNames(ctx, service.Namespace, service.Name)
+	if err != nil {
+		return err
+	}
+	endpointServers, err := controller.serversForNodeNames(ctx, nodeNames, runningServers)
+	if err != nil {
+		return err
+	}
+	endpointServers = controller.withoutEvacuatingServers(endpointServers)
+	serviceKey := service.Namespace + "/" + service.Name
+	if len(endpointServers) < 1 {
+		controller.Logger.Debugf("Service '%s/%s' has no ready endpoints on running servers", service.Namespace, service.Name)
+		return nil
+	}
+	if floatingIP.Server != nil && hasServerByID(endpointServers, floatingIP.Server) {
+		return nil
+	}
+
+	reason := reasonService
+	if floatingIP.Server != nil {
+		switch {
+		case controller.inTakeoverGracePeriod(now):
+			controller.Logger.Infof("Not moving address '%s' away from server %d during takeover grace period", floatingIP.IP.String(), floatingIP.Server.ID)
+			return nil
+		case !hasServerByID(runningServers, floatingIP.Server):
+			reason = reasonFailover
+			if !controller.isServerFailed(floatingIP.Server, now) {
+				observation := controller.unhealthyServers[floatingIP.Server.ID]
+				controller.Logger.Infof("Server %d of address '%s' unhealthy for %d observations since %s, waiting before failover",
+					floatingIP.Server.ID, floatingIP.IP.String(), observation.observations, observation.since.Format(time.RFC3339))
+				controller.recordPendingFailover(provider, floatingIP)
+				return nil
+			}
+		case controller.isServerEvacuating(floatingIP.Server):
+			reason = reasonEvacuation
+		}
+	}
+
+	candidates, placement := provider.Placement(endpointServers, floatingIP)
+	if len(candidates) < 1 {
+		controller.Logger.Warnf("No endpoint server of service '%s/%s' can route address '%s'", service.Namespace, service.Name, floatingIP.IP.String())
+		controller.eventNoHealthyTarget(ctx, provider, floatingIP, serviceKey, "no endpoint server of the service can route it")
+		return nil
+	}
+	server := controller.assignmentStrategy().SelectServer(candidates, floatingIP, assignments)
+	if server == nil {
+		controller.Logger.Warnf("Assignment strategy found no server for address '%s'", floatingIP.IP.String())
+		controller.eventNoHealthyTarget(ctx, provider, floatingIP, serviceKey, "the assignment strategy found no server")
+		return nil
+	}
+
+	controller.Logger.Infof("Switching address '%s' of service '%s/%s' to server '%s' (reason: %s, placement: %s)", floatingIP.IP.String(), service.Namespace, service.Name, server.Name, reason, placement)
+	previous := floatingIP.Server
+	if err := controller.assignAddress(withAssignmentReason(ctx, reason), provider, floatingIP, server); err != nil {
+		controller.eventAssignFailed(ctx, provider, floatingIP, server, serviceKey, err)
+		return err
+	}
+	assignments[server.ID]++
+	if previous != nil {
+		assignments[previous.ID]--
+	}
+
+	controller.recordReassignment(ctx, provider, floatingIP, server, reason, placement,
+		attribute.String("service", serviceKey))
+	controller.eventMoved(ctx, provider, floatingIP, previous, server, reason, serviceKey)
+	return nil
+}
+
+// Return the "<namespace>/<name>" of the service the floating IP is allocated to, or an empty string
+func serviceAllocation(floatingIP *Address) string {
+	namespace, name := floatingIP.Labels[LabelServiceNamespace], floatingIP.Labels[LabelServiceName]
+	if namespace == "" || name == "" {
+		return ""
+	}
+	return namespace + "/" + name
+}
+
+// Take the first IPv4 floating IP from the list. IPv6 floating IPs are networks and can not be allocated
+// as a single service IP.
+func popIPv4FloatingIP(floatingIPs []*Address) (*Address, []*Address) {
+	for i, floatingIP := range floatingIPs {
+		if floatingIP.Network == nil && floatingIP.IP.To4() != nil {
+			return floatingIP, append(floatingIPs[:i:i], floatingIPs[i+1:]...)
+		}
+	}
+	return nil, floatingIPs
+}
@@ -0,0 +1,278 @@
+package fipcontroller
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	"github.com/hetznercloud/hcloud-go/v2/hcloud/schema"
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/cbeneke/hcloud-fip-controller/internal/pkg/configuration"
+)
+
+func createTestEndpointSlice(namespace, service, nodeName string) *discoveryv1.EndpointSlice {
+	ready := true
+	return &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      service + "-abcde",
+			Namespace: namespace,
+			Labels: map[string]string{
+				discoveryv1.LabelServiceName: service,
+			},
+		},
+		Endpoints: []discoveryv1.Endpoint{
+			{
+				Addresses:  []string{"10.0.0.1"},
+				Conditions: discoveryv1.EndpointConditions{Ready: &ready},
+				NodeName:   &nodeName,
+			},
+		},
+	}
+}
+
+func TestReconcileServiceIPs(t *testing.T) {
+	tests := []struct {
+		name           string
+		floatingIP     *Address
+		objects        []runtime.Object
+		dryRun         bool
+		threshold      int
+		gracePeriod    time.Duration
+		resultLabels   map[string]string
+		resultServer   int64
+		resultUnassign bool
+		resultIngress  string
+	}{
+		{
+			name: "allocate and assign free floating ip",
+			floatingIP: &Address{
+				ID: 1,
+				IP: net.ParseIP("10.10.10.10"),
+			},
+			objects: []runtime.Object{
+				&v1.Service{
+					ObjectMeta: metav1.ObjectMeta{Name: "ingress", Namespace: "web"},
+					Spec:       v1.ServiceSpec{Type: v1.ServiceTypeLoadBalancer},
+				},
+				createTestEndpointSlice("web", "ingress", "node-2"),
+			},
+			resultLabels: map[string]string{
+				LabelServiceNamespace: "web",
+				LabelServiceName:      "ingress",
+			},
+			resultServer:  2,
+			resultIngress: "10.10.10.10",
+		},
+		{
+			name: "release floating ip of deleted service",
+			floatingIP: &Address{
+				ID: 1,
+				IP: net.ParseIP("10.10.10.10"),
+				Labels: map[string]string{
+					LabelServiceNamespace: "web",
+					LabelServiceName:      "deleted",
+					"foo":                 "bar",
+				},
+				Server: &hcloud.Server{ID: 1},
+			},
+			resultLabels:   map[string]string{"foo": "bar"},
+			resultUnassign: true,
+		},
+		{
+			name: "fail over from failed server",
+			floatingIP: &Address{
+				ID: 1,
+				IP: net.ParseIP("10.10.10.10"),
+				Labels: map[string]string{
+					LabelServiceNamespace: "web",
+					LabelServiceName:      "ingress",
+				},
+				Server: &hcloud.Server{ID: 9},
+			},
+			objects: []runtime.Object{
+				&v1.Service{
+					ObjectMeta: metav1.ObjectMeta{Name: "ingress", Namespace: "web"},
+					Spec:       v1.ServiceSpec{Type: v1.ServiceTypeLoadBalancer},
+					Status:     v1.ServiceStatus{LoadBalancer: v1.LoadBalancerStatus{Ingress: []v1.LoadBalancerIngress{{IP: "10.10.10.10"}}}},
+				},
+				createTestEndpointSlice("web", "ingress", "node-2"),
+			},
+			resultServer: 2,
+		},
+		{
+			name:      "wait for unhealthy threshold before failover",
+			threshold: 2,
+			floatingIP: &Address{
+				ID: 1,
+				IP: net.ParseIP("10.10.10.10"),
+				Labels: map[string]string{
+					LabelServiceNamespace: "web",
+					LabelServiceName:      "ingress",
+				},
+				Server: &hcloud.Server{ID: 9},
+			},
+			objects: []runtime.Object{
+				&v1.Service{
+					ObjectMeta: metav1.ObjectMeta{Name: "ingress", Namespace: "web"},
+					Spec:       v1.ServiceSpec{Type: v1.ServiceTypeLoadBalancer},
+					Status:     v1.ServiceStatus{LoadBalancer: v1.LoadBalancerStatus{Ingress: []v1.LoadBalancerIngress{{IP: "10.10.10.10"}}}},
+				},
+				createTestEndpointSlice("web", "ingress", "node-2"),
+			},
+		},
+		{
+			name:        "no failover during takeover grace period",
+			gracePeriod: time.Minute,
+			floatingIP: &Address{
+				ID: 1,
+				IP: net.ParseIP("10.10.10.10"),
+				Labels: map[string]string{
+					LabelServiceNamespace: "web",
+					LabelServiceName:      "ingress",
+				},
+				Server: &hcloud.Server{ID: 9},
+			},
+			objects: []runtime.Object{
+				&v1.Service{
+					ObjectMeta: metav1.ObjectMeta{Name: "ingress", Namespace: "web"},
+					Spec:       v1.ServiceSpec{Type: v1.ServiceTypeLoadBalancer},
+					Status:     v1.ServiceStatus{LoadBalancer: v1.LoadBalancerStatus{Ingress: []v1.LoadBalancerIngress{{IP: "10.10.10.10"}}}},
+				},
+				createTestEndpointSlice("web", "ingress", "node-2"),
+			},
+		},
+		{
+			name: "dry run changes nothing",
+			floatingIP: &Address{
+				ID: 1,
+				IP: net.ParseIP("10.10.10.10"),
+			},
+			objects: []runtime.Object{
+				&v1.Service{
+					ObjectMeta: metav1.ObjectMeta{Name: "ingress", Namespace: "web"},
+					Spec:       v1.ServiceSpec{Type: v1.ServiceTypeLoadBalancer},
+				},
+				createTestEndpointSlice("web", "ingress", "node-2"),
+			},
+			dryRun: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			testEnv := newTestEnv()
+			defer testEnv.Teardown()
+
+			var labels map[string]string
+			var assignedServer int64
+			var unassigned bool
+			testEnv.Mux.HandleFunc("/floating_ips/1", func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodGet {
+					json.NewEncoder(w).Encode(schema.FloatingIPGetResponse{
+						FloatingIP: schema.FloatingIP{ID: 1, Type: "ipv4", IP: "10.10.10.10", Labels: labels, Server: &assignedServer},
+					})
+					return
+				}
+				var reqBody schema.FloatingIPUpdateRequest
+				if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+					t.Fatal(err)
+				}
+				labels = *reqBody.Labels
+				json.NewEncoder(w).Encode(schema.FloatingIPUpdateResponse{
+					FloatingIP: schema.FloatingIP{ID: 1, Type: "ipv4", IP: "10.10.10.10", Labels: labels},
+				})
+			})
+			testEnv.Mux.HandleFunc("/floating_ips/1/actions/assign", func(w http.ResponseWriter, r *http.Request) {
+				var reqBody schema.FloatingIPActionAssignRequest
+				if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+					t.Fatal(err)
+				}
+				assignedServer = reqBody.Server
+				w.WriteHeader(201)
+				json.NewEncoder(w).Encode(schema.FloatingIPActionAssignResponse{
+					Action: schema.Action{ID: 1},
+				})
+			})
+			testEnv.Mux.HandleFunc("/floating_ips/1/actions/unassign", func(w http.ResponseWriter, r *http.Request) {
+				unassigned = true
+				w.WriteHeader(201)
+				json.NewEncoder(w).Encode(schema.FloatingIPActionUnassignResponse{
+					Action: schema.Action{ID: 1},
+				})
+			})
+
+			objects := append(test.objects,
+				createTestNode("node-1", []v1.NodeAddress{{Type: v1.NodeExternalIP, Address: "1.1.1.1"}}, v1.ConditionTrue),
+				createTestNode("node-2", []v1.NodeAddress{{Type: v1.NodeExternalIP, Address: "2.2.2.2"}}, v1.ConditionTrue),
+			)
+			kubernetesFakeClient := fake.NewSimpleClientset(objects...)
+
+			provider := newFloatingIPProvider(testEnv.Client, &configuration.Configuration{})
+			controller := Controller{
+				Providers:        []IPProvider{provider},
+				KubernetesClient: kubernetesFakeClient,
+				Backoff: wait.Backoff{
+					Steps: 1,
+				},
+				Configuration: &configuration.Configuration{
+					ServiceIPAM:         true,
+					DryRun:              test.dryRun,
+					UnhealthyThreshold:  test.threshold,
+					TakeoverGracePeriod: test.gracePeriod,
+				},
+				Logger: logrus.New(),
+			}
+			now := time.Now()
+			controller.leadingSince = now
+
+			runningServers := []*hcloud.Server{
+				{ID: 1, Name: "server-1", PublicNet: hcloud.ServerPublicNet{IPv4: hcloud.ServerPublicNetIPv4{IP: net.ParseIP("1.1.1.1")}}},
+				{ID: 2, Name: "server-2", PublicNet: hcloud.ServerPublicNet{IPv4: hcloud.ServerPublicNetIPv4{IP: net.ParseIP("2.2.2.2")}}},
+			}
+
+			controller.observeUnhealthyServers(runningServers, addressHolders([]*Address{test.floatingIP}), now)
+
+			err := controller.reconcileServiceIPs(context.Background(), provider, runningServers, []*Address{test.floatingIP}, now)
+			if err != nil {
+				t.Fatalf("error should be [nil] but was [%v]", err)
+			}
+
+			if len(labels) != len(test.resultLabels) {
+				t.Fatalf("labels should be %v but were %v", test.resultLabels, labels)
+			}
+			for key, value := range test.resultLabels {
+				if labels[key] != value {
+					t.Fatalf("labels should be %v but were %v", test.resultLabels, labels)
+				}
+			}
+			if assignedServer != test.resultServer {
+				t.Fatalf("floating ip should be assigned to server [%d] but was [%d]", test.resultServer, assignedServer)
+			}
+			if unassigned != test.resultUnassign {
+				t.Fatalf("floating ip unassigned should be %t but was %t", test.resultUnassign, unassigned)
+			}
+
+			if test.resultIngress != "" {
+				service, err := kubernetesFakeClient.CoreV1().Services("web").Get(context.Background(), "ingress", metav1.GetOptions{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				ingress := service.Status.LoadBalancer.Ingress
+				if len(ingress) != 1 || ingress[0].IP != test.resultIngress {
+					t.Fatalf("service ingress should be [%s] but was %v", test.resultIngress, ingress)
+				}
+			}
+		})
+	}
+}
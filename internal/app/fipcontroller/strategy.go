@@ -0,0 +1,151 @@
+package fipcontroller
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+
+	"github.com/cbeneke/hcloud-fip-controller/internal/pkg/configuration"
+)
+
+// AssignmentStrategy decides which server an address is assigned to.
+//
+// SelectServer is called with the candidate servers (never empty), the address that needs a server
+// and the current assignments, mapping server IDs to the number of addresses of the same kind
+// assigned to them. It returns nil when none of the candidates is acceptable.
+type AssignmentStrategy interface {
+	SelectServer(servers []*hcloud.Server, address *Address, assignments map[int64]int) *hcloud.Server
+}
+
+// newAssignmentStrategy creates the AssignmentStrategy configured by the given configuration.
+// An empty strategy name selects the least-loaded strategy.
+func newAssignmentStrategy(config *configuration.Configuration) (AssignmentStrategy, error) {
+	switch config.AssignmentStrategy {
+	case "", configuration.AssignmentStrategyLeastLoaded:
+		return leastLoadedStrategy{}, nil
+	case configuration.AssignmentStrategyRandom:
+		return randomStrategy{}, nil
+	case configuration.AssignmentStrategyWeighted:
+		weights, err := parseServerWeights(config.ServerWeights)
+		if err != nil {
+			return nil, err
+		}
+		return weightedStrategy{weights: weights}, nil
+	case configuration.AssignmentStrategyOrdered:
+		return orderedStrategy{preferences: splitListFlags(config.ServerPreferences)}, nil
+	}
+	return nil, fmt.Errorf("unknown assignment strategy '%s'", config.AssignmentStrategy)
+}
+
+// assignmentStrategy returns the configured strategy, falling back to least-loaded if none is set
+func (controller *Controller) assignmentStrategy() AssignmentStrategy {
+	if controller.Strategy == nil {
+		return leastLoadedStrategy{}
+	}
+	return controller.Strategy
+}
+
+// leastLoadedStrategy selects the server with the lowest amount of addresses.
+// On a tie the first server in the list wins.
+type leastLoadedStrategy struct{}
+
+func (leastLoadedStrategy) SelectServer(servers []*hcloud.Server, _ *Address, assignments map[int64]int) *hcloud.Server {
+	var selected *hcloud.Server
+	for _, server := range servers {
+		if selected == nil || assignments[server.ID] < assignments[selected.ID] {
+			selected = server
+		}
+	}
+	return selected
+}
+
+// randomStrategy selects a uniformly random server
+type randomStrategy struct{}
+
+func (randomStrategy) SelectServer(servers []*hcloud.Server, _ *Address, _ map[int64]int) *hcloud.Server {
+	if len(servers) < 1 {
+		return nil
+	}
+	return servers[rand.IntN(len(servers))]
+}
+
+// weightedStrategy distributes addresses proportionally to the server weights, i.e. it selects the
+// server with the lowest amount of addresses per weight. Servers without a configured weight have
+// a weight of 1, servers with a weight of 0 never get an address assigned.
+type weightedStrategy struct {
+	weights map[string]int
+}
+
+func (strategy weightedStrategy) SelectServer(servers []*hcloud.Server, _ *Address, assignments map[int64]int) *hcloud.Server {
+	var selected *hcloud.Server
+	var selectedLoad float64
+	for _, server := range servers {
+		weight := strategy.weight(server)
+		if weight <= 0 {
+			continue
+		}
+		// Compare the load the server would have after getting the address assigned
+		load := float64(assignments[server.ID]+1) / float64(weight)
+		if selected == nil || load < selectedLoad {
+			selected = server
+			selectedLoad = load
+		}
+	}
+	return selected
+}
+
+func (strategy weightedStrategy) weight(server *hcloud.Server) int {
+	if weight, ok := strategy.weights[server.Name]; ok {
+		return weight
+	}
+	return 1
+}
+
+// orderedStrategy selects the first available server from an ordered list of server names.
+// If none of the preferred servers is available, the least-loaded of the remaining servers is used.
+type orderedStrategy struct {
+	preferences []string
+}
+
+func (strategy orderedStrategy) SelectServer(servers []*hcloud.Server, address *Address, assignments map[int64]int) *hcloud.Server {
+	for _, name := range strategy.preferences {
+		for _, server := range servers {
+			if server.Name == name {
+				return server
+			}
+		}
+	}
+	return leastLoadedStrategy{}.SelectServer(servers, address, assignments)
+}
+
+// Parse server weights in the form "<server name>=<weight>"
+func parseServerWeights(values []string) (map[string]int, error) {
+	weights := make(map[string]int)
+	for _, value := range splitListFlags(values) {
+		name, weightString, found := strings.Cut(value, "=")
+		if !found || name == "" {
+			return nil, fmt.Errorf("server weight '%s' is not in the form <server>=<weight>", value)
+		}
+		weight, err := strconv.Atoi(weightString)
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("server weight '%s' is not a non-negative integer", value)
+		}
+		weights[name] = weight
+	}
+	return weights, nil
+}
+
+// Split comma separated flag values, so list options can also be set via a single environment variable
+func splitListFlags(values []string) (result []string) {
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				result = append(result, item)
+			}
+		}
+	}
+	return result
+}
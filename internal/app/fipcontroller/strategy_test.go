@@ -0,0 +1,136 @@
+package fipcontroller
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+
+	"github.com/cbeneke/hcloud-fip-controller/internal/pkg/configuration"
+)
+
+func createTestServers(names ...string) []*hcloud.Server {
+	servers := make([]*hcloud.Server, 0, len(names))
+	for i, name := range names {
+		servers = append(servers, &hcloud.Server{ID: int64(i + 1), Name: name})
+	}
+	return servers
+}
+
+func TestSelectServer(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      *configuration.Configuration
+		servers     []*hcloud.Server
+		assignments map[int64]int
+		result      string
+	}{
+		{
+			name:        "least-loaded picks server with fewest floating ips",
+			config:      &configuration.Configuration{},
+			servers:     createTestServers("server-1", "server-2", "server-3"),
+			assignments: map[int64]int{1: 2, 2: 1, 3: 1},
+			result:      "server-2",
+		},
+		{
+			name:        "weighted respects server weights",
+			config:      &configuration.Configuration{AssignmentStrategy: configuration.AssignmentStrategyWeighted, ServerWeights: []string{"server-1=3"}},
+			servers:     createTestServers("server-1", "server-2"),
+			assignments: map[int64]int{1: 2, 2: 1},
+			result:      "server-1",
+		},
+		{
+			name:        "weighted skips servers with weight 0",
+			config:      &configuration.Configuration{AssignmentStrategy: configuration.AssignmentStrategyWeighted, ServerWeights: []string{"server-1=0,server-2=1"}},
+			servers:     createTestServers("server-1", "server-2"),
+			assignments: map[int64]int{1: 0, 2: 5},
+			result:      "server-2",
+		},
+		{
+			name:        "weighted finds no server if all weights are 0",
+			config:      &configuration.Configuration{AssignmentStrategy: configuration.AssignmentStrategyWeighted, ServerWeights: []string{"server-1=0"}},
+			servers:     createTestServers("server-1"),
+			assignments: map[int64]int{},
+			result:      "",
+		},
+		{
+			name:        "ordered picks first available preference",
+			config:      &configuration.Configuration{AssignmentStrategy: configuration.AssignmentStrategyOrdered, ServerPreferences: []string{"server-4", "server-3", "server-1"}},
+			servers:     createTestServers("server-1", "server-2", "server-3"),
+			assignments: map[int64]int{3: 10},
+			result:      "server-3",
+		},
+		{
+			name:        "ordered falls back to least-loaded",
+			config:      &configuration.Configuration{AssignmentStrategy: configuration.AssignmentStrategyOrdered, ServerPreferences: []string{"server-4"}},
+			servers:     createTestServers("server-1", "server-2"),
+			assignments: map[int64]int{1: 1},
+			result:      "server-2",
+		},
+		{
+			name:        "random picks one of the servers",
+			config:      &configuration.Configuration{AssignmentStrategy: configuration.AssignmentStrategyRandom},
+			servers:     createTestServers("server-1"),
+			assignments: map[int64]int{},
+			result:      "server-1",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			strategy, err := newAssignmentStrategy(test.config)
+			if err != nil {
+				t.Fatalf("error should be [nil] but was [%v]", err)
+			}
+
+			server := strategy.SelectServer(test.servers, &Address{}, test.assignments)
+
+			name := ""
+			if server != nil {
+				name = server.Name
+			}
+			if name != test.result {
+				t.Fatalf("server should be [%s] but was [%s]", test.result, name)
+			}
+		})
+	}
+}
+
+func TestParseServerWeights(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []string
+		result map[string]int
+		err    error
+	}{
+		{
+			name:   "test multiple flags and comma separated values",
+			values: []string{"server-1=2", "server-2=0, server-3=5"},
+			result: map[string]int{"server-1": 2, "server-2": 0, "server-3": 5},
+		},
+		{
+			name:   "test missing weight",
+			values: []string{"server-1"},
+			err:    fmt.Errorf("server weight 'server-1' is not in the form <server>=<weight>"),
+		},
+		{
+			name:   "test negative weight",
+			values: []string{"server-1=-1"},
+			err:    fmt.Errorf("server weight 'server-1=-1' is not a non-negative integer"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			weights, err := parseServerWeights(test.values)
+
+			if !reflect.DeepEqual(test.err, err) {
+				t.Fatalf("error should be [%v] but was [%v]", test.err, err)
+			}
+			if test.err == nil && !reflect.DeepEqual(test.result, weights) {
+				t.Fatalf("result should be %v but was %v", test.result, weights)
+			}
+		})
+	}
+}
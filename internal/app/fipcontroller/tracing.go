@@ -41,7 +41,7 @@ func InitTracing(ctx context.Context, endpoint, serviceName, serviceVersion stri
 
 	exporter, err := otlptracegrpc.New(ctx, otlptracegrpc.WithEndpointURL(endpoint))
 	if err != nil {
-		return noop, fmt.Errorf("could not create OTLP trace exporter: %v", err)
+		return noop, fmt.Errorf("could not create OTLP trace exporter: %w", err)
 	}
 
 	res, err := resource.New(ctx, resource.WithAttributes(
@@ -49,7 +49,7 @@ func InitTracing(ctx context.Context, endpoint, serviceName, serviceVersion stri
 		attribute.String("service.version", serviceVersion),
 	))
 	if err != nil {
-		return noop, fmt.Errorf("could not create trace resource: %v", err)
+		return noop, fmt.Errorf("could not create trace resource: %w", err)
 	}
 
 	provider := sdktrace.NewTracerProvider(
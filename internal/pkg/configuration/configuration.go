@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"strings"
 )
 
@@ -11,12 +12,12 @@ import (
 func (config *Configuration) VarsFromFile(configFile string) error {
 	file, err := ioutil.ReadFile(configFile)
 	if err != nil {
-		return fmt.Errorf("failed to read config file: %v", err)
+		return fmt.Errorf("failed to read config file: %w", err)
 	}
 
 	err = json.Unmarshal(file, &config)
 	if err != nil {
-		return fmt.Errorf("failed to decode config file: %v", err)
+		return fmt.Errorf("failed to decode config file: %w", err)
 	}
 
 	return nil
@@ -59,6 +60,60 @@ func (config *Configuration) Validate() error {
 		errs = append(errs, "backoff steps need to be greater than 0")
 	}
 
+	if config.RebalanceThreshold < 0 {
+		errs = append(errs, "rebalance threshold must not be negative")
+	}
+	if config.RebalanceInterval < 0 {
+		errs = append(errs, "rebalance interval must not be negative")
+	}
+
+	if config.UnhealthyThreshold < 0 {
+		errs = append(errs, "unhealthy threshold must not be negative")
+	}
+	if config.UnhealthyDuration < 0 {
+		errs = append(errs, "unhealthy duration must not be negative")
+	}
+	if config.HcloudCacheTTL < 0 {
+		errs = append(errs, "hcloud cache ttl must not be negative")
+	}
+	if config.HcloudRateLimitHeadroom < 0 {
+		errs = append(errs, "hcloud rate limit headroom must not be negative")
+	}
+	if config.ResyncInterval < 0 {
+		errs = append(errs, "resync interval must not be negative")
+	}
+	if config.TakeoverGracePeriod < 0 {
+		errs = append(errs, "takeover grace period must not be negative")
+	}
+
+	switch config.AssignmentStrategy {
+	case "", AssignmentStrategyLeastLoaded, AssignmentStrategyRandom, AssignmentStrategyWeighted, AssignmentStrategyOrdered:
+	default:
+		errs = append(errs, fmt.Sprintf("assignment strategy '%s' is not supported", config.AssignmentStrategy))
+	}
+
+	if len(config.AliasIPs) > 0 && config.AliasIPNetwork == "" {
+		errs = append(errs, "alias ip network needs to be set for alias ips")
+	}
+	for _, aliasIP := range config.AliasIPs {
+		if net.ParseIP(aliasIP) == nil {
+			errs = append(errs, fmt.Sprintf("alias ip '%s' is not a valid IP address", aliasIP))
+		}
+	}
+
+	if config.FollowService != "" {
+		namespace, name, _ := strings.Cut(config.FollowService, "/")
+		if namespace == "" || name == "" {
+			errs = append(errs, fmt.Sprintf("follow service '%s' needs to be in the form <namespace>/<name>", config.FollowService))
+		}
+	}
+
+	for _, rule := range config.EvacuateTaints {
+		if _, err := ParseTaintRule(rule); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
 	if len(undefinedErrs) > 0 {
 		errs = append(errs, fmt.Sprintf("required configuration options not configured: %s", strings.Join(undefinedErrs, ", ")))
 	}
@@ -67,3 +122,66 @@ func (config *Configuration) Validate() error {
 	}
 	return nil
 }
+
+// ValidateAgent checks the configuration of the node agent. The agent does not use the hetzner cloud API and
+// only needs the node, the namespace of the assignment objects and the interface to configure.
+func (config *Configuration) ValidateAgent() error {
+	var errs []string
+	var undefinedErrs []string
+
+	if config.NodeName == "" {
+		undefinedErrs = append(undefinedErrs, "kubernetes node name")
+	}
+	if config.Namespace == "" {
+		undefinedErrs = append(undefinedErrs, "kubernetes namespace")
+	}
+	if config.AgentInterface == "" {
+		undefinedErrs = append(undefinedErrs, "agent interface")
+	}
+
+	if config.BackoffDuration == 0 {
+		errs = append(errs, "backoff duration is not a valid duration or 0")
+	}
+	if config.BackoffFactor < 1 {
+		errs = append(errs, "backoff factor must be at least 1")
+	}
+	if config.BackoffSteps < 0 {
+		errs = append(errs, "backoff steps need to be greater than 0")
+	}
+	if config.ResyncInterval < 0 {
+		errs = append(errs, "resync interval must not be negative")
+	}
+
+	if len(undefinedErrs) > 0 {
+		errs = append(errs, fmt.Sprintf("required configuration options not configured: %s", strings.Join(undefinedErrs, ", ")))
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// TaintRule matches kubernetes node taints. Empty values and effects match any value and effect
+type TaintRule struct {
+	Key    string
+	Value  string
+	Effect string
+}
+
+// ParseTaintRule parses a taint rule in the kubectl taint form key[=value][:effect]
+func ParseTaintRule(rule string) (TaintRule, error) {
+	var taintRule TaintRule
+	keyValue, effect, _ := strings.Cut(rule, ":")
+	taintRule.Key, taintRule.Value, _ = strings.Cut(keyValue, "=")
+	taintRule.Effect = effect
+
+	if taintRule.Key == "" {
+		return taintRule, fmt.Errorf("evacuate taint '%s' has no key", rule)
+	}
+	switch taintRule.Effect {
+	case "", TaintEffectNoSchedule, TaintEffectPreferNoSchedule, TaintEffectNoExecute:
+	default:
+		return taintRule, fmt.Errorf("evacuate taint effect '%s' is not supported", taintRule.Effect)
+	}
+	return taintRule, nil
+}
@@ -113,6 +113,134 @@ func TestValidate(t *testing.T) {
 			},
 			err: fmt.Errorf("backoff steps need to be greater than 0"),
 		},
+		{
+			name: "test rebalance threshold invalid",
+			config: func() *Configuration {
+				conf := testConfig()
+				conf.RebalanceThreshold = -1
+				return conf
+			},
+			err: fmt.Errorf("rebalance threshold must not be negative"),
+		},
+		{
+			name: "test unhealthy threshold invalid",
+			config: func() *Configuration {
+				conf := testConfig()
+				conf.UnhealthyThreshold = -1
+				return conf
+			},
+			err: fmt.Errorf("unhealthy threshold must not be negative"),
+		},
+		{
+			name: "test hcloud cache ttl invalid",
+			config: func() *Configuration {
+				conf := testConfig()
+				conf.HcloudCacheTTL = -time.Second
+				return conf
+			},
+			err: fmt.Errorf("hcloud cache ttl must not be negative"),
+		},
+		{
+			name: "test hcloud rate limit headroom invalid",
+			config: func() *Configuration {
+				conf := testConfig()
+				conf.HcloudRateLimitHeadroom = -1
+				return conf
+			},
+			err: fmt.Errorf("hcloud rate limit headroom must not be negative"),
+		},
+		{
+			name: "test resync interval invalid",
+			config: func() *Configuration {
+				conf := testConfig()
+				conf.ResyncInterval = -time.Second
+				return conf
+			},
+			err: fmt.Errorf("resync interval must not be negative"),
+		},
+		{
+			name: "test follow service invalid",
+			config: func() *Configuration {
+				conf := testConfig()
+				conf.FollowService = "ingress"
+				return conf
+			},
+			err: fmt.Errorf("follow service 'ingress' needs to be in the form <namespace>/<name>"),
+		},
+		{
+			name: "test assignment strategy valid",
+			config: func() *Configuration {
+				conf := testConfig()
+				conf.AssignmentStrategy = AssignmentStrategyWeighted
+				return conf
+			},
+			err: nil,
+		},
+		{
+			name: "test assignment strategy invalid",
+			config: func() *Configuration {
+				conf := testConfig()
+				conf.AssignmentStrategy = "foo"
+				return conf
+			},
+			err: fmt.Errorf("assignment strategy 'foo' is not supported"),
+		},
+		{
+			name: "test alias ips valid",
+			config: func() *Configuration {
+				conf := testConfig()
+				conf.AliasIPs = []string{"10.0.0.100"}
+				conf.AliasIPNetwork = "internal"
+				return conf
+			},
+			err: nil,
+		},
+		{
+			name: "test alias ips without network",
+			config: func() *Configuration {
+				conf := testConfig()
+				conf.AliasIPs = []string{"10.0.0.100"}
+				return conf
+			},
+			err: fmt.Errorf("alias ip network needs to be set for alias ips"),
+		},
+		{
+			name: "test alias ip invalid",
+			config: func() *Configuration {
+				conf := testConfig()
+				conf.AliasIPs = []string{"foo"}
+				conf.AliasIPNetwork = "internal"
+				return conf
+			},
+			err: fmt.Errorf("alias ip 'foo' is not a valid IP address"),
+		},
+		{
+			name: "test evacuate taints valid",
+			config: func() *Configuration {
+				conf := testConfig()
+				conf.EvacuateTaints = []string{"maintenance", "maintenance=planned", "maintenance:NoSchedule", "maintenance=planned:NoExecute"}
+				return conf
+			},
+			err: nil,
+		},
+		{
+			name: "test evacuate taint without key",
+			config: func() *Configuration {
+				conf := testConfig()
+				conf.EvacuateTaints = []string{"=planned"}
+				return conf
+			},
+			err: fmt.Errorf("evacuate taint '=planned' has no key"),
+		},
+		{
+			name: "test evacuate taint invalid effect",
+			config: func() *Configuration {
+				conf := testConfig()
+				conf.EvacuateTaints = []string{"maintenance:Sometimes"}
+				return conf
+			},
+			err: fmt.Errorf("evacuate taint effect 'Sometimes' is not supported"),
+		},
 	}
 
 	for _, test := range tests {
@@ -135,3 +263,50 @@ func TestValidate(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateAgent(t *testing.T) {
+	tests := []struct {
+		name   string
+		config GenConfiguration
+		err    error
+	}{
+		{
+			name: "test valid config without token",
+			config: func() *Configuration {
+				conf := testConfig()
+				conf.HcloudAPIToken = ""
+				conf.AgentInterface = "eth0"
+				return conf
+			},
+			err: nil,
+		},
+		{
+			name: "test no node name and interface",
+			config: func() *Configuration {
+				conf := testConfig()
+				conf.NodeName = ""
+				return conf
+			},
+			err: fmt.Errorf("%skubernetes node name, agent interface", errorPrefix),
+		},
+		{
+			name: "test negative resync interval",
+			config: func() *Configuration {
+				conf := testConfig()
+				conf.AgentInterface = "eth0"
+				conf.ResyncInterval = -time.Second
+				return conf
+			},
+			err: fmt.Errorf("resync interval must not be negative"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.config().ValidateAgent()
+			if fmt.Sprint(err) != fmt.Sprint(test.err) {
+				t.Fatalf("error should be [%v] but was [%v]", test.err, err)
+			}
+		})
+	}
+}
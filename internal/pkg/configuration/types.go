@@ -26,6 +26,73 @@ type Configuration struct {
 	BackoffFactor           float64          `json:"backoff_factor,omitempty"`
 	BackoffSteps            int              `json:"backoff_steps,omitempty"`
 	HealthCheckAddress      string           `json:"health_check_address,omitempty"`
+	// AssignmentStrategy chooses the server an address is assigned to, one of the AssignmentStrategy constants
+	AssignmentStrategy string `json:"assignment_strategy,omitempty"`
+	// ServerWeights are server weights in the form <server>=<weight>, used by the weighted assignment strategy
+	ServerWeights stringArrayFlags `json:"server_weights,omitempty"`
+	// ServerPreferences are server names in order of preference, used by the ordered assignment strategy
+	ServerPreferences stringArrayFlags `json:"server_preferences,omitempty"`
+	// Rebalance moves floating IPs between running servers to even out their distribution
+	Rebalance bool `json:"rebalance,omitempty"`
+	// RebalanceThreshold is the difference in floating IPs between the most and the least loaded server which
+	// is tolerated before rebalancing
+	RebalanceThreshold int `json:"rebalance_threshold,omitempty"`
+	// RebalanceInterval is the minimum time between two rebalancing moves
+	RebalanceInterval time.Duration `json:"rebalance_interval,omitempty"`
+	// UnhealthyThreshold is the number of consecutive observations a server has to be unhealthy before its
+	// addresses are moved
+	UnhealthyThreshold int `json:"unhealthy_threshold,omitempty"`
+	// UnhealthyDuration is the time a server has to be unhealthy before its addresses are moved
+	UnhealthyDuration time.Duration `json:"unhealthy_duration,omitempty"`
+	// TakeoverGracePeriod is the time a new leader waits before it moves assigned addresses
+	TakeoverGracePeriod time.Duration `json:"takeover_grace_period,omitempty"`
+	// ServiceIPAM allocates floating IPs to LoadBalancer services and publishes them in the service status
+	ServiceIPAM bool `json:"service_ipam,omitempty"`
+	// LoadBalancerClass is the load balancer class of the services handled in service IPAM mode. If empty,
+	// services without a load balancer class are handled
+	LoadBalancerClass string `json:"load_balancer_class,omitempty"`
+	// FollowServiceAnnotations lets floating IPs follow the endpoints of services annotated with them
+	FollowServiceAnnotations bool `json:"follow_service_annotations,omitempty"`
+	// PrimaryIPLabelSelector enables managing the primary IPs matching the selector
+	PrimaryIPLabelSelector string `json:"primary_ip_label_selector,omitempty"`
+	// PrimaryIPPowerOff allows powering off servers to move primary IPs between them
+	PrimaryIPPowerOff bool `json:"primary_ip_power_off,omitempty"`
+	// AliasIPs are virtual IPs inside the AliasIPNetwork, moved between the alias IPs of the servers
+	AliasIPs stringArrayFlags `json:"alias_ips,omitempty"`
+	// AliasIPNetwork is the name or ID of the hcloud network the alias IPs belong to
+	AliasIPNetwork string `json:"alias_ip_network,omitempty"`
+	// EvacuateUnschedulable moves addresses off cordoned nodes before they go down
+	EvacuateUnschedulable bool `json:"evacuate_unschedulable,omitempty"`
+	// EvacuateTaints are taint rules in the form key[=value][:effect]. Addresses are moved off nodes with a
+	// matching taint
+	EvacuateTaints stringArrayFlags `json:"evacuate_taints,omitempty"`
+	// EvacuateNoExecute moves addresses off nodes with any NoExecute taint
+	EvacuateNoExecute bool `json:"evacuate_no_execute,omitempty"`
+	// ServerLabelSelector restricts the hcloud servers the controller lists, e.g. to the servers of the cluster
+	ServerLabelSelector string `json:"server_label_selector,omitempty"`
+	// HcloudCacheTTL is the time hcloud servers and addresses are cached between reconciliations
+	HcloudCacheTTL time.Duration `json:"hcloud_cache_ttl,omitempty"`
+	// HcloudRateLimitHeadroom is the number of hcloud API requests kept for changing addresses. Reads are slowed
+	// down once the remaining rate limit drops to it
+	HcloudRateLimitHeadroom int `json:"hcloud_rate_limit_headroom,omitempty"`
+	// ResyncInterval is the maximum time between two reconciliations. Node and pod changes trigger a
+	// reconciliation right away
+	ResyncInterval time.Duration `json:"resync_interval,omitempty"`
+	// FollowService is the "<namespace>/<name>" of a service. All floating IPs follow its ready endpoints unless
+	// their labels or a service annotation choose another service
+	FollowService string `json:"follow_service,omitempty"`
+	// FloatingIPPools makes the controller manage floating IPs only through FloatingIPPool objects instead of
+	// the floating IP options
+	FloatingIPPools bool `json:"floating_ip_pools,omitempty"`
+	// AssignmentObjects makes the leader maintain a FloatingIPAssignment object per managed address in the
+	// namespace of the controller
+	AssignmentObjects bool `json:"assignment_objects,omitempty"`
+	// NodeLabels makes the leader label the nodes holding managed IPs and annotate them with the IPs they hold
+	NodeLabels bool `json:"node_labels,omitempty"`
+	// AgentInterface is the host network interface the node agent configures the floating IPs of its node on
+	AgentInterface string `json:"agent_interface,omitempty"`
+	// DryRun makes the controller only log, trace and count the changes it would make
+	DryRun bool `json:"dry_run,omitempty"`
 	// OtelExporterOtlpEndpoint enables OpenTelemetry trace export when set.
 	// Maps to the standard OTEL_EXPORTER_OTLP_ENDPOINT environment variable.
 	OtelExporterOtlpEndpoint string `json:"otel_exporter_otlp_endpoint,omitempty"`
@@ -52,6 +119,24 @@ const (
 	NodeAddressTypeInternal = "internal"
 )
 
+const (
+	// AssignmentStrategyLeastLoaded assigns floating IPs to the server with the fewest floating IPs
+	AssignmentStrategyLeastLoaded = "least-loaded"
+	// AssignmentStrategyRandom assigns floating IPs to a random server
+	AssignmentStrategyRandom = "random"
+	// AssignmentStrategyWeighted distributes floating IPs proportionally to the configured server weights
+	AssignmentStrategyWeighted = "weighted"
+	// AssignmentStrategyOrdered assigns floating IPs to the first available server of the configured preferences
+	AssignmentStrategyOrdered = "ordered"
+)
+
+// Taint effects of kubernetes nodes, as used in evacuate taint rules
+const (
+	TaintEffectNoSchedule       = "NoSchedule"
+	TaintEffectPreferNoSchedule = "PreferNoSchedule"
+	TaintEffectNoExecute        = "NoExecute"
+)
+
 func (flags *NodeAddressType) String() string {
 	return string(*flags)
 }